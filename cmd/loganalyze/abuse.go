@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"iter"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/abuse"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/shuffle"
+)
+
+func runAbuse(args []string) error {
+	flags := flag.NewFlagSet("abuse", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines parsing files")
+	partitions := flags.Int("partitions", runtime.GOMAXPROCS(0), "Number of goroutines scanning disjoint sets of clients")
+	rate := flags.Float64("rate", 5, "Requests per second a client may sustain over the rate window (0 disables)")
+	rateWindow := flags.Duration("rate-window", 10*time.Second, "Sliding window of the rate limit")
+	authFailures := flags.Int("auth-failures", 10, "401/403 responses to the login path a client may get within the auth window (0 disables)")
+	authWindow := flags.Duration("auth-window", time.Minute, "Sliding window of the authentication failure limit")
+	authPath := flags.String("auth-path", "/api/auth/login", "Path template of the login endpoint")
+	top := flags.Int("top", 20, "Offenders listed")
+	maxViolations := flags.Int("violations", 3, "Violation windows listed per offender")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	if *rateWindow < time.Millisecond || *authWindow < time.Millisecond {
+		return fmt.Errorf("-rate-window and -auth-window must be at least 1ms")
+	}
+	if *top < 0 || *maxViolations < 0 {
+		return fmt.Errorf("-top and -violations must not be negative, got %d and %d", *top, *maxViolations)
+	}
+	config := abuse.Config{
+		Rate:         *rate,
+		RateWindow:   *rateWindow,
+		AuthFailures: *authFailures,
+		AuthWindow:   *authWindow,
+		AuthPath:     *authPath,
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	startTime := time.Now()
+	offenders := detectAbuse(root, files, config, max(*workers, 1), shuffle.DefaultConfig(max(*partitions, 1)))
+	printOffenders(config, offenders, *top, *maxViolations, time.Since(startTime))
+	return nil
+}
+
+// abuseColumns are the fields of the entries that abuse.Keys and
+// abuse.Config.Event use.
+var abuseColumns = segment.Of(segment.Timestamp, segment.PathTemplate, segment.Status, segment.IP, segment.UserID)
+
+// detectAbuse applies the rules to every client of all files.
+//
+// Like sessions, the requests of a client span files, so parser workers
+// shuffle every event to the partition that owns its key, once for its IP
+// and once for its user. Each partition sorts and scans the streams of its
+// keys independently.
+func detectAbuse(root *os.Root, files []string, config abuse.Config, numWorkers int, shuffleConfig shuffle.Config) []abuse.Offender {
+	partials := make([][]abuse.Offender, shuffleConfig.Partitions)
+	events := shuffle.New(shuffleConfig, func(p int, records iter.Seq[shuffle.Record[abuse.Key, abuse.Event]]) {
+		detector := abuse.NewDetector(config)
+		for record := range records {
+			detector.Add(record.Key, record.Value)
+		}
+		partials[p] = detector.Result()
+	})
+
+	jobs := make(chan string, numWorkers)
+	var mappers sync.WaitGroup
+	for range numWorkers {
+		mappers.Go(func() {
+			emitter := events.NewEmitter()
+			defer emitter.Flush()
+
+			for filename := range jobs {
+				err := engine.ScanColumns(root, filename, abuseColumns, func(entry *logparser.LogEntry) {
+					event, err := config.Event(entry)
+					if err != nil {
+						return
+					}
+					for _, key := range abuse.Keys(entry) {
+						emitter.Emit(key, event)
+					}
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
+				}
+			}
+		})
+	}
+
+	for _, filename := range files {
+		jobs <- filename
+	}
+	close(jobs)
+
+	// The shuffle can only be closed once every mapper has flushed.
+	mappers.Wait()
+	events.Close()
+
+	var offenders []abuse.Offender
+	for _, partial := range partials {
+		offenders = append(offenders, partial...)
+	}
+	abuse.SortOffenders(offenders)
+	return offenders
+}
+
+func printOffenders(config abuse.Config, offenders []abuse.Offender, top, maxViolations int, elapsed time.Duration) {
+	fmt.Printf("\n=== Abusive Clients ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	if config.Rate > 0 {
+		fmt.Printf("Rate limit: %g requests/s over %s\n", config.Rate, config.RateWindow)
+	}
+	if config.AuthFailures > 0 {
+		fmt.Printf("Auth limit: %d failures on %s within %s\n", config.AuthFailures, config.AuthPath, config.AuthWindow)
+	}
+	fmt.Printf("Offenders: %d\n", len(offenders))
+
+	const layout = "2006-01-02 15:04:05"
+	for _, o := range offenders[:min(len(offenders), top)] {
+		fmt.Printf("\n[%s] %s: peak %d in %s (limit %d), %s matching requests, %d violations\n",
+			o.Rule, o.Key, o.Peak, o.Window, o.Limit, formatNumber(o.Events), len(o.Violations))
+		for _, v := range o.Violations[:min(len(o.Violations), maxViolations)] {
+			fmt.Printf("  %s - %s UTC (%s): %s requests, peak %d\n",
+				v.Start.Format(layout), v.End.Format("15:04:05"), v.End.Sub(v.Start).Round(time.Second), formatNumber(v.Events), v.Peak)
+		}
+		if len(o.Violations) > maxViolations {
+			fmt.Printf("  ... %d more\n", len(o.Violations)-maxViolations)
+		}
+	}
+	if len(offenders) > top {
+		fmt.Printf("\n... %d more offenders\n", len(offenders)-top)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/anomaly"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+)
+
+func runAnomalies(args []string) error {
+	flags := flag.NewFlagSet("anomalies", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines parsing files")
+	window := flags.Int("window", 60, "Minutes of the trailing baseline")
+	threshold := flags.Float64("threshold", 5, "Robust z-score that flags a minute (twice as much is critical)")
+	minRequests := flags.Int("min-requests", 20, "Requests a minute needs for its 5xx ratio and p99 to be scored")
+	top := flags.Int("top", 3, "Contributing paths listed per window")
+	timeRange := registerTimeRange(flags)
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	r, err := timeRange.parse()
+	if err != nil {
+		return err
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	startTime := time.Now()
+	series, stats := bucketMinutes(root, files, r, max(*workers, 1))
+	windows := anomaly.Detect(series, anomaly.Config{
+		Window:      *window,
+		Threshold:   *threshold,
+		MinRequests: *minRequests,
+		TopPaths:    *top,
+	})
+	printAnomalies(series, windows, time.Since(startTime))
+	printScanStats(r, stats)
+	return nil
+}
+
+// seriesColumns are the fields of the entries that logparser.Series uses.
+var seriesColumns = segment.Of(segment.Timestamp, segment.PathTemplate, segment.Status, segment.ResponseTime)
+
+// bucketMinutes builds the per-minute series of the requests of all files
+// in r. Every worker fills its own Series and the partial series are merged
+// at the end, like Results.
+func bucketMinutes(root *os.Root, files []string, r engine.TimeRange, numWorkers int) (*logparser.Series, engine.ScanStats) {
+	jobs := make(chan string, numWorkers)
+	partials := make([]*logparser.Series, numWorkers)
+	scanned := make([]engine.ScanStats, numWorkers)
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Go(func() {
+			series := logparser.NewSeries()
+			for filename := range jobs {
+				stats, err := engine.ScanRange(root, filename, seriesColumns, r, func(entry *logparser.LogEntry) {
+					series.Add(entry) // entries without a valid timestamp are skipped
+				})
+				scanned[w].Add(stats)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
+				}
+			}
+			partials[w] = series
+		})
+	}
+
+	for _, filename := range files {
+		jobs <- filename
+	}
+	close(jobs)
+	wg.Wait()
+
+	series := partials[0]
+	for _, partial := range partials[1:] {
+		series.Merge(partial)
+	}
+	for _, stats := range scanned[1:] {
+		scanned[0].Add(stats)
+	}
+	return series, scanned[0]
+}
+
+func printAnomalies(series *logparser.Series, windows []anomaly.Window, elapsed time.Duration) {
+	fmt.Printf("\n=== Anomalies ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	first, last, ok := series.Span()
+	if !ok {
+		fmt.Printf("No requests\n")
+		return
+	}
+	const layout = "2006-01-02 15:04"
+	fmt.Printf("Minutes: %s (%s to %s UTC)\n", formatNumber(int(last-first+1)),
+		time.Unix(first*60, 0).UTC().Format(layout), time.Unix(last*60, 0).UTC().Format(layout))
+	fmt.Printf("Flagged windows: %d\n", len(windows))
+
+	for _, w := range windows {
+		fmt.Printf("\n[%s] %s - %s UTC (%s)\n", w.Severity, w.Start.Format(layout), w.End.Format("15:04"), w.End.Sub(w.Start))
+		for _, s := range w.Signals {
+			fmt.Printf("  %-12s %10s at %s, baseline %s, z=%+.1f\n",
+				s.Metric, s.Metric.Format(s.Value), s.At.Format("15:04"), s.Metric.Format(s.Baseline), s.Score)
+		}
+		if len(w.TopPaths) == 0 {
+			continue
+		}
+		unit := map[anomaly.Metric]string{
+			anomaly.Requests:   "requests",
+			anomaly.ErrorRatio: "5xx responses",
+			anomaly.P99Latency: "ms of response time",
+		}[w.Signals[0].Metric]
+		var paths []string
+		for _, c := range w.TopPaths {
+			paths = append(paths, fmt.Sprintf("%s %+.0f (%.0f%%)", c.Path, c.Excess, c.Share*100))
+		}
+		fmt.Printf("  Top paths by %s vs baseline: %s\n", unit, strings.Join(paths, ", "))
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+)
+
+func runConvert(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Directory of the JSON logs")
+	outDir := flags.String("out", "./logs-seg", "Directory of the segments (created if needed)")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines converting files")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	if absLogs, absOut := absPath(*logDir), absPath(*outDir); absLogs == absOut {
+		return fmt.Errorf("-out must differ from -logs")
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	var jsonFiles []string
+	for _, name := range files {
+		if !engine.IsSegment(name) {
+			jsonFiles = append(jsonFiles, name)
+		}
+	}
+	if err := checkSegmentNames(jsonFiles); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	out, err := os.OpenRoot(*outDir)
+	if err != nil {
+		return fmt.Errorf("failed to open output directory: %w", err)
+	}
+	defer out.Close()
+
+	startTime := time.Now()
+	stats, err := convertFiles(root, out, jsonFiles, max(*workers, 1))
+	printConversion(stats, time.Since(startTime))
+	return err
+}
+
+func absPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	return abs
+}
+
+// conversion sums up the files converted.
+type conversion struct {
+	files, rows       int
+	inBytes, outBytes int64
+	// columns holds the total size of every stored column, in file order.
+	columns []segment.ColumnInfo
+}
+
+func (c *conversion) add(other *conversion) {
+	c.files += other.files
+	c.rows += other.rows
+	c.inBytes += other.inBytes
+	c.outBytes += other.outBytes
+	c.addColumns(other.columns)
+}
+
+func (c *conversion) addColumns(columns []segment.ColumnInfo) {
+	if c.columns == nil {
+		c.columns = make([]segment.ColumnInfo, len(columns))
+	}
+	for i, info := range columns {
+		c.columns[i].Column = info.Column
+		c.columns[i].Size += info.Size
+	}
+}
+
+// convertFiles writes every file as a segment of the same name with the
+// segment extension. Each worker converts whole files and sums up its own
+// conversions, which are added together at the end.
+func convertFiles(root, out *os.Root, files []string, numWorkers int) (*conversion, error) {
+	jobs := make(chan int, numWorkers)
+	partials := make([]*conversion, numWorkers)
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Go(func() {
+			partial := &conversion{}
+			for i := range jobs {
+				if err := convertFile(root, out, files[i], partial); err != nil {
+					errs[i] = fmt.Errorf("failed to convert %s: %w", files[i], err)
+				}
+			}
+			partials[w] = partial
+		})
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	total := &conversion{}
+	for _, partial := range partials {
+		total.add(partial)
+	}
+	return total, errors.Join(errs...)
+}
+
+// convertFile decodes a file into memory, encodes it and writes it under a
+// temporary name that is renamed once complete, so that an interrupted
+// conversion never leaves a partial segment behind.
+func convertFile(root, out *os.Root, filename string, stats *conversion) error {
+	var entries []logparser.LogEntry
+	err := engine.ScanFile(root, filename, func(entry *logparser.LogEntry) {
+		entries = append(entries, *entry)
+	})
+	if err != nil {
+		return err
+	}
+	data := segment.Encode(entries)
+
+	name := segmentName(filename)
+	if err := out.WriteFile(name+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	if err := out.Rename(name+".tmp", name); err != nil {
+		out.Remove(name + ".tmp")
+		return err
+	}
+
+	info, err := root.Stat(filename)
+	if err != nil {
+		return err
+	}
+	file, err := segment.NewFile(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	stats.files++
+	stats.rows += len(entries)
+	stats.inBytes += info.Size()
+	stats.outBytes += int64(len(data))
+	stats.addColumns(file.Header.Columns)
+	return nil
+}
+
+// checkSegmentNames reports files that would be converted to the same
+// segment, such as access_1.json and access_1.json.gz, before any worker
+// overwrites the segment of one with the other.
+func checkSegmentNames(files []string) error {
+	sources := make(map[string]string, len(files))
+	var errs []error
+	for _, name := range files {
+		seg := segmentName(name)
+		if other, ok := sources[seg]; ok {
+			errs = append(errs, fmt.Errorf("%s and %s would both be converted to %s", other, name, seg))
+			continue
+		}
+		sources[seg] = name
+	}
+	return errors.Join(errs...)
+}
+
+// segmentName returns the name of the segment of a JSON log file.
+func segmentName(filename string) string {
+	name := strings.TrimSuffix(filename, ".gz")
+	return strings.TrimSuffix(name, ".json") + segment.Ext
+}
+
+func printConversion(c *conversion, elapsed time.Duration) {
+	fmt.Printf("\n=== Segment Conversion ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	fmt.Printf("Files: %d, rows: %s\n", c.files, formatNumber(c.rows))
+	fmt.Printf("Size: %s -> %s bytes (%.1fx smaller)\n",
+		formatNumber(int(c.inBytes)), formatNumber(int(c.outBytes)), float64(c.inBytes)/float64(max(c.outBytes, 1)))
+	if c.rows == 0 {
+		return
+	}
+
+	fmt.Printf("\n%-18s %14s %12s\n", "Column", "Bytes", "Bytes/row")
+	for _, info := range c.columns {
+		fmt.Printf("%-18s %14s %12.2f\n", info.Column, formatNumber(int(info.Size)), float64(info.Size)/float64(c.rows))
+	}
+}
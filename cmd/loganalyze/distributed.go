@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/dist"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+)
+
+// workerGrace is how long the coordinator waits for the workers to
+// disconnect once every task is completed.
+const workerGrace = 5 * time.Second
+
+// runCoordinate lists the log files and leases them, or ranges of them, to
+// `loganalyze work` processes, optionally spawning them locally.
+func runCoordinate(args []string) error {
+	flags := flag.NewFlagSet("coordinate", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	listen := flags.String("listen", "127.0.0.1:0", "Address to listen on for workers")
+	spawn := flags.Int("spawn", 0, "Number of local worker processes to start (0 waits for external workers)")
+	taskKB := flags.Int("task", 0, "Lease ranges of this many KB instead of whole files (0 leases files)")
+	ttl := flags.Duration("lease", dist.DefaultLeaseTTL, "Lease duration; a lease not renewed in time is reassigned")
+	abandonAfter := flags.Int("abandon-after", 0, "Make the first spawned worker exit while holding its Nth lease (for testing reassignment)")
+	outPath := flags.String("out", "", "Write the results to this file for `loganalyze merge` (.json for JSON, binary otherwise)")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	opts := engine.Options{Schedule: engine.ScheduleLargest}
+	if *taskKB > 0 {
+		opts.Schedule, opts.TaskSize = engine.ScheduleSteal, int64(*taskKB)*1024
+	}
+	tasks, err := engine.PlanTasks(root, files, opts)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	coordinator := dist.NewCoordinator(files, tasks, dist.Config{
+		LeaseTTL: *ttl,
+		Logger:   log.New(os.Stderr, "coordinator: ", 0),
+	})
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- coordinator.Serve(listener) }()
+	defer listener.Close()
+
+	addr := listener.Addr().String()
+	fmt.Printf("Coordinating %d tasks over %d files on %s\n", len(tasks), len(files), addr)
+
+	startTime := time.Now()
+	workersExited := make(chan struct{})
+	var workers sync.WaitGroup
+	if *spawn > 0 {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		for i := range *spawn {
+			workerArgs := []string{"work", "-coordinator", addr, "-logs", *logDir, "-name", "worker-" + strconv.Itoa(i)}
+			if i == 0 && *abandonAfter > 0 {
+				workerArgs = append(workerArgs, "-abandon-after", strconv.Itoa(*abandonAfter))
+			}
+			cmd := exec.Command(self, workerArgs...)
+			cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+			if err := cmd.Start(); err != nil {
+				return err
+			}
+			workers.Go(func() { cmd.Wait() })
+		}
+		go func() {
+			workers.Wait()
+			close(workersExited)
+		}()
+	}
+
+	select {
+	case <-coordinator.Done():
+	case err := <-serveErr:
+		return err
+	case <-workersExited:
+		// Completing the last task and exiting race with each other.
+		if left := coordinator.Remaining(); left > 0 {
+			return fmt.Errorf("every worker exited with %d tasks left", left)
+		}
+	}
+	elapsed := time.Since(startTime)
+
+	if !coordinator.WaitDisconnect(workerGrace) {
+		fmt.Fprintln(os.Stderr, "Warning: some workers did not disconnect")
+	}
+	workers.Wait()
+
+	results, err := coordinator.Results()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error processing files: %v\n", err)
+	}
+	leases, expired := coordinator.Stats()
+	fmt.Printf("Leases: %d granted, %d expired and reassigned\n", leases, expired)
+	printStatus(logparser.MergeResults(results), elapsed)
+
+	if *outPath != "" {
+		if err := writeResultSet(*outPath, logparser.NewResultSet(results)); err != nil {
+			return err
+		}
+		fmt.Printf("\nResults written to %s\n", *outPath)
+	}
+	return nil
+}
+
+// runWork processes the tasks leased by a coordinator until all are done.
+func runWork(args []string) error {
+	flags := flag.NewFlagSet("work", flag.ExitOnError)
+	addr := flags.String("coordinator", "", "Address of the coordinator")
+	logDir := flags.String("logs", "./logs", "Log directory (the same files as the coordinator's)")
+	readerName := flags.String("reader", engine.ReaderBuffered, "File reader backend: buffered or mmap")
+	name := flags.String("name", "", "Worker name in logs (default host:pid)")
+	abandonAfter := flags.Int("abandon-after", 0, "Exit while holding the Nth lease, as if the process died (for testing)")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	if *addr == "" {
+		return errors.New("-coordinator is required")
+	}
+	if *name == "" {
+		host, _ := os.Hostname()
+		*name = host + ":" + strconv.Itoa(os.Getpid())
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	reader, err := engine.NewReader(*readerName, engine.DefaultChunkSize)
+	if err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(*logDir)
+	if err != nil {
+		return fmt.Errorf("failed to open log directory: %w", err)
+	}
+	defer root.Close()
+
+	completed, err := dist.Work(*addr, root, dist.WorkerConfig{
+		Name:         *name,
+		Reader:       reader,
+		AbandonAfter: *abandonAfter,
+	})
+	if errors.Is(err, dist.ErrAbandoned) {
+		fmt.Fprintf(os.Stderr, "%s: exiting after %d tasks, abandoning a lease\n", *name, completed)
+		// os.Exit skips the deferred calls.
+		stopProfiling()
+		os.Exit(3)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s: completed %d tasks\n", *name, completed)
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+)
+
+func runIndex(args []string) error {
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines indexing files")
+	interval := flags.Int("interval", engine.DefaultIndexInterval, "Lines per indexed block")
+	force := flags.Bool("force", false, "Rebuild indexes that are up to date")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	var jsonFiles []string
+	for _, name := range files {
+		if !engine.IsSegment(name) {
+			jsonFiles = append(jsonFiles, name)
+		}
+	}
+
+	startTime := time.Now()
+	built, err := buildIndexes(root, jsonFiles, max(*interval, 1), *force, max(*workers, 1))
+	fmt.Printf("\n=== Index ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", time.Since(startTime).Seconds())
+	fmt.Printf("Files: %d (%d indexed, %d up to date)\n", len(jsonFiles), built, len(jsonFiles)-built)
+	return err
+}
+
+// buildIndexes writes the index of every file that has none, or a stale
+// one, with a worker per file. It returns the number of indexes written.
+func buildIndexes(root *os.Root, files []string, interval int, force bool, numWorkers int) (int, error) {
+	jobs := make(chan int, numWorkers)
+	errs := make([]error, len(files))
+	written := make([]bool, len(files))
+	var wg sync.WaitGroup
+	for range numWorkers {
+		wg.Go(func() {
+			for i := range jobs {
+				written[i], errs[i] = indexFile(root, files[i], interval, force)
+			}
+		})
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	built := 0
+	for _, ok := range written {
+		if ok {
+			built++
+		}
+	}
+	return built, errors.Join(errs...)
+}
+
+func indexFile(root *os.Root, name string, interval int, force bool) (bool, error) {
+	if !force {
+		if ix, err := engine.LoadIndex(root, name); err == nil && ix.Interval == interval {
+			return false, nil
+		}
+	}
+	ix, err := engine.BuildIndex(root, name, interval)
+	if err != nil {
+		return false, fmt.Errorf("failed to index %s: %w", name, err)
+	}
+	if err := engine.SaveIndex(root, name, ix); err != nil {
+		return false, fmt.Errorf("failed to save the index of %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// timeRangeFlags are the -from and -to flags restricting an analysis to a
+// period.
+type timeRangeFlags struct {
+	from, to *string
+}
+
+func registerTimeRange(flags *flag.FlagSet) *timeRangeFlags {
+	return &timeRangeFlags{
+		from: flags.String("from", "", "Only analyze requests at or after this RFC 3339 time, e.g. 2025-01-11T03:00:00Z"),
+		to:   flags.String("to", "", "Only analyze requests before this RFC 3339 time"),
+	}
+}
+
+func (f *timeRangeFlags) parse() (engine.TimeRange, error) {
+	var r engine.TimeRange
+	var err error
+	if *f.from != "" {
+		if r.From, err = time.Parse(time.RFC3339Nano, *f.from); err != nil {
+			return r, fmt.Errorf("invalid -from: %w", err)
+		}
+	}
+	if *f.to != "" {
+		if r.To, err = time.Parse(time.RFC3339Nano, *f.to); err != nil {
+			return r, fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return r, fmt.Errorf("-from must be before -to")
+	}
+	return r, nil
+}
+
+// printScanStats tells how much of the logs a time-range scan could skip.
+func printScanStats(r engine.TimeRange, stats engine.ScanStats) {
+	if r.IsZero() {
+		return
+	}
+	fmt.Printf("Time range: %s to %s\n", formatBound(r.From, "start"), formatBound(r.To, "end"))
+	fmt.Printf("Read: %s of %s bytes (%.1f%%); %d of %d files indexed, %d skipped\n",
+		formatNumber(int(stats.Read)), formatNumber(int(stats.Size)),
+		float64(stats.Read)/float64(max(stats.Size, 1))*100, stats.Indexed, stats.Files, stats.Skipped)
+}
+
+func formatBound(t time.Time, open string) string {
+	if t.IsZero() {
+		return open
+	}
+	return t.UTC().Format("2006-01-02 15:04:05 UTC")
+}
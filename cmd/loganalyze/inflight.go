@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/inflight"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+)
+
+func runInflight(args []string) error {
+	flags := flag.NewFlagSet("inflight", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines parsing files")
+	interval := flags.Duration("interval", 0, "Report the peak of every interval of this length (0 disables)")
+	top := flags.Int("top", 20, "Path templates listed, by peak concurrency")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	if *top < 0 {
+		return fmt.Errorf("-top must not be negative, got %d", *top)
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	startTime := time.Now()
+	streams := collectEvents(root, files, max(*workers, 1))
+	report := inflight.Sweep(streams, inflight.Config{Interval: *interval})
+	printInflight(report, *top, *interval, time.Since(startTime))
+	return nil
+}
+
+// inflightColumns are the fields of the entries that inflight.Events uses.
+var inflightColumns = segment.Of(segment.Timestamp, segment.PathTemplate, segment.ResponseTime)
+
+// collectEvents turns the requests of all files into interval events.
+// Every worker fills and sorts its own stream; inflight.Sweep merges them.
+func collectEvents(root *os.Root, files []string, numWorkers int) []*inflight.Events {
+	jobs := make(chan string, numWorkers)
+	streams := make([]*inflight.Events, numWorkers)
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Go(func() {
+			events := inflight.NewEvents()
+			for filename := range jobs {
+				err := engine.ScanColumns(root, filename, inflightColumns, func(entry *logparser.LogEntry) {
+					events.Add(entry) // entries without a valid timestamp are skipped
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
+				}
+			}
+			events.Sort()
+			streams[w] = events
+		})
+	}
+
+	for _, filename := range files {
+		jobs <- filename
+	}
+	close(jobs)
+	wg.Wait()
+	return streams
+}
+
+func printInflight(r *inflight.Report, top int, interval, elapsed time.Duration) {
+	fmt.Printf("\n=== In-flight Requests ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	if r.Start.IsZero() {
+		fmt.Printf("No requests\n")
+		return
+	}
+	const layout = "2006-01-02 15:04:05.000"
+	fmt.Printf("Period: %s to %s UTC\n", r.Start.Format(layout), r.End.Format(layout))
+	fmt.Printf("Time-weighted percentiles: the concurrency not exceeded during that share of the period\n\n")
+
+	header := fmt.Sprintf("%-24s %10s %7s", "Path", "Requests", "Mean")
+	for _, p := range r.Overall.Percentiles {
+		header += fmt.Sprintf(" %6s", formatQuantile(p.Quantile))
+	}
+	fmt.Printf("%s %6s  %s\n", header, "Max", "Max at (UTC)")
+	fmt.Printf("%s\n", strings.Repeat("-", len(header)+33))
+
+	printStats := func(name string, s inflight.Stats) {
+		line := fmt.Sprintf("%-24s %10s %7.2f", name, formatNumber(s.Requests), s.Mean)
+		for _, p := range s.Percentiles {
+			line += fmt.Sprintf(" %6d", p.Value)
+		}
+		fmt.Printf("%s %6d  %s\n", line, s.Max, s.MaxAt.Format(layout))
+	}
+	printStats("(all)", r.Overall)
+	for _, s := range r.Paths[:min(len(r.Paths), top)] {
+		printStats(s.Path, s)
+	}
+	if len(r.Paths) > top {
+		fmt.Printf("... %d more paths\n", len(r.Paths)-top)
+	}
+
+	if len(r.Intervals) == 0 {
+		return
+	}
+	fmt.Printf("\nPeak per interval:\n")
+	for i, p := range r.Intervals {
+		// Intervals without events after the first are not reported.
+		if i > 0 && p.Start.Sub(r.Intervals[i-1].Start) > interval {
+			fmt.Printf("  ...\n")
+		}
+		fmt.Printf("  %s  %6d  at %s\n", p.Start.Format("2006-01-02 15:04:05"), p.Max, p.At.Format("15:04:05.000"))
+	}
+}
+
+// formatQuantile formats 0.99 as "p99" and 0.999 as "p99.9".
+func formatQuantile(q float64) string {
+	return "p" + strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", q*100), "0"), ".")
+}
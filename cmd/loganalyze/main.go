@@ -0,0 +1,96 @@
+// loganalyze runs analyses over the access logs that need more than the
+// per-file Result of the workshop phases. Logs are JSON lines or columnar
+// segments written by the convert command.
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+)
+
+// command is a loganalyze subcommand.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"abuse":      {"Find clients exceeding a sliding-window rate or auth failure limit", runAbuse},
+	"anomalies":  {"Flag unusual minutes of request rate, 5xx ratio and p99 latency", runAnomalies},
+	"convert":    {"Convert JSON logs to columnar segments for faster repeated analysis", runConvert},
+	"coordinate": {"Lease files to worker processes and merge their results", runCoordinate},
+	"index":      {"Build sparse timestamp indexes that let -from/-to skip parts of the logs", runIndex},
+	"inflight":   {"Reconstruct in-flight concurrency overall and per path with a sweep line", runInflight},
+	"merge":      {"Merge the result files of separate status runs", runMerge},
+	"slo":        {"Evaluate per-endpoint SLOs: compliance, error budget and burn rates", runSLO},
+	"sessions":   {"Reconstruct user sessions and measure funnel conversion", runSessions},
+	"sort":       {"Write all entries sorted by timestamp with an external merge sort", runSort},
+	"subnets":    {"Group clients by IP prefix or by the labels of a CIDR table", runSubnets},
+	"status":     {"Count status codes with the engine's reader and worker pool", runStatus},
+	"work":       {"Process the files leased by a coordinator", runWork},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: loganalyze <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+}
+
+// openLogs opens the log directory and lists its log files.
+func openLogs(dir string) (*os.Root, []string, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open log directory: %w", err)
+	}
+
+	files, err := engine.ListLogFiles(root)
+	if err != nil {
+		root.Close()
+		return nil, nil, err
+	}
+	return root, files, nil
+}
+
+// formatNumber formats n with thousands separators.
+func formatNumber(n int) string {
+	s := fmt.Sprintf("%d", n)
+	result := ""
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			result += ","
+		}
+		result += string(c)
+	}
+	return result
+}
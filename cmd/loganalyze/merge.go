@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// runMerge combines the result files written by `status -out`, for example
+// on several hosts that each analyzed a shard of the logs.
+func runMerge(args []string) error {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := flags.String("out", "", "Write the merged results to this file (.json for JSON, binary otherwise)")
+	verify := flags.String("verify", "", "Check that the merged totals equal a single run over this log directory")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: loganalyze merge [flags] <result file>...")
+		fmt.Fprintln(os.Stderr, "")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	startTime := time.Now()
+	var results []*logparser.Result
+	seen := make(map[string]string)
+	for _, path := range flags.Args() {
+		set, err := readResultSet(path)
+		if err != nil {
+			return err
+		}
+		// A file analyzed by two shards would be counted twice.
+		for _, r := range set.Results {
+			if other, ok := seen[r.FileName]; ok {
+				return fmt.Errorf("%s is in both %s and %s", r.FileName, other, path)
+			}
+			seen[r.FileName] = path
+		}
+		results = append(results, set.Results...)
+		fmt.Printf("%s: %d files, %s requests\n", path, set.Total.FileCount, formatNumber(set.Total.TotalCount))
+	}
+	slices.SortFunc(results, func(a, b *logparser.Result) int { return strings.Compare(a.FileName, b.FileName) })
+	merged := logparser.NewResultSet(results)
+
+	if *out != "" {
+		if err := writeResultSet(*out, merged); err != nil {
+			return err
+		}
+		fmt.Printf("Merged results written to %s\n", *out)
+	}
+
+	printStatus(merged.Total, time.Since(startTime))
+
+	if *verify != "" {
+		return verifyMerged(*verify, merged)
+	}
+	return nil
+}
+
+// verifyMerged analyzes dir in a single run and compares it with merged.
+func verifyMerged(dir string, merged *logparser.ResultSet) error {
+	root, files, err := openLogs(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	startTime := time.Now()
+	results, err := engine.Run(root, files, engine.Options{})
+	if err != nil {
+		return err
+	}
+	single := logparser.MergeResults(results)
+
+	var missing []string
+	for _, name := range files {
+		if !slices.ContainsFunc(merged.Results, func(r *logparser.Result) bool { return r.FileName == name }) {
+			missing = append(missing, name)
+		}
+	}
+
+	fmt.Printf("\n=== Verification (single run over %s in %.2fs) ===\n", dir, time.Since(startTime).Seconds())
+	if len(missing) > 0 {
+		return fmt.Errorf("verification failed: %d files of %s are in no result file: %s",
+			len(missing), dir, strings.Join(missing, ", "))
+	}
+	if !merged.Total.Equal(single) {
+		return fmt.Errorf("verification failed: merged %d files with %d requests, single run %d files with %d requests",
+			merged.Total.FileCount, merged.Total.TotalCount, single.FileCount, single.TotalCount)
+	}
+	fmt.Printf("OK: merged totals equal a single run over %d files\n", single.FileCount)
+	return nil
+}
+
+// readResultSet reads a result file in either encoding.
+func readResultSet(path string) (*logparser.ResultSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set, err := logparser.DecodeResultSet(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return set, nil
+}
+
+// writeResultSet writes a result file, as JSON if path ends in .json and in
+// the binary encoding otherwise.
+func writeResultSet(path string, set *logparser.ResultSet) error {
+	var data []byte
+	var err error
+	if filepath.Ext(path) == ".json" {
+		data, err = json.MarshalIndent(set, "", "  ")
+	} else {
+		data, err = set.MarshalBinary()
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// parseShard parses a -shard flag "i/n" into its index and count.
+func parseShard(s string) (index, count int, err error) {
+	i, n, ok := strings.Cut(s, "/")
+	if ok {
+		index, err = strconv.Atoi(i)
+		if err == nil {
+			count, err = strconv.Atoi(n)
+		}
+	}
+	if !ok || err != nil || count < 1 || index < 0 || index >= count {
+		return 0, 0, fmt.Errorf("invalid shard %q (want i/n with 0 <= i < n)", s)
+	}
+	return index, count, nil
+}
+
+// shardFiles returns the files of shard index out of count, dealt in turn.
+func shardFiles(files []string, index, count int) []string {
+	var shard []string
+	for i, name := range files {
+		if i%count == index {
+			shard = append(shard, name)
+		}
+	}
+	return shard
+}
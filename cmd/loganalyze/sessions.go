@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"iter"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/shuffle"
+)
+
+const defaultFunnel = "/api/auth/login,/api/products/{id},/api/orders"
+
+func runSessions(args []string) error {
+	flags := flag.NewFlagSet("sessions", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines parsing files")
+	partitions := flags.Int("partitions", runtime.GOMAXPROCS(0), "Number of goroutines sessionizing disjoint sets of users")
+	batchSize := flags.Int("batch", shuffle.DefaultConfig(1).BatchSize, "Events per batch sent to a partition")
+	gap := flags.Duration("gap", 30*time.Minute, "Inactivity gap that ends a session")
+	funnelSpec := flags.String("funnel", defaultFunnel, "Comma separated funnel steps, optionally prefixed by a method (\"POST /api/orders\")")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	funnel, err := logparser.ParseFunnel(*funnelSpec)
+	if err != nil {
+		return err
+	}
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	startTime := time.Now()
+	config := logparser.SessionConfig{Gap: *gap, Funnel: funnel}
+	shuffleConfig := shuffle.DefaultConfig(max(*partitions, 1))
+	shuffleConfig.BatchSize = *batchSize
+	stats := analyzeSessions(root, files, config, max(*workers, 1), shuffleConfig)
+	printSessionStats(stats, time.Since(startTime))
+	return nil
+}
+
+// sessionColumns are the fields of the entries that sessions are built
+// from.
+var sessionColumns = segment.Of(segment.Timestamp, segment.Method, segment.PathTemplate, segment.UserID)
+
+// analyzeSessions sessionizes all files.
+//
+// Users span files, so per-file results cannot be merged like Result.
+// Instead parser workers shuffle every event to the partition that owns its
+// user. Each partition sessionizes its users independently and only the
+// small SessionStats are merged.
+func analyzeSessions(root *os.Root, files []string, config logparser.SessionConfig, numWorkers int, shuffleConfig shuffle.Config) *logparser.SessionStats {
+	partials := make([]*logparser.SessionStats, shuffleConfig.Partitions)
+	events := shuffle.New(shuffleConfig, func(p int, records iter.Seq[shuffle.Record[string, logparser.SessionEvent]]) {
+		sessionizer := logparser.NewSessionizer(config)
+		for record := range records {
+			sessionizer.AddEvent(record.Key, record.Value)
+		}
+		partials[p] = sessionizer.Result()
+	})
+
+	jobs := make(chan string, numWorkers)
+	var mappers sync.WaitGroup
+	for range numWorkers {
+		mappers.Go(func() {
+			emitter := events.NewEmitter()
+			defer emitter.Flush()
+
+			for filename := range jobs {
+				err := engine.ScanColumns(root, filename, sessionColumns, func(entry *logparser.LogEntry) {
+					event, err := config.Event(entry)
+					if err != nil {
+						return
+					}
+					emitter.Emit(entry.UserID, event)
+				})
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
+				}
+			}
+		})
+	}
+
+	for _, filename := range files {
+		jobs <- filename
+	}
+	close(jobs)
+
+	// The shuffle can only be closed once every mapper has flushed.
+	mappers.Wait()
+	events.Close()
+
+	stats := logparser.NewSessionStats(config.Funnel)
+	for _, partial := range partials {
+		stats.Merge(partial)
+	}
+	return stats
+}
+
+func printSessionStats(stats *logparser.SessionStats, elapsed time.Duration) {
+	fmt.Printf("\n=== Sessions ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	fmt.Printf("Users: %s\n", formatNumber(stats.Users))
+	fmt.Printf("Sessions: %s\n", formatNumber(stats.Sessions))
+	fmt.Printf("Requests: %s\n", formatNumber(stats.Requests))
+	fmt.Printf("Pages per session: %.2f\n", stats.PagesPerSession())
+	fmt.Printf("Average duration: %s\n", stats.AvgDuration().Round(time.Second))
+	fmt.Printf("Max duration: %s\n", stats.MaxDuration.Round(time.Second))
+	if stats.Sessions > 0 {
+		fmt.Printf("Bounce rate: %.2f%%\n", float64(stats.Bounces)/float64(stats.Sessions)*100)
+	}
+
+	if len(stats.Funnel) == 0 {
+		return
+	}
+	fmt.Printf("\nFunnel:\n")
+	for i, step := range stats.Funnel {
+		fmt.Printf("  %d. %-28s %12s sessions (%.2f%%)\n",
+			i+1, step, formatNumber(stats.FunnelReached[i]), stats.Conversion(i))
+	}
+	if stats.Sessions > 0 {
+		last := stats.FunnelReached[len(stats.Funnel)-1]
+		fmt.Printf("  Overall conversion: %.2f%%\n", float64(last)/float64(stats.Sessions)*100)
+	}
+}
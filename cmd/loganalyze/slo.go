@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/slo"
+)
+
+func runSLO(args []string) error {
+	flags := flag.NewFlagSet("slo", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	sloPath := flags.String("slo", "cmd/loganalyze/slos/example.json", "SLO file (JSON)")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines parsing files")
+	episodes := flags.Int("episodes", 5, "Alert episodes listed per rule")
+	timeRange := registerTimeRange(flags)
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	if *episodes < 0 {
+		return fmt.Errorf("-episodes must not be negative, got %d", *episodes)
+	}
+	r, err := timeRange.parse()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := slo.Load(*sloPath)
+	if err != nil {
+		return err
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	startTime := time.Now()
+	tracker, stats := trackObjectives(root, files, cfg, r, max(*workers, 1))
+	printSLOReport(tracker.Report(), *episodes, time.Since(startTime))
+	printScanStats(r, stats)
+	return nil
+}
+
+// sloColumns are the fields of the entries that slo.Tracker uses.
+var sloColumns = segment.Of(segment.Timestamp, segment.Method, segment.PathTemplate, segment.Status, segment.ResponseTime)
+
+// trackObjectives counts the requests in r of every objective per minute.
+// Like bucketMinutes, every worker fills its own Tracker and the partial
+// trackers are merged at the end.
+func trackObjectives(root *os.Root, files []string, cfg *slo.Config, r engine.TimeRange, numWorkers int) (*slo.Tracker, engine.ScanStats) {
+	jobs := make(chan string, numWorkers)
+	partials := make([]*slo.Tracker, numWorkers)
+	scanned := make([]engine.ScanStats, numWorkers)
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Go(func() {
+			tracker := slo.NewTracker(cfg)
+			for filename := range jobs {
+				stats, err := engine.ScanRange(root, filename, sloColumns, r, func(entry *logparser.LogEntry) {
+					tracker.Add(entry) // entries without a valid timestamp are skipped
+				})
+				scanned[w].Add(stats)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
+				}
+			}
+			partials[w] = tracker
+		})
+	}
+
+	for _, filename := range files {
+		jobs <- filename
+	}
+	close(jobs)
+	wg.Wait()
+
+	tracker := partials[0]
+	for _, partial := range partials[1:] {
+		tracker.Merge(partial)
+	}
+	for _, stats := range scanned[1:] {
+		scanned[0].Add(stats)
+	}
+	return tracker, scanned[0]
+}
+
+func printSLOReport(r *slo.Report, maxEpisodes int, elapsed time.Duration) {
+	fmt.Printf("\n=== SLO Report ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	if r.Start.IsZero() {
+		fmt.Printf("No requests\n")
+		return
+	}
+	const layout = "2006-01-02 15:04"
+	fmt.Printf("Period: %s to %s UTC (%s)\n", r.Start.Format(layout), r.End.Format(layout), r.End.Sub(r.Start))
+
+	for _, ind := range r.Indicators {
+		state := "MET"
+		if !ind.Met() {
+			state = "VIOLATED"
+		}
+		fmt.Printf("\n[%s] %s: %s\n", state, ind.Objective, ind.Name)
+		if ind.Total == 0 {
+			fmt.Printf("  No matching requests\n")
+			continue
+		}
+		fmt.Printf("  Compliance:   %s (target %s), %s bad of %s requests\n",
+			formatPercent(ind.Compliance), formatPercent(ind.Target), formatNumber(ind.Bad), formatNumber(ind.Total))
+		fmt.Printf("  Error budget: %.1f%% remaining (burn rate %.2f over the period)\n", ind.BudgetRemaining*100, ind.BurnRate)
+
+		var burns []string
+		for _, b := range ind.Current {
+			if !b.Covered {
+				burns = append(burns, fmt.Sprintf("%s n/a", formatWindow(b.Window)))
+				continue
+			}
+			burns = append(burns, fmt.Sprintf("%s %.2f", formatWindow(b.Window), b.BurnRate))
+		}
+		fmt.Printf("  Burn rate at end: %s\n", strings.Join(burns, ", "))
+
+		for _, a := range ind.Alerts {
+			rule := fmt.Sprintf("%s (%s/%s >= %g)", a.Rule.Name,
+				formatWindow(time.Duration(a.Rule.Long)), formatWindow(time.Duration(a.Rule.Short)), a.Rule.BurnRate)
+			if !a.Evaluated {
+				fmt.Printf("  Alert %s: not enough data (the logs span %s)\n", rule, r.End.Sub(r.Start))
+				continue
+			}
+			if len(a.Episodes) == 0 {
+				fmt.Printf("  Alert %s: never fired\n", rule)
+				continue
+			}
+			status := ""
+			if a.Firing {
+				status = ", FIRING"
+			}
+			fmt.Printf("  Alert %s: %d episodes%s\n", rule, len(a.Episodes), status)
+			for _, e := range a.Episodes[:min(len(a.Episodes), maxEpisodes)] {
+				end := e.End.Format("15:04")
+				if e.End.Sub(e.Start) >= 24*time.Hour || e.End.Day() != e.Start.Day() {
+					end = e.End.Format(layout)
+				}
+				fmt.Printf("    %s - %s UTC (%s)\n", e.Start.Format(layout), end, e.End.Sub(e.Start))
+			}
+			if len(a.Episodes) > maxEpisodes {
+				fmt.Printf("    ... %d more\n", len(a.Episodes)-maxEpisodes)
+			}
+		}
+	}
+}
+
+// formatPercent shows enough digits to tell targets such as 99.9% and
+// 99.95% apart.
+func formatPercent(f float64) string {
+	return fmt.Sprintf("%.3f%%", f*100)
+}
+
+// formatWindow formats a whole number of minutes as "5m", "1h" or "1h30m".
+func formatWindow(d time.Duration) string {
+	s := d.String()
+	s = strings.TrimSuffix(s, "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
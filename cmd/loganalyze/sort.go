@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/extsort"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+)
+
+func runSort(args []string) error {
+	flags := flag.NewFlagSet("sort", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	outDir := flags.String("out", "./logs-sorted", "Directory of the sorted JSON logs (created if needed), or - for standard output")
+	lines := flags.Int("lines", 100000, "Entries per output file (0 means a single file)")
+	memoryMB := flags.Int("memory", 256, "Memory budget in MB for the entries held before spilling, shared by the workers")
+	tmpDir := flags.String("tmp", "", "Directory of the spilled runs (default: the system temporary directory)")
+	fanIn := flags.Int("fanin", 64, "Most spilled runs merged at once")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines sorting files")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	toStdout := *outDir == "-"
+	if !toStdout && absPath(*logDir) == absPath(*outDir) {
+		return fmt.Errorf("-out must differ from -logs")
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	var out *sortedWriter
+	if toStdout {
+		out = &sortedWriter{w: bufio.NewWriterSize(os.Stdout, 256*1024)}
+	} else {
+		if out, err = createSortedWriter(*outDir, *lines); err != nil {
+			return err
+		}
+		defer out.dir.Close()
+	}
+
+	numWorkers := max(*workers, 1)
+	cfg := extsort.Config{
+		MemoryLimit: int64(max(*memoryMB, 1)) << 20 / int64(numWorkers),
+		TempDir:     *tmpDir,
+		FanIn:       *fanIn,
+	}
+
+	startTime := time.Now()
+	runs, stats, err := sortFiles(root, files, cfg, numWorkers)
+	if err != nil {
+		return errors.Join(err, extsort.RemoveAll(runs))
+	}
+	stats.sorted = time.Since(startTime)
+
+	err = extsort.Merge(runs, cfg, func(_ int64, data []byte) error {
+		return out.write(data)
+	})
+	if err != nil {
+		out.abort()
+		return err
+	}
+	if err := out.close(); err != nil {
+		return err
+	}
+	stats.elapsed = time.Since(startTime)
+
+	// The summary must not mix with the entries on standard output.
+	summary := io.Writer(os.Stdout)
+	if toStdout {
+		summary = os.Stderr
+	}
+	printSort(summary, stats, out.files)
+	return nil
+}
+
+// sortStats sums up a global sort.
+type sortStats struct {
+	files, entries, dropped int
+	spilled                 int
+	spilledBytes            int64
+	sorted, elapsed         time.Duration
+}
+
+func (s *sortStats) add(other *sortStats) {
+	s.files += other.files
+	s.entries += other.entries
+	s.dropped += other.dropped
+	s.spilled += other.spilled
+	s.spilledBytes += other.spilledBytes
+}
+
+// sortFiles sorts the entries of all files into runs. Each worker feeds the
+// files it takes to its own Sorter, which spills sorted runs whenever the
+// worker's share of the memory budget is full, and keeps the rest in
+// memory for the merge.
+//
+// The entries are keyed by timestamp, and ties are broken by the position
+// of the entry among all files, so that the merged order does not depend
+// on which worker sorted which file.
+func sortFiles(root *os.Root, files []string, cfg extsort.Config, numWorkers int) ([]*extsort.Run, *sortStats, error) {
+	jobs := make(chan int, numWorkers)
+	partials := make([]*sortStats, numWorkers)
+	runs := make([][]*extsort.Run, numWorkers)
+	errs := make([]error, len(files))
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Go(func() {
+			partial := &sortStats{}
+			sorter := extsort.NewSorter(cfg)
+			for i := range jobs {
+				if err := sortFile(root, files[i], uint64(i), sorter, partial); err != nil {
+					errs[i] = fmt.Errorf("failed to sort %s: %w", files[i], err)
+				}
+			}
+			runs[w] = sorter.Finish()
+			partial.spilled = sorter.Spilled
+			partial.spilledBytes = sorter.SpilledBytes
+			partials[w] = partial
+		})
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var all []*extsort.Run
+	total := &sortStats{}
+	for w := range numWorkers {
+		all = append(all, runs[w]...)
+		total.add(partials[w])
+	}
+	return all, total, errors.Join(errs...)
+}
+
+// sortFile adds the entries of a file to sorter as JSON lines. Entries
+// without a valid timestamp have no place in the order and are dropped.
+func sortFile(root *os.Root, filename string, fileIndex uint64, sorter *extsort.Sorter, stats *sortStats) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	// The sequence number is the file index followed by the index of the
+	// entry in the file, which leaves room for 2^32 entries per file.
+	seq := fileIndex << 32
+	var addErr error
+	err := engine.ScanFile(root, filename, func(entry *logparser.LogEntry) {
+		seq++
+		if addErr != nil {
+			return
+		}
+		t, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+		if err != nil {
+			stats.dropped++
+			return
+		}
+		buf.Reset()
+		if addErr = enc.Encode(entry); addErr != nil {
+			return
+		}
+		addErr = sorter.Add(t.UnixNano(), seq, buf.Bytes())
+		stats.entries++
+	})
+	if err = errors.Join(err, addErr); err != nil {
+		return err
+	}
+	stats.files++
+	return nil
+}
+
+// sortedWriter writes the merged entries to standard output, or to
+// numbered files of up to limit entries each. Every file is written under
+// a temporary name and renamed once complete, so that the output
+// directory never holds a partial log file.
+type sortedWriter struct {
+	w *bufio.Writer
+
+	dir   *os.Root
+	limit int
+	file  *os.File
+	name  string
+	lines int
+	files []string
+}
+
+// createSortedWriter opens the output directory, which must not hold log
+// files already: they would mix with the sorted ones.
+func createSortedWriter(dir string, limit int) (*sortedWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output directory: %w", err)
+	}
+	existing, err := engine.ListLogFiles(root)
+	if err == nil && len(existing) > 0 {
+		err = fmt.Errorf("output directory %s already holds log files", dir)
+	}
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+	return &sortedWriter{dir: root, limit: limit}, nil
+}
+
+func (s *sortedWriter) write(line []byte) error {
+	if s.dir != nil && (s.file == nil || (s.limit > 0 && s.lines == s.limit)) {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+	s.lines++
+	_, err := s.w.Write(line)
+	return err
+}
+
+// next completes the current output file and starts the next one.
+func (s *sortedWriter) next() error {
+	if err := s.complete(); err != nil {
+		return err
+	}
+	s.name = fmt.Sprintf("access_%05d.json", len(s.files)+1)
+	file, err := s.dir.Create(s.name + ".tmp")
+	if err != nil {
+		return err
+	}
+	s.file, s.lines = file, 0
+	if s.w == nil {
+		s.w = bufio.NewWriterSize(file, 256*1024)
+	} else {
+		s.w.Reset(file)
+	}
+	return nil
+}
+
+// complete flushes the current output file and gives it its final name.
+func (s *sortedWriter) complete() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.dir.Rename(s.name+".tmp", s.name)
+	}
+	s.file = nil
+	if err != nil {
+		s.dir.Remove(s.name + ".tmp")
+		return fmt.Errorf("failed to write %s: %w", s.name, err)
+	}
+	s.files = append(s.files, s.name)
+	return nil
+}
+
+func (s *sortedWriter) close() error {
+	if s.dir == nil {
+		return s.w.Flush()
+	}
+	return s.complete()
+}
+
+// abort removes the output file being written.
+func (s *sortedWriter) abort() {
+	if s.file != nil {
+		s.file.Close()
+		s.dir.Remove(s.name + ".tmp")
+		s.file = nil
+	}
+}
+
+func printSort(w io.Writer, stats *sortStats, files []string) {
+	fmt.Fprintf(w, "\n=== Global Sort ===\n")
+	fmt.Fprintf(w, "Elapsed: %.2fs (sort %.2fs, merge %.2fs)\n",
+		stats.elapsed.Seconds(), stats.sorted.Seconds(), (stats.elapsed - stats.sorted).Seconds())
+	fmt.Fprintf(w, "Entries: %s from %d files", formatNumber(stats.entries), stats.files)
+	if stats.dropped > 0 {
+		fmt.Fprintf(w, " (%s without a valid timestamp dropped)", formatNumber(stats.dropped))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Spilled: %d runs, %s bytes\n", stats.spilled, formatNumber(int(stats.spilledBytes)))
+	if len(files) > 0 {
+		fmt.Fprintf(w, "Output files: %d (%s to %s)\n", len(files), files[0], files[len(files)-1])
+	}
+}
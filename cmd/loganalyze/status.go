@@ -0,0 +1,161 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/pool"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/progress"
+)
+
+// runStatus counts status codes like the workshop phases, using the engine
+// and its pluggable reader, pool and schedule.
+func runStatus(args []string) error {
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	readerName := flags.String("reader", engine.ReaderBuffered, "File reader backend: buffered or mmap")
+	chunkKB := flags.Int("chunk", engine.DefaultChunkSize/1024, "Chunk size in KB")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of workers (initial number with -adaptive)")
+	adaptive := flags.Bool("adaptive", false, "Resize the worker pool while running based on throughput and I/O wait")
+	minWorkers := flags.Int("min-workers", 1, "Lower bound of the adaptive pool")
+	maxWorkers := flags.Int("max-workers", 4*runtime.GOMAXPROCS(0), "Upper bound of the adaptive pool")
+	schedule := flags.String("schedule", engine.ScheduleFIFO, "Work schedule: fifo, largest or steal")
+	taskKB := flags.Int("task", engine.DefaultTaskSize/1024, "Range size in KB stolen by the steal schedule")
+	showProgress := flags.Bool("progress", true, "Show progress on stderr (only if stderr is a terminal)")
+	interval := flags.Duration("interval", 500*time.Millisecond, "Sampling interval of the adaptive pool")
+	checkpointPath := flags.String("checkpoint", "", "Save progress to this file periodically")
+	checkpointInterval := flags.Duration("checkpoint-interval", 5*time.Second, "How often the checkpoint is saved")
+	resume := flags.Bool("resume", false, "Skip the files completed in -checkpoint and merge its saved totals")
+	shard := flags.String("shard", "", "Analyze only shard i/n of the files, e.g. 0/3")
+	outPath := flags.String("out", "", "Write the results to this file for `loganalyze merge` (.json for JSON, binary otherwise)")
+	cachePath := flags.String("cache", "", "Reuse the results of unchanged files from this cache and update it")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	reader, err := engine.NewReader(*readerName, *chunkKB*1024)
+	if err != nil {
+		return err
+	}
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	if *shard != "" {
+		index, count, err := parseShard(*shard)
+		if err != nil {
+			return err
+		}
+		files = shardFiles(files, index, count)
+	}
+
+	opts := engine.Options{
+		Workers:  *workers,
+		Reader:   reader,
+		Schedule: *schedule,
+		TaskSize: int64(*taskKB) * 1024,
+	}
+	if *adaptive {
+		opts.Adaptive = &pool.Config{
+			Min:      *minWorkers,
+			Max:      *maxWorkers,
+			Initial:  *workers,
+			Interval: *interval,
+			Logger:   log.New(os.Stderr, "", 0),
+		}
+	}
+
+	if *checkpointPath != "" {
+		opts.Checkpoint = &engine.CheckpointOptions{
+			Path:     *checkpointPath,
+			Interval: *checkpointInterval,
+			Resume:   *resume,
+		}
+	} else if *resume {
+		return fmt.Errorf("-resume requires -checkpoint")
+	}
+	// A resumed checkpoint keeps only the total of the files it completed.
+	if *resume && *outPath != "" {
+		return fmt.Errorf("-out cannot be combined with -resume")
+	}
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	if *cachePath != "" {
+		opts.Cache, err = engine.OpenCache(*cachePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	var reporter *progress.Reporter
+	if *showProgress && progress.IsTerminal(os.Stderr) {
+		totalBytes, err := engine.TotalSize(root, files)
+		if err != nil {
+			return err
+		}
+		opts.Progress = &progress.Counters{}
+		reporter = progress.Start(os.Stderr, opts.Progress,
+			progress.Total{Files: len(files), Bytes: totalBytes}, 200*time.Millisecond)
+	}
+
+	startTime := time.Now()
+	report, err := engine.Execute(root, files, opts)
+	reporter.Stop()
+	if report == nil {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error processing files: %v\n", err)
+	}
+	if opts.Cache != nil {
+		if err := opts.Cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving cache: %v\n", err)
+		}
+		fmt.Printf("Reused %d of %d results from %s\n", report.Cached, len(files), *cachePath)
+	}
+	if report.Resumed != nil && report.Resumed.FileCount > 0 {
+		fmt.Printf("Resumed %d completed files from %s\n", report.Resumed.FileCount, *checkpointPath)
+	}
+	printStatus(report.Total(), time.Since(startTime))
+
+	if *outPath != "" {
+		if err := writeResultSet(*outPath, logparser.NewResultSet(report.Results)); err != nil {
+			return err
+		}
+		fmt.Printf("\nResults written to %s\n", *outPath)
+	}
+	return nil
+}
+
+func printStatus(total *logparser.TotalResult, elapsed time.Duration) {
+	fmt.Printf("\n=== Status ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	fmt.Printf("Files: %d\n", total.FileCount)
+	fmt.Printf("Requests: %s\n", formatNumber(total.TotalCount))
+	fmt.Printf("\nBy status code:\n")
+	for s := 100; s < 600; s++ {
+		if count, ok := total.StatusCounts[s]; ok {
+			percentage := float64(count) / float64(total.TotalCount) * 100
+			fmt.Printf("  %d: %s (%.2f%%)\n", s, formatNumber(count), percentage)
+		}
+	}
+	fmt.Printf("\nError rate (4xx, 5xx): %.2f%%\n", total.ErrorRate())
+}
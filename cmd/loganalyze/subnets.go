@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/iptag"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+)
+
+func runSubnets(args []string) error {
+	flags := flag.NewFlagSet("subnets", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines parsing files")
+	bits4 := flags.Int("bits", 24, "IPv4 prefix length to group clients by (e.g. 16 or 24)")
+	bits6 := flags.Int("bits6", 64, "IPv6 prefix length to group clients by")
+	tablePath := flags.String("table", "", "JSON table of CIDR prefixes to labels, e.g. cmd/loganalyze/cidrs/example.json")
+	top := flags.Int("top", 10, "Prefixes listed per label")
+	prof := profiling.Register(flags)
+	flags.Parse(args)
+
+	if *bits4 < 0 || *bits4 > 32 || *bits6 < 0 || *bits6 > 128 {
+		return fmt.Errorf("-bits must be in [0, 32] and -bits6 in [0, 128], got %d and %d", *bits4, *bits6)
+	}
+	if *top < 0 {
+		return fmt.Errorf("-top must not be negative, got %d", *top)
+	}
+	var table *iptag.Table
+	if *tablePath != "" {
+		var err error
+		if table, err = iptag.LoadTable(*tablePath); err != nil {
+			return err
+		}
+	}
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		return err
+	}
+	defer stopProfiling()
+
+	root, files, err := openLogs(*logDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	startTime := time.Now()
+	agg := aggregateSubnets(root, files, table, *bits4, *bits6, max(*workers, 1))
+	printSubnets(agg, *top, time.Since(startTime))
+	return nil
+}
+
+// subnetColumns are the fields of the entries that iptag.Aggregator uses.
+var subnetColumns = segment.Of(segment.IP, segment.Status)
+
+// aggregateSubnets counts the requests of all files by label and prefix.
+// Every worker fills its own Aggregator, which shares the read-only table,
+// and the partial aggregators are merged at the end.
+func aggregateSubnets(root *os.Root, files []string, table *iptag.Table, bits4, bits6, numWorkers int) *iptag.Aggregator {
+	jobs := make(chan string, numWorkers)
+	partials := make([]*iptag.Aggregator, numWorkers)
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Go(func() {
+			agg := iptag.NewAggregator(table, bits4, bits6)
+			for filename := range jobs {
+				err := engine.ScanColumns(root, filename, subnetColumns, agg.Add)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
+				}
+			}
+			partials[w] = agg
+		})
+	}
+
+	for _, filename := range files {
+		jobs <- filename
+	}
+	close(jobs)
+	wg.Wait()
+
+	agg := partials[0]
+	for _, partial := range partials[1:] {
+		agg.Merge(partial)
+	}
+	return agg
+}
+
+func printSubnets(agg *iptag.Aggregator, top int, elapsed time.Duration) {
+	groups := agg.Report(top)
+	total := agg.Invalid.Requests
+	for _, g := range groups {
+		total += g.Requests
+	}
+
+	fmt.Printf("\n=== Client Subnets ===\n")
+	fmt.Printf("Elapsed: %.2fs\n", elapsed.Seconds())
+	fmt.Printf("Requests: %s\n", formatNumber(total))
+	if agg.Invalid.Requests > 0 {
+		fmt.Printf("Invalid IPs: %s\n", formatNumber(agg.Invalid.Requests))
+	}
+
+	row := func(name string, c iptag.Counts, extra string) {
+		fmt.Printf("%-28s %12s %7.2f%% %7.2f%% %7.2f%%%s\n", name, formatNumber(c.Requests),
+			float64(c.Requests)/float64(max(total, 1))*100, c.ClientErrorRate(), c.ServerErrorRate(), extra)
+	}
+	header := func(name string) {
+		fmt.Printf("%-28s %12s %8s %8s %8s\n", name, "Requests", "Share", "4xx", "5xx")
+	}
+
+	if len(groups) > 1 || (len(groups) == 1 && groups[0].Label != "") {
+		fmt.Printf("\n")
+		header("Label")
+		for _, g := range groups {
+			row(g.Label, g.Counts, fmt.Sprintf("  (%s prefixes)", formatNumber(g.Prefixes)))
+		}
+	}
+	for _, g := range groups {
+		title := "Top prefixes"
+		if g.Label != "" {
+			title += " of " + g.Label
+		}
+		fmt.Printf("\n%s:\n", title)
+		header("Prefix")
+		for _, p := range g.TopPrefixes {
+			row(p.Prefix.String(), p.Counts, "")
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/batch"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// runBatch compares sending lines one by one with sending them in batches of
+// increasing size, and with sending newline-aligned byte chunks.
+func runBatch(args []string) error {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	sizesSpec := flags.String("sizes", "1,8,64,512,4096", "Comma separated batch sizes (lines)")
+	interval := flags.Duration("interval", 0, "Flush latency of partial batches (0 disables)")
+	chunkKB := flags.Int("chunk", 256, "Chunk size in KB for the byte chunk run")
+	readers := flags.Int("readers", runtime.GOMAXPROCS(0), "Goroutines reading files")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Goroutines parsing lines")
+	rounds := flags.Int("rounds", 3, "Runs per configuration; the fastest is reported")
+	flags.Parse(args)
+
+	sizes, err := parseSizes(*sizesSpec)
+	if err != nil {
+		return err
+	}
+
+	ds, err := openDataset(*logDir)
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+
+	fmt.Printf("%d files, %.1fMB, %d readers, %d workers\n\n",
+		len(ds.files), float64(ds.bytes)/(1024*1024), *readers, *workers)
+	printHeader()
+
+	expected := -1
+	check := func(m measurement) error {
+		if expected >= 0 && m.lines != expected {
+			return fmt.Errorf("%s counted %d lines, expected %d", m.name, m.lines, expected)
+		}
+		expected = m.lines
+		return nil
+	}
+
+	for _, size := range sizes {
+		cfg := batch.Config{Size: size, FlushInterval: *interval}
+		m, err := best(*rounds, func() (measurement, error) {
+			return benchLines(ds, cfg, max(*readers, 1), max(*workers, 1)), nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := check(m); err != nil {
+			return err
+		}
+		printMeasurement(m, ds.bytes)
+	}
+
+	m, err := best(*rounds, func() (measurement, error) {
+		return benchChunks(ds, *chunkKB*1024, max(*readers, 1), max(*workers, 1))
+	})
+	if err != nil {
+		return err
+	}
+	if err := check(m); err != nil {
+		return err
+	}
+	printMeasurement(m, ds.bytes)
+	return nil
+}
+
+// benchLines reads lines and sends them to the workers in batches of
+// cfg.Size. Workers only extract the status, so that the cost of the
+// transport is visible.
+func benchLines(ds *dataset, cfg batch.Config, numReaders, numWorkers int) measurement {
+	start := time.Now()
+	pool := batch.NewPool[string](cfg.Size)
+	lines := make(chan []string, numWorkers)
+	jobs := make(chan string, numReaders)
+
+	var readers sync.WaitGroup
+	for range numReaders {
+		readers.Go(func() {
+			batcher := batch.New(lines, pool, cfg)
+			defer batcher.Close()
+
+			for name := range jobs {
+				file, err := ds.root.Open(name)
+				if err != nil {
+					continue
+				}
+				scanner := bufio.NewScanner(file)
+				scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+				for scanner.Scan() {
+					batcher.Add(scanner.Text())
+				}
+				file.Close()
+			}
+		})
+	}
+
+	counts := make([]int, numWorkers)
+	var workers sync.WaitGroup
+	for w := range numWorkers {
+		workers.Go(func() {
+			for b := range lines {
+				for _, line := range b {
+					if _, err := logparser.ParseStatus([]byte(line)); err == nil {
+						counts[w]++
+					}
+				}
+				pool.Put(b)
+			}
+		})
+	}
+
+	for _, name := range ds.files {
+		jobs <- name
+	}
+	close(jobs)
+	readers.Wait()
+	close(lines)
+	workers.Wait()
+
+	return measurement{
+		name:    fmt.Sprintf("lines batch=%d", cfg.Size),
+		lines:   sum(counts),
+		elapsed: time.Since(start),
+	}
+}
+
+// benchChunks sends pooled newline-aligned chunks instead of lines.
+func benchChunks(ds *dataset, chunkSize, numReaders, numWorkers int) (measurement, error) {
+	start := time.Now()
+	pool := batch.NewPool[byte](chunkSize)
+	chunks := make(chan []byte, numWorkers)
+	jobs := make(chan string, numReaders)
+
+	var mu sync.Mutex
+	var readErr error
+	var readers sync.WaitGroup
+	for range numReaders {
+		readers.Go(func() {
+			for name := range jobs {
+				file, err := ds.root.Open(name)
+				if err == nil {
+					err = batch.ReadChunks(file, pool, func(chunk []byte) { chunks <- chunk })
+					file.Close()
+				}
+				if err != nil {
+					mu.Lock()
+					readErr = err
+					mu.Unlock()
+				}
+			}
+		})
+	}
+
+	counts := make([]int, numWorkers)
+	var workers sync.WaitGroup
+	for w := range numWorkers {
+		workers.Go(func() {
+			for chunk := range chunks {
+				rest := chunk
+				for len(rest) > 0 {
+					line := rest
+					if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+						line, rest = rest[:i], rest[i+1:]
+					} else {
+						rest = nil
+					}
+					if _, err := logparser.ParseStatus(line); err == nil {
+						counts[w]++
+					}
+				}
+				pool.Put(chunk)
+			}
+		})
+	}
+
+	for _, name := range ds.files {
+		jobs <- name
+	}
+	close(jobs)
+	readers.Wait()
+	close(chunks)
+	workers.Wait()
+
+	return measurement{
+		name:    fmt.Sprintf("chunks %dKB", chunkSize/1024),
+		lines:   sum(counts),
+		elapsed: time.Since(start),
+	}, readErr
+}
+
+func sum(counts []int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,154 @@
+// logbench measures the throughput of the concurrency building blocks on the
+// generated log files.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+)
+
+// command is a logbench subcommand.
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"batch": {"Throughput of line transport versus batch size", runBatch},
+	"read":  {"Throughput of the buffered and mmap file readers", runRead},
+	"sched": {"Tail latency of the schedules on uneven file sizes", runSched},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: logbench <command> [flags]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].summary)
+	}
+}
+
+// dataset is the set of log files a benchmark runs on.
+type dataset struct {
+	root  *os.Root
+	files []string
+	bytes int64
+}
+
+// openDataset opens the log directory and reads every file once, so that
+// all measurements start with a warm page cache.
+func openDataset(dir string) (*dataset, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log directory: %w", err)
+	}
+
+	files, err := engine.ListLogFiles(root)
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+	if len(files) == 0 {
+		root.Close()
+		return nil, fmt.Errorf("no log files in %s (run make gen first)", dir)
+	}
+
+	ds := &dataset{root: root, files: files}
+	for _, name := range files {
+		file, err := root.Open(name)
+		if err != nil {
+			root.Close()
+			return nil, err
+		}
+		n, err := io.Copy(io.Discard, file)
+		file.Close()
+		if err != nil {
+			root.Close()
+			return nil, err
+		}
+		ds.bytes += n
+	}
+	return ds, nil
+}
+
+func (ds *dataset) Close() error {
+	return ds.root.Close()
+}
+
+// measurement is the outcome of one benchmark run.
+type measurement struct {
+	name    string
+	lines   int
+	elapsed time.Duration
+}
+
+// best runs fn rounds times and keeps the fastest run.
+func best(rounds int, fn func() (measurement, error)) (measurement, error) {
+	var fastest measurement
+	for i := range max(rounds, 1) {
+		m, err := fn()
+		if err != nil {
+			return m, err
+		}
+		if i == 0 || m.elapsed < fastest.elapsed {
+			fastest = m
+		}
+	}
+	return fastest, nil
+}
+
+func printHeader() {
+	fmt.Printf("%-20s %10s %12s %14s %10s\n", "run", "elapsed", "lines", "lines/s", "MB/s")
+}
+
+func printMeasurement(m measurement, bytes int64) {
+	seconds := m.elapsed.Seconds()
+	fmt.Printf("%-20s %10s %12d %14.0f %10.1f\n",
+		m.name, m.elapsed.Round(time.Millisecond), m.lines,
+		float64(m.lines)/seconds, float64(bytes)/(1024*1024)/seconds)
+}
+
+// parseSizes parses a comma separated list of positive integers.
+func parseSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid size %q", field)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
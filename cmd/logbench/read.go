@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// runRead compares the file reading backends of the engine.
+func runRead(args []string) error {
+	flags := flag.NewFlagSet("read", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	readersSpec := flags.String("readers", engine.ReaderBuffered+","+engine.ReaderMmap, "Comma separated reader backends")
+	chunkKB := flags.Int("chunk", engine.DefaultChunkSize/1024, "Chunk size in KB")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Goroutines processing files")
+	rounds := flags.Int("rounds", 3, "Runs per backend; the fastest is reported")
+	flags.Parse(args)
+
+	ds, err := openDataset(*logDir)
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+
+	fmt.Printf("%d files, %.1fMB, %d workers, %dKB chunks\n\n",
+		len(ds.files), float64(ds.bytes)/(1024*1024), *workers, *chunkKB)
+	printHeader()
+
+	var reference *logparser.TotalResult
+	for _, name := range strings.Split(*readersSpec, ",") {
+		reader, err := engine.NewReader(strings.TrimSpace(name), *chunkKB*1024)
+		if err != nil {
+			return err
+		}
+
+		var total *logparser.TotalResult
+		m, err := best(*rounds, func() (measurement, error) {
+			start := time.Now()
+			results, err := engine.Run(ds.root, ds.files, engine.Options{Workers: *workers, Reader: reader})
+			total = logparser.MergeResults(results)
+			return measurement{name: reader.Name(), lines: total.TotalCount, elapsed: time.Since(start)}, err
+		})
+		if err != nil {
+			return err
+		}
+
+		if reference == nil {
+			reference = total
+		} else if err := sameTotals(reference, total); err != nil {
+			return fmt.Errorf("%s: %w", reader.Name(), err)
+		}
+		printMeasurement(m, ds.bytes)
+	}
+	return nil
+}
+
+// sameTotals reports whether two runs counted the same entries.
+func sameTotals(want, got *logparser.TotalResult) error {
+	if want.TotalCount != got.TotalCount {
+		return fmt.Errorf("counted %d entries, expected %d", got.TotalCount, want.TotalCount)
+	}
+	for status, count := range want.StatusCounts {
+		if got.StatusCounts[status] != count {
+			return fmt.Errorf("status %d counted %d times, expected %d", status, got.StatusCounts[status], count)
+		}
+	}
+	return nil
+}
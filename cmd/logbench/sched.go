@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/sched"
+)
+
+// runSched compares the schedules of the engine. It is meant for datasets
+// with uneven file sizes (loggen -skew), where the phase3 order leaves
+// workers idle while the largest file is still being processed.
+func runSched(args []string) error {
+	flags := flag.NewFlagSet("sched", flag.ExitOnError)
+	logDir := flags.String("logs", "./logs", "Log directory")
+	schedulesSpec := flags.String("schedules",
+		engine.ScheduleFIFO+","+engine.ScheduleLargest+","+engine.ScheduleSteal, "Comma separated schedules")
+	readerName := flags.String("reader", engine.ReaderBuffered, "File reader backend: buffered or mmap")
+	workers := flags.Int("workers", runtime.GOMAXPROCS(0), "Number of workers")
+	taskKB := flags.Int("task", engine.DefaultTaskSize/1024, "Range size in KB stolen by the steal schedule")
+	rounds := flags.Int("rounds", 3, "Runs per schedule; the fastest is reported")
+	flags.Parse(args)
+
+	reader, err := engine.NewReader(*readerName, 0)
+	if err != nil {
+		return err
+	}
+
+	ds, err := openDataset(*logDir)
+	if err != nil {
+		return err
+	}
+	defer ds.Close()
+
+	largest, err := largestFile(ds)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d files, %.1fMB, largest file %.1f%% of the data, %d workers\n\n",
+		len(ds.files), float64(ds.bytes)/(1024*1024), float64(largest)/float64(ds.bytes)*100, *workers)
+	fmt.Printf("%-10s %10s %10s %8s %8s %10s\n", "schedule", "elapsed", "tail", "idle", "steals", "MB/s")
+
+	var reference *logparser.TotalResult
+	for _, name := range strings.Split(*schedulesSpec, ",") {
+		opts := engine.Options{
+			Workers:  *workers,
+			Reader:   reader,
+			Schedule: strings.TrimSpace(name),
+			TaskSize: int64(*taskKB) * 1024,
+		}
+
+		var fastest sched.Stats
+		var total *logparser.TotalResult
+		for i := range max(*rounds, 1) {
+			report, err := engine.Execute(ds.root, ds.files, opts)
+			if err != nil {
+				return err
+			}
+			if i == 0 || report.Schedule.Elapsed < fastest.Elapsed {
+				fastest = report.Schedule
+			}
+			total = logparser.MergeResults(report.Results)
+		}
+
+		if reference == nil {
+			reference = total
+		} else if err := sameTotals(reference, total); err != nil {
+			return fmt.Errorf("%s: %w", opts.Schedule, err)
+		}
+		printSchedule(opts.Schedule, fastest, ds.bytes)
+	}
+	fmt.Printf("\ntail: time between the first worker running out of work and the end of the run\n")
+	return nil
+}
+
+// largestFile returns the size of the largest file of the dataset.
+func largestFile(ds *dataset) (int64, error) {
+	var largest int64
+	for _, name := range ds.files {
+		info, err := ds.root.Stat(name)
+		if err != nil {
+			return 0, err
+		}
+		largest = max(largest, info.Size())
+	}
+	return largest, nil
+}
+
+func printSchedule(name string, stats sched.Stats, bytes int64) {
+	var busy time.Duration
+	for _, w := range stats.Workers {
+		busy += w.Busy
+	}
+	capacity := stats.Elapsed * time.Duration(len(stats.Workers))
+	idle := 1 - float64(busy)/float64(capacity)
+
+	fmt.Printf("%-10s %10s %10s %7.1f%% %8d %10.1f\n",
+		name, stats.Elapsed.Round(time.Millisecond), stats.Tail().Round(time.Millisecond),
+		idle*100, stats.Steals(), float64(bytes)/(1024*1024)/stats.Elapsed.Seconds())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// appendLogEntry appends the JSON encoding of entry followed by a newline.
+// The output is identical to json.Encoder with SetEscapeHTML(false), but it
+// avoids reflection and allocations by writing into a reusable buffer.
+func appendLogEntry(buf []byte, entry *LogEntry) []byte {
+	buf = append(buf, `{"timestamp":`...)
+	buf = appendJSONString(buf, entry.Timestamp)
+	buf = append(buf, `,"method":`...)
+	buf = appendJSONString(buf, entry.Method)
+	buf = append(buf, `,"path":`...)
+	buf = appendJSONString(buf, entry.Path)
+	buf = append(buf, `,"status":`...)
+	buf = strconv.AppendInt(buf, int64(entry.Status), 10)
+	buf = append(buf, `,"response_time_ms":`...)
+	buf = strconv.AppendInt(buf, int64(entry.ResponseTimeMs), 10)
+	buf = append(buf, `,"bytes":`...)
+	buf = strconv.AppendInt(buf, int64(entry.Bytes), 10)
+	buf = append(buf, `,"user_id":`...)
+	buf = appendJSONString(buf, entry.UserID)
+	buf = append(buf, `,"ip":`...)
+	buf = appendJSONString(buf, entry.IP)
+	buf = append(buf, "}\n"...)
+	return buf
+}
+
+// appendJSONString appends s as a JSON string literal.
+// Plain printable ASCII is copied as is; anything else falls back to
+// encoding/json so escaping stays exactly the same.
+func appendJSONString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			return appendJSONStringSlow(buf, s)
+		}
+	}
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
+
+func appendJSONStringSlow(buf []byte, s string) []byte {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(s); err != nil {
+		// Encoding a string never fails; keep the quoted form just in case.
+		return strconv.AppendQuote(buf, s)
+	}
+	return append(buf, bytes.TrimSuffix(b.Bytes(), []byte("\n"))...)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"time"
+)
+
+// Incident is a period of abnormal traffic injected into the random model,
+// for example to check that an anomaly detector finds it.
+//
+// During the incident the request rate is multiplied by Traffic, the extra
+// requests going to Path, and the requests to Path (to every path if Path
+// is empty) fail with Status with probability ErrorRate and take
+// LatencyFactor times longer. With IP or UserID, the extra requests come
+// from that client and only its requests are degraded, as for a client
+// stuffing credentials or scraping the site.
+type Incident struct {
+	Start         time.Time `json:"start"`
+	Minutes       int       `json:"minutes"`
+	Path          string    `json:"path,omitempty"`
+	Traffic       float64   `json:"traffic,omitempty"`
+	ErrorRate     float64   `json:"error_rate,omitempty"`
+	Status        int       `json:"status,omitempty"`
+	LatencyFactor float64   `json:"latency_factor,omitempty"`
+	IP            string    `json:"ip,omitempty"`
+	UserID        string    `json:"user_id,omitempty"`
+}
+
+// client reports whether the entry comes from the client of the incident,
+// if it has one.
+func (inc *Incident) client(entry *LogEntry) bool {
+	return (inc.IP == "" || entry.IP == inc.IP) && (inc.UserID == "" || entry.UserID == inc.UserID)
+}
+
+func (inc *Incident) end() time.Time {
+	return inc.Start.Add(time.Duration(inc.Minutes) * time.Minute)
+}
+
+func (p *Profile) validateIncidents() []error {
+	var errs []error
+	for i, inc := range p.Incidents {
+		field := fmt.Sprintf("incidents[%d]", i)
+		if inc.Minutes <= 0 {
+			errs = append(errs, fmt.Errorf("%s: minutes must be positive, got %d", field, inc.Minutes))
+		}
+		if inc.Start.Before(p.TimeRange.Start) || inc.end().After(p.TimeRange.End) {
+			errs = append(errs, fmt.Errorf("%s: %s to %s is outside of time_range", field,
+				inc.Start.Format(time.RFC3339), inc.end().Format(time.RFC3339)))
+		}
+		if inc.Path != "" && p.pathSpec(inc.Path) == nil {
+			errs = append(errs, fmt.Errorf("%s: path %q is not a template of paths", field, inc.Path))
+		}
+		if inc.Traffic != 0 && inc.Traffic < 1 {
+			errs = append(errs, fmt.Errorf("%s: traffic must be at least 1, got %g", field, inc.Traffic))
+		}
+		if inc.ErrorRate < 0 || inc.ErrorRate > 1 {
+			errs = append(errs, fmt.Errorf("%s: error_rate must be in [0, 1], got %g", field, inc.ErrorRate))
+		}
+		if inc.ErrorRate > 0 && (inc.Status < 100 || inc.Status > 599) {
+			errs = append(errs, fmt.Errorf("%s: status %d out of range 100-599", field, inc.Status))
+		}
+		if inc.LatencyFactor < 0 {
+			errs = append(errs, fmt.Errorf("%s: latency_factor must not be negative, got %g", field, inc.LatencyFactor))
+		}
+		if inc.IP != "" {
+			if _, err := netip.ParseAddr(inc.IP); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", field, err))
+			}
+		}
+		if (inc.IP != "" || inc.UserID != "") && inc.Traffic == 0 {
+			errs = append(errs, fmt.Errorf("%s: a client incident needs traffic", field))
+		}
+	}
+	return errs
+}
+
+func (p *Profile) pathSpec(template string) *PathSpec {
+	for i := range p.Paths {
+		if p.Paths[i].Template == template {
+			return &p.Paths[i]
+		}
+	}
+	return nil
+}
+
+// applyIncidents moves some entries into the incidents to raise their
+// traffic and degrades the entries that fall into one. It returns the time
+// of the entry. Without incidents it draws no random numbers, so that the
+// output of a seed does not change.
+func (p *Profile) applyIncidents(entry *LogEntry, at time.Time, rng *rand.Rand) time.Time {
+	if len(p.Incidents) == 0 {
+		return at
+	}
+
+	// An incident of a fraction f of the time range receives f of the
+	// entries; moving another (Traffic-1)*f of them into it multiplies its
+	// rate by Traffic.
+	total := p.TimeRange.End.Sub(p.TimeRange.Start).Seconds()
+	r := rng.Float64()
+	for i := range p.Incidents {
+		inc := &p.Incidents[i]
+		extra := max(inc.Traffic-1, 0) * float64(inc.Minutes) * 60 / total
+		if r >= extra {
+			r -= extra
+			continue
+		}
+		at = inc.Start.Add(time.Duration(rng.Int64N(int64(inc.Minutes) * int64(time.Minute))))
+		if inc.Path != "" {
+			entry.Path = p.pathSpec(inc.Path).render(rng)
+		}
+		if inc.IP != "" {
+			entry.IP = inc.IP
+		}
+		if inc.UserID != "" {
+			entry.UserID = inc.UserID
+		}
+		break
+	}
+
+	for i := range p.Incidents {
+		inc := &p.Incidents[i]
+		if at.Before(inc.Start) || !at.Before(inc.end()) {
+			continue
+		}
+		if (inc.Path != "" && !p.pathSpec(inc.Path).matches(entry.Path)) || !inc.client(entry) {
+			continue
+		}
+		if inc.ErrorRate > 0 && rng.Float64() < inc.ErrorRate {
+			entry.Status = inc.Status
+			entry.Bytes = generateBytes(inc.Status, rng)
+		}
+		if inc.LatencyFactor > 0 {
+			entry.ResponseTimeMs = int(float64(entry.ResponseTimeMs) * inc.LatencyFactor)
+		}
+	}
+	return at
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/anomaly"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// TestIncidentsAreDetected generates logs with the incidents of
+// profiles/incidents.json and checks that pkg/anomaly flags each of them,
+// and nothing else, with the signal the incident injects.
+func TestIncidentsAreDetected(t *testing.T) {
+	if testing.Short() {
+		t.Skip("generates three million log entries")
+	}
+	const profilePath = "profiles/incidents.json"
+	profile, err := LoadProfile(profilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	cfg := &Config{
+		OutputDir:    dir,
+		FileCount:    6,
+		LinesPerFile: 500000,
+		Seed:         1,
+		Workers:      2,
+		Model:        modelRandom,
+		ProfilePath:  profilePath,
+	}
+	if err := run(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	files, err := engine.ListLogFiles(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := logparser.NewSeries()
+	for _, name := range files {
+		err := engine.ScanFile(root, name, func(entry *logparser.LogEntry) {
+			series.Add(entry)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	windows := anomaly.Detect(series, anomaly.Config{})
+	if len(windows) != len(profile.Incidents) {
+		t.Errorf("got %d flagged windows, want one per incident (%d)", len(windows), len(profile.Incidents))
+	}
+	for _, inc := range profile.Incidents {
+		want := anomaly.Requests
+		switch {
+		case inc.ErrorRate > 0:
+			want = anomaly.ErrorRatio
+		case inc.LatencyFactor > 0:
+			want = anomaly.P99Latency
+		}
+
+		var found *anomaly.Window
+		for i := range windows {
+			if windows[i].Start.Before(inc.end()) && inc.Start.Before(windows[i].End) {
+				found = &windows[i]
+				break
+			}
+		}
+		if found == nil {
+			t.Errorf("incident at %s on %s: not flagged", inc.Start, inc.Path)
+			continue
+		}
+		if got := found.Signals[0].Metric; got != want {
+			t.Errorf("incident at %s on %s: main signal %s, want %s", inc.Start, inc.Path, got, want)
+		}
+		if len(found.TopPaths) == 0 || found.TopPaths[0].Path != inc.Path {
+			t.Errorf("incident at %s on %s: top paths %v, want %s first", inc.Start, inc.Path, found.TopPaths, inc.Path)
+		}
+	}
+}
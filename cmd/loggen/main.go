@@ -2,14 +2,16 @@
 package main
 
 import (
-	"encoding/json"
+	"bufio"
 	"flag"
 	"fmt"
 	"io/fs"
 	"math"
 	"math/rand/v2"
 	"os"
+	"runtime"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -32,33 +34,24 @@ type Config struct {
 	LinesPerFile int
 	Seed         uint64
 	Verbose      bool
+	ProfilePath  string
+	Workers      int
+	Model        string
+	Skew         float64
 }
 
-var (
-	methods       = []string{"GET", "POST", "PUT", "DELETE", "PATCH"}
-	methodWeights = []int{70, 20, 5, 3, 2} // cumulative: GET=70%, POST=20%, etc.
-
-	paths = []string{
-		"/api/users",
-		"/api/users/%d",
-		"/api/products",
-		"/api/products/%d",
-		"/api/orders",
-		"/api/orders/%d",
-		"/api/auth/login",
-		"/api/auth/logout",
-		"/api/search",
-		"/api/health",
-		"/api/metrics",
-		"/",
-		"/static/%s",
-	}
-	pathWeights = []int{15, 10, 15, 10, 10, 5, 8, 3, 7, 5, 2, 5, 5}
-
-	statuses      = []int{200, 201, 204, 301, 302, 400, 401, 403, 404, 500, 502, 503}
-	statusWeights = []int{75, 5, 2, 1, 1, 3, 2, 1, 5, 3, 1, 1}
+// Traffic models selectable with -model.
+const (
+	modelRandom  = "random"
+	modelSession = "session"
 )
 
+// entrySource produces the log entries of a single file. next reports
+// false once the source has no more entries.
+type entrySource interface {
+	next() (LogEntry, bool)
+}
+
 func main() {
 	cfg := parseFlags()
 
@@ -75,11 +68,40 @@ func parseFlags() *Config {
 	flag.IntVar(&cfg.LinesPerFile, "lines", 50000, "Lines per file")
 	flag.Uint64Var(&cfg.Seed, "seed", uint64(time.Now().UnixNano()), "Random seed for reproducibility")
 	flag.BoolVar(&cfg.Verbose, "verbose", false, "Show progress during generation")
+	flag.IntVar(&cfg.Workers, "workers", runtime.GOMAXPROCS(0), "Number of files generated in parallel")
+	flag.StringVar(&cfg.Model, "model", modelRandom, "Traffic model: random (independent entries) or session (simulated user sessions)")
+	flag.Float64Var(&cfg.Skew, "skew", 0, "Vary file sizes with a power law of this exponent (0: every file has -lines lines); the last file is the largest")
+	flag.StringVar(&cfg.ProfilePath, "profile", "", "JSON profile file overriding the built-in distributions")
 	flag.Parse()
 	return cfg
 }
 
 func run(cfg *Config) error {
+	profile := DefaultProfile()
+	if cfg.ProfilePath != "" {
+		loaded, err := LoadProfile(cfg.ProfilePath)
+		if err != nil {
+			return err
+		}
+		profile = loaded
+	}
+
+	var chain *sessionChain
+	switch cfg.Model {
+	case modelRandom:
+	case modelSession:
+		compiled, err := compileSessions(profile)
+		if err != nil {
+			return fmt.Errorf("invalid session model: %w", err)
+		}
+		chain = compiled
+	default:
+		return fmt.Errorf("unknown model %q (want %s or %s)", cfg.Model, modelRandom, modelSession)
+	}
+	if cfg.Skew < 0 {
+		return fmt.Errorf("invalid skew %v (want >= 0)", cfg.Skew)
+	}
+
 	if err := os.MkdirAll(cfg.OutputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
@@ -112,25 +134,22 @@ func run(cfg *Config) error {
 		fmt.Printf("Cleaned up %d existing log file(s)\n", cleaned)
 	}
 
-	rng := rand.New(rand.NewPCG(cfg.Seed, cfg.Seed))
-
 	fmt.Println("Generating log files...")
 	startTime := time.Now()
 	totalSize := int64(0)
 
-	for i := 1; i <= cfg.FileCount; i++ {
-		filename := fmt.Sprintf("access_%03d.json", i)
-
-		size, err := generateLogFile(outputRoot, filename, cfg.LinesPerFile, rng)
-		if err != nil {
-			return fmt.Errorf("failed to generate %s: %w", filename, err)
+	done := 0
+	for res := range generateFiles(outputRoot, cfg, profile, chain) {
+		if res.err != nil {
+			return fmt.Errorf("failed to generate %s: %w", res.filename, res.err)
 		}
 
-		totalSize += size
+		totalSize += res.size
+		done++
 
 		if cfg.Verbose {
 			fmt.Printf("  [%d/%d] %s (%d lines, %.1fMB)\n",
-				i, cfg.FileCount, filename, cfg.LinesPerFile, float64(size)/(1024*1024))
+				done, cfg.FileCount, res.filename, res.lines, float64(res.size)/(1024*1024))
 		}
 	}
 
@@ -141,97 +160,171 @@ func run(cfg *Config) error {
 	return nil
 }
 
-func generateLogFile(root *os.Root, filename string, lineCount int, rng *rand.Rand) (int64, error) {
+// fileResult is the outcome of generating a single log file.
+type fileResult struct {
+	filename string
+	lines    int
+	size     int64
+	err      error
+}
+
+// generateFiles writes all log files with a pool of cfg.Workers goroutines and
+// streams one fileResult per file in completion order.
+//
+// Each file gets its own generator seeded from (cfg.Seed, file index), so the
+// content of every file is the same no matter how many workers run or in
+// which order they pick up files. A nil chain selects the random model.
+func generateFiles(root *os.Root, cfg *Config, profile *Profile, chain *sessionChain) <-chan fileResult {
+	numWorkers := max(cfg.Workers, 1)
+	lines := lineCounts(cfg)
+	jobs := make(chan int, numWorkers)
+	results := make(chan fileResult, numWorkers)
+
+	var wg sync.WaitGroup
+	for range numWorkers {
+		wg.Go(func() {
+			for index := range jobs {
+				filename := fmt.Sprintf("access_%03d.json", index)
+				rng := rand.New(rand.NewPCG(cfg.Seed, uint64(index)))
+				var source entrySource = &randomSource{profile: profile, rng: rng}
+				if chain != nil {
+					source = newSessionSource(profile, chain, lines[index], rng)
+				}
+				written, size, err := generateLogFile(root, filename, lines[index], source)
+				results <- fileResult{filename: filename, lines: written, size: size, err: err}
+			}
+		})
+	}
+
+	go func() {
+		defer close(jobs)
+		for i := 1; i <= cfg.FileCount; i++ {
+			jobs <- i
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
+
+// lineCounts returns the number of lines of every file, indexed from 1.
+// Without skew every file has cfg.LinesPerFile lines. With skew s, file i
+// gets a share proportional to (FileCount-i+1)^-s of the same total, so
+// sizes grow towards the last file: the worst case for a pool that
+// dispatches files in listing order.
+func lineCounts(cfg *Config) []int {
+	lines := make([]int, cfg.FileCount+1)
+	if cfg.Skew == 0 {
+		for i := range lines {
+			lines[i] = cfg.LinesPerFile
+		}
+		return lines
+	}
+
+	weights := make([]float64, cfg.FileCount+1)
+	sum := 0.0
+	for i := 1; i <= cfg.FileCount; i++ {
+		weights[i] = math.Pow(float64(cfg.FileCount-i+1), -cfg.Skew)
+		sum += weights[i]
+	}
+	total := float64(cfg.FileCount * cfg.LinesPerFile)
+	for i := 1; i <= cfg.FileCount; i++ {
+		lines[i] = max(int(math.Round(total*weights[i]/sum)), 1)
+	}
+	return lines
+}
+
+// generateLogFile writes up to lineCount entries of source and returns the
+// number of lines written and the size of the file.
+func generateLogFile(root *os.Root, filename string, lineCount int, source entrySource) (int, int64, error) {
 	file, err := root.Create(filename)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	encoder.SetEscapeHTML(false)
+	writer := bufio.NewWriterSize(file, 256*1024)
+	var line []byte
 
-	for i := 0; i < lineCount; i++ {
-		entry := generateLogEntry(rng)
-		if err := encoder.Encode(entry); err != nil {
-			return 0, err
+	written := 0
+	for written < lineCount {
+		entry, ok := source.next()
+		if !ok {
+			break
+		}
+		line = appendLogEntry(line[:0], &entry)
+		if _, err := writer.Write(line); err != nil {
+			return 0, 0, err
 		}
+		written++
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, 0, err
 	}
 
 	info, err := file.Stat()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return info.Size(), nil
+	return written, info.Size(), nil
+}
+
+// randomSource draws every field of every entry independently.
+type randomSource struct {
+	profile *Profile
+	rng     *rand.Rand
 }
 
-func generateLogEntry(rng *rand.Rand) LogEntry {
-	status := weightedRandom(statuses, statusWeights, rng)
+func (s *randomSource) next() (LogEntry, bool) {
+	return generateLogEntry(s.profile, s.rng), true
+}
+
+func generateLogEntry(profile *Profile, rng *rand.Rand) LogEntry {
+	status := weightedRandom(profile.Statuses, rng).Value
+	at := generateTime(profile.TimeRange, rng)
 
-	return LogEntry{
-		Timestamp:      generateTimestamp(rng),
-		Method:         weightedRandom(methods, methodWeights, rng),
-		Path:           generatePath(rng),
+	entry := LogEntry{
+		Method:         weightedRandom(profile.Methods, rng).Value,
+		Path:           weightedRandom(profile.Paths, rng).render(rng),
 		Status:         status,
-		ResponseTimeMs: generateResponseTime(rng),
+		ResponseTimeMs: generateResponseTime(profile.ResponseTime, rng),
 		Bytes:          generateBytes(status, rng),
-		UserID:         fmt.Sprintf("user_%d", rng.IntN(1000000)),
+		UserID:         fmt.Sprintf("user_%d", profile.UserIDs.draw(rng)),
 		IP:             generateIP(rng),
 	}
+	at = profile.applyIncidents(&entry, at, rng)
+	entry.Timestamp = at.Format(time.RFC3339Nano)
+	return entry
 }
 
-func generateTimestamp(rng *rand.Rand) string {
-	// Range: 2025-01-10 00:00:00 to 2025-01-15 23:59:59 UTC
-	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
-	end := time.Date(2025, 1, 15, 23, 59, 59, 0, time.UTC)
-
-	delta := end.Unix() - start.Unix()
+func generateTime(tr TimeRange, rng *rand.Rand) time.Time {
+	delta := tr.End.Unix() - tr.Start.Unix()
 	sec := rng.Int64N(delta)
-	t := start.Add(time.Duration(sec) * time.Second)
+	t := tr.Start.Add(time.Duration(sec) * time.Second)
 
 	ms := rng.IntN(1000)
-	t = t.Add(time.Duration(ms) * time.Millisecond)
-
-	return t.Format(time.RFC3339Nano)
-}
-
-func generatePath(rng *rand.Rand) string {
-	pattern := weightedRandom(paths, pathWeights, rng)
-
-	switch pattern {
-	case "/api/users/%d":
-		return fmt.Sprintf(pattern, rng.IntN(1000)+1)
-	case "/api/products/%d":
-		return fmt.Sprintf(pattern, rng.IntN(5000)+1)
-	case "/api/orders/%d":
-		return fmt.Sprintf(pattern, rng.IntN(10000)+1)
-	case "/static/%s":
-		files := []string{"app.js", "style.css", "logo.png", "favicon.ico", "bundle.js"}
-		return fmt.Sprintf(pattern, files[rng.IntN(len(files))])
-	default:
-		return pattern
-	}
+	return t.Add(time.Duration(ms) * time.Millisecond)
 }
 
-func generateResponseTime(rng *rand.Rand) int {
-	// Normal distribution: mean=100ms, stdDev=200ms
-	mean := 100.0
-	stdDev := 200.0
-
+func generateResponseTime(spec ResponseTimeSpec, rng *rand.Rand) int {
 	// Box-Muller transform for normal distribution
 	u1 := rng.Float64()
 	u2 := rng.Float64()
 	z := math.Sqrt(-2*math.Log(u1)) * math.Cos(2*math.Pi*u2)
 
-	value := mean + stdDev*z
+	value := spec.MeanMs + spec.StdDevMs*z
 
-	// Clamp to 1-5000ms
-	if value < 1 {
-		value = 1
+	// Clamp to the configured range
+	if value < spec.MinMs {
+		value = spec.MinMs
 	}
-	if value > 5000 {
-		value = 5000
+	if value > spec.MaxMs {
+		value = spec.MaxMs
 	}
 
 	return int(value)
@@ -280,21 +373,21 @@ func generateIP(rng *rand.Rand) string {
 	}
 }
 
-func weightedRandom[T any](items []T, weights []int, rng *rand.Rand) T {
+func weightedRandom[W weighted](items []W, rng *rand.Rand) W {
 	// Calculate cumulative weights
 	total := 0
-	for _, w := range weights {
-		total += w
+	for _, item := range items {
+		total += item.weight()
 	}
 
 	// Random selection
 	r := rng.IntN(total)
 	cumulative := 0
 
-	for i, w := range weights {
-		cumulative += w
+	for _, item := range items {
+		cumulative += item.weight()
 		if r < cumulative {
-			return items[i]
+			return item
 		}
 	}
 
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path template placeholders understood by PathSpec.
+const (
+	placeholderID    = "{id}"
+	placeholderValue = "{value}"
+)
+
+// Profile describes the distributions log entries are drawn from.
+// Every top-level field can be overridden from a JSON profile file; fields
+// that are omitted keep the values of DefaultProfile.
+type Profile struct {
+	Methods      []Weighted[string] `json:"methods"`
+	Paths        []PathSpec         `json:"paths"`
+	Statuses     []Weighted[int]    `json:"statuses"`
+	TimeRange    TimeRange          `json:"time_range"`
+	ResponseTime ResponseTimeSpec   `json:"response_time"`
+	UserIDs      IntRange           `json:"user_ids"`
+	Sessions     SessionModel       `json:"sessions"`
+	Incidents    []Incident         `json:"incidents"`
+}
+
+// Weighted is a value picked with probability weight/sum(weights).
+type Weighted[T any] struct {
+	Value  T   `json:"value"`
+	Weight int `json:"weight"`
+}
+
+// weighted is implemented by profile entries that can be picked by weightedRandom.
+type weighted interface {
+	weight() int
+}
+
+func (w Weighted[T]) weight() int { return w.Weight }
+
+// PathSpec is a weighted path template.
+// "{id}" is replaced by an integer drawn from IDs and "{value}" by an
+// element of Values.
+type PathSpec struct {
+	Template string    `json:"template"`
+	Weight   int       `json:"weight"`
+	IDs      *IntRange `json:"ids,omitempty"`
+	Values   []string  `json:"values,omitempty"`
+}
+
+func (s PathSpec) weight() int { return s.Weight }
+
+// TimeRange is the window timestamps are drawn from uniformly.
+type TimeRange struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// ResponseTimeSpec is a normal distribution clamped to [MinMs, MaxMs].
+type ResponseTimeSpec struct {
+	MeanMs   float64 `json:"mean_ms"`
+	StdDevMs float64 `json:"stddev_ms"`
+	MinMs    float64 `json:"min_ms"`
+	MaxMs    float64 `json:"max_ms"`
+}
+
+// IntRange is an inclusive integer range.
+type IntRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// DefaultProfile returns the built-in profile used when no profile file is given.
+func DefaultProfile() *Profile {
+	return &Profile{
+		Methods: []Weighted[string]{
+			{"GET", 70}, {"POST", 20}, {"PUT", 5}, {"DELETE", 3}, {"PATCH", 2},
+		},
+		Paths: []PathSpec{
+			{Template: "/api/users", Weight: 15},
+			{Template: "/api/users/{id}", Weight: 10, IDs: &IntRange{1, 1000}},
+			{Template: "/api/products", Weight: 15},
+			{Template: "/api/products/{id}", Weight: 10, IDs: &IntRange{1, 5000}},
+			{Template: "/api/orders", Weight: 10},
+			{Template: "/api/orders/{id}", Weight: 5, IDs: &IntRange{1, 10000}},
+			{Template: "/api/auth/login", Weight: 8},
+			{Template: "/api/auth/logout", Weight: 3},
+			{Template: "/api/search", Weight: 7},
+			{Template: "/api/health", Weight: 5},
+			{Template: "/api/metrics", Weight: 2},
+			{Template: "/", Weight: 5},
+			{Template: "/static/{value}", Weight: 5, Values: []string{"app.js", "style.css", "logo.png", "favicon.ico", "bundle.js"}},
+		},
+		Statuses: []Weighted[int]{
+			{200, 75}, {201, 5}, {204, 2}, {301, 1}, {302, 1}, {400, 3},
+			{401, 2}, {403, 1}, {404, 5}, {500, 3}, {502, 1}, {503, 1},
+		},
+		TimeRange: TimeRange{
+			Start: time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC),
+			End:   time.Date(2025, 1, 15, 23, 59, 59, 0, time.UTC),
+		},
+		ResponseTime: ResponseTimeSpec{MeanMs: 100, StdDevMs: 200, MinMs: 1, MaxMs: 5000},
+		UserIDs:      IntRange{0, 999999},
+		Sessions:     defaultSessionModel(),
+	}
+}
+
+// LoadProfile reads a JSON profile file on top of DefaultProfile and validates it.
+// Each top-level field present in the file replaces the default as a whole.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile: %w", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse profile %s: %w", path, err)
+	}
+
+	// Decode into a zero Profile instead of the defaults, so that slices in
+	// the file never get merged into the default slices element by element.
+	var loaded Profile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&loaded); err != nil {
+		return nil, fmt.Errorf("failed to parse profile %s: %w", path, err)
+	}
+
+	profile := DefaultProfile()
+	for name := range fields {
+		switch name {
+		case "methods":
+			profile.Methods = loaded.Methods
+		case "paths":
+			profile.Paths = loaded.Paths
+		case "statuses":
+			profile.Statuses = loaded.Statuses
+		case "time_range":
+			profile.TimeRange = loaded.TimeRange
+		case "response_time":
+			profile.ResponseTime = loaded.ResponseTime
+		case "user_ids":
+			profile.UserIDs = loaded.UserIDs
+		case "sessions":
+			profile.Sessions = loaded.Sessions
+		case "incidents":
+			profile.Incidents = loaded.Incidents
+		}
+	}
+
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", path, err)
+	}
+	return profile, nil
+}
+
+// Validate reports every problem found in the profile.
+// The session model is only checked by compileSessions, since profiles that
+// are used with the random model do not need one.
+func (p *Profile) Validate() error {
+	var errs []error
+
+	errs = append(errs, validateWeights("methods", p.Methods)...)
+	errs = append(errs, validateWeights("statuses", p.Statuses)...)
+
+	errs = append(errs, validateWeights("paths", p.Paths)...)
+	for i, spec := range p.Paths {
+		errs = append(errs, spec.validate(fmt.Sprintf("paths[%d]", i))...)
+	}
+
+	for i, s := range p.Statuses {
+		if s.Value < 100 || s.Value > 599 {
+			errs = append(errs, fmt.Errorf("statuses[%d]: status %d out of range 100-599", i, s.Value))
+		}
+	}
+
+	// Timestamps are drawn by the second, so a range needs at least one.
+	if p.TimeRange.End.Sub(p.TimeRange.Start) < time.Second {
+		errs = append(errs, fmt.Errorf("time_range: start %s must be at least one second before end %s",
+			p.TimeRange.Start.Format(time.RFC3339Nano), p.TimeRange.End.Format(time.RFC3339Nano)))
+	}
+
+	rt := p.ResponseTime
+	if rt.StdDevMs < 0 {
+		errs = append(errs, fmt.Errorf("response_time: stddev_ms must not be negative, got %g", rt.StdDevMs))
+	}
+	if rt.MinMs < 0 || rt.MinMs > rt.MaxMs {
+		errs = append(errs, fmt.Errorf("response_time: need 0 <= min_ms <= max_ms, got %g..%g", rt.MinMs, rt.MaxMs))
+	}
+
+	if err := p.UserIDs.validate("user_ids"); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, p.validateIncidents()...)
+
+	return errors.Join(errs...)
+}
+
+func validateWeights[W weighted](field string, items []W) []error {
+	if len(items) == 0 {
+		return []error{fmt.Errorf("%s: at least one entry is required", field)}
+	}
+
+	var errs []error
+	total := 0
+	for i, item := range items {
+		if item.weight() < 0 {
+			errs = append(errs, fmt.Errorf("%s[%d]: weight must not be negative, got %d", field, i, item.weight()))
+			continue
+		}
+		total += item.weight()
+	}
+	if total == 0 {
+		errs = append(errs, fmt.Errorf("%s: weights must sum to a positive number", field))
+	}
+	return errs
+}
+
+func (s PathSpec) validate(field string) []error {
+	var errs []error
+	if !strings.HasPrefix(s.Template, "/") {
+		errs = append(errs, fmt.Errorf("%s: template %q must start with /", field, s.Template))
+	}
+
+	rest := strings.ReplaceAll(s.Template, placeholderID, "")
+	rest = strings.ReplaceAll(rest, placeholderValue, "")
+	if strings.ContainsAny(rest, "{}") {
+		errs = append(errs, fmt.Errorf("%s: template %q has an unknown placeholder (want %s or %s)",
+			field, s.Template, placeholderID, placeholderValue))
+	}
+
+	if strings.Contains(s.Template, placeholderID) {
+		if s.IDs == nil {
+			errs = append(errs, fmt.Errorf("%s: template %q uses %s but has no ids range", field, s.Template, placeholderID))
+		} else if err := s.IDs.validate(field + ".ids"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if strings.Contains(s.Template, placeholderValue) && len(s.Values) == 0 {
+		errs = append(errs, fmt.Errorf("%s: template %q uses %s but has no values", field, s.Template, placeholderValue))
+	}
+	return errs
+}
+
+func (r IntRange) validate(field string) error {
+	if r.Min < 0 || r.Min > r.Max {
+		return fmt.Errorf("%s: need 0 <= min <= max, got %d..%d", field, r.Min, r.Max)
+	}
+	return nil
+}
+
+// draw returns a uniformly distributed integer in [Min, Max].
+func (r IntRange) draw(rng *rand.Rand) int {
+	return r.Min + rng.IntN(r.Max-r.Min+1)
+}
+
+// render expands the placeholders of the template.
+func (s PathSpec) render(rng *rand.Rand) string {
+	if !strings.Contains(s.Template, "{") {
+		return s.Template
+	}
+
+	var b strings.Builder
+	rest := s.Template
+	for {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			b.WriteString(rest)
+			return b.String()
+		}
+		b.WriteString(rest[:i])
+		rest = rest[i:]
+
+		switch {
+		case strings.HasPrefix(rest, placeholderID):
+			b.WriteString(strconv.Itoa(s.IDs.draw(rng)))
+			rest = rest[len(placeholderID):]
+		case strings.HasPrefix(rest, placeholderValue):
+			b.WriteString(s.Values[rng.IntN(len(s.Values))])
+			rest = rest[len(placeholderValue):]
+		default:
+			b.WriteByte('{')
+			rest = rest[1:]
+		}
+	}
+}
+
+// matches reports whether path could have been rendered from the template.
+func (s PathSpec) matches(path string) bool {
+	return matchTemplate(s.Template, path, s.Values)
+}
+
+func matchTemplate(template, path string, values []string) bool {
+	i := strings.IndexByte(template, '{')
+	if i < 0 {
+		return template == path
+	}
+	if !strings.HasPrefix(path, template[:i]) {
+		return false
+	}
+	template, path = template[i:], path[i:]
+
+	switch {
+	case strings.HasPrefix(template, placeholderID):
+		digits := 0
+		for digits < len(path) && path[digits] >= '0' && path[digits] <= '9' {
+			digits++
+		}
+		return digits > 0 && matchTemplate(template[len(placeholderID):], path[digits:], values)
+	case strings.HasPrefix(template, placeholderValue):
+		for _, v := range values {
+			if strings.HasPrefix(path, v) && matchTemplate(template[len(placeholderValue):], path[len(v):], values) {
+				return true
+			}
+		}
+		return false
+	default:
+		return strings.HasPrefix(path, "{") && matchTemplate(template[1:], path[1:], values)
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// sessionExit is the pseudo state that ends a session in SessionModel.
+const sessionExit = "exit"
+
+// SessionModel describes user sessions as a Markov chain over path templates.
+// A session starts in one of Start, issues one request per visited state and
+// waits a think time between requests until it moves to "exit".
+type SessionModel struct {
+	Start     []Weighted[string] `json:"start"`
+	States    []SessionState     `json:"states"`
+	ThinkTime ThinkTimeSpec      `json:"think_time"`
+}
+
+// SessionState is a node of the session chain.
+// Path must match the template of one of Profile.Paths. Methods overrides
+// Profile.Methods for requests made in this state.
+type SessionState struct {
+	Path    string             `json:"path"`
+	Methods []Weighted[string] `json:"methods,omitempty"`
+	Next    []Weighted[string] `json:"next"`
+}
+
+// ThinkTimeSpec is an exponential distribution capped at MaxMs.
+type ThinkTimeSpec struct {
+	MeanMs float64 `json:"mean_ms"`
+	MaxMs  float64 `json:"max_ms"`
+}
+
+// defaultSessionModel is a small shop: browse, search, log in and order.
+func defaultSessionModel() SessionModel {
+	get := []Weighted[string]{{"GET", 1}}
+	post := []Weighted[string]{{"POST", 1}}
+
+	return SessionModel{
+		Start: []Weighted[string]{
+			{"/", 50}, {"/api/products", 20}, {"/api/auth/login", 20}, {"/api/search", 10},
+		},
+		States: []SessionState{
+			{Path: "/", Methods: get, Next: []Weighted[string]{
+				{"/static/{value}", 30}, {"/api/products", 30}, {"/api/search", 15}, {"/api/auth/login", 20}, {sessionExit, 5},
+			}},
+			{Path: "/static/{value}", Methods: get, Next: []Weighted[string]{
+				{"/static/{value}", 40}, {"/api/products", 35}, {"/api/auth/login", 15}, {sessionExit, 10},
+			}},
+			{Path: "/api/auth/login", Methods: post, Next: []Weighted[string]{
+				{"/api/products", 40}, {"/api/users/{id}", 15}, {"/api/search", 20}, {"/api/orders", 15}, {sessionExit, 10},
+			}},
+			{Path: "/api/products", Methods: get, Next: []Weighted[string]{
+				{"/api/products/{id}", 55}, {"/api/search", 15}, {"/api/products", 10}, {sessionExit, 20},
+			}},
+			{Path: "/api/products/{id}", Methods: get, Next: []Weighted[string]{
+				{"/api/products/{id}", 25}, {"/api/orders", 20}, {"/api/products", 25}, {"/api/search", 10}, {sessionExit, 20},
+			}},
+			{Path: "/api/search", Methods: get, Next: []Weighted[string]{
+				{"/api/products/{id}", 50}, {"/api/search", 20}, {sessionExit, 30},
+			}},
+			{Path: "/api/orders", Methods: []Weighted[string]{{"POST", 80}, {"GET", 20}}, Next: []Weighted[string]{
+				{"/api/orders/{id}", 50}, {"/api/products", 20}, {"/api/auth/logout", 15}, {sessionExit, 15},
+			}},
+			{Path: "/api/orders/{id}", Methods: get, Next: []Weighted[string]{
+				{"/api/products", 30}, {"/api/auth/logout", 30}, {sessionExit, 40},
+			}},
+			{Path: "/api/users/{id}", Methods: []Weighted[string]{{"GET", 80}, {"PUT", 15}, {"PATCH", 5}}, Next: []Weighted[string]{
+				{"/api/orders", 30}, {"/api/products", 40}, {sessionExit, 30},
+			}},
+			{Path: "/api/auth/logout", Methods: post, Next: []Weighted[string]{
+				{sessionExit, 1},
+			}},
+		},
+		ThinkTime: ThinkTimeSpec{MeanMs: 8000, MaxMs: 120000},
+	}
+}
+
+// sessionChain is a SessionModel resolved against the profile paths.
+type sessionChain struct {
+	start  []Weighted[int]
+	states []chainState
+}
+
+type chainState struct {
+	path    PathSpec
+	methods []Weighted[string]
+	next    []Weighted[int] // -1 means exit
+}
+
+// compileSessions resolves the session model of the profile and reports every
+// inconsistency, such as states that reference unknown paths or a chain that
+// never exits.
+func compileSessions(p *Profile) (*sessionChain, error) {
+	model := p.Sessions
+	var errs []error
+	if len(p.Incidents) > 0 {
+		// Moving requests into an incident would break the sessions up.
+		errs = append(errs, errors.New("incidents: only the random model injects incidents"))
+	}
+
+	paths := make(map[string]PathSpec, len(p.Paths))
+	for _, spec := range p.Paths {
+		paths[spec.Template] = spec
+	}
+
+	index := make(map[string]int, len(model.States))
+	for i, state := range model.States {
+		if _, ok := index[state.Path]; ok {
+			errs = append(errs, fmt.Errorf("sessions.states[%d]: duplicate state %q", i, state.Path))
+		}
+		index[state.Path] = i
+	}
+
+	resolve := func(field string, items []Weighted[string], allowExit bool) []Weighted[int] {
+		errs = append(errs, validateWeights(field, items)...)
+		resolved := make([]Weighted[int], 0, len(items))
+		for i, item := range items {
+			target, ok := index[item.Value]
+			switch {
+			case ok:
+			case allowExit && item.Value == sessionExit:
+				target = -1
+			default:
+				errs = append(errs, fmt.Errorf("%s[%d]: unknown state %q", field, i, item.Value))
+			}
+			resolved = append(resolved, Weighted[int]{target, item.Weight})
+		}
+		return resolved
+	}
+
+	chain := &sessionChain{
+		start:  resolve("sessions.start", model.Start, false),
+		states: make([]chainState, len(model.States)),
+	}
+	for i, state := range model.States {
+		field := fmt.Sprintf("sessions.states[%d]", i)
+		spec, ok := paths[state.Path]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: path %q is not one of the profile paths", field, state.Path))
+		}
+		methods := state.Methods
+		if len(methods) == 0 {
+			methods = p.Methods
+		} else {
+			errs = append(errs, validateWeights(field+".methods", methods)...)
+		}
+		chain.states[i] = chainState{
+			path:    spec,
+			methods: methods,
+			next:    resolve(field+".next", state.Next, true),
+		}
+	}
+
+	if model.ThinkTime.MeanMs < 0 || model.ThinkTime.MaxMs < model.ThinkTime.MeanMs {
+		errs = append(errs, fmt.Errorf("sessions.think_time: need 0 <= mean_ms <= max_ms, got %g..%g",
+			model.ThinkTime.MeanMs, model.ThinkTime.MaxMs))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	for _, i := range chain.trapped() {
+		errs = append(errs, fmt.Errorf("sessions.states[%d]: state %q never leads to exit", i, model.States[i].Path))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return chain, nil
+}
+
+// reachable reports which states sessions can visit.
+func (c *sessionChain) reachable() []bool {
+	seen := make([]bool, len(c.states))
+	var stack []int
+	visit := func(edges []Weighted[int]) {
+		for _, e := range edges {
+			if e.Value >= 0 && e.Weight > 0 && !seen[e.Value] {
+				seen[e.Value] = true
+				stack = append(stack, e.Value)
+			}
+		}
+	}
+	visit(c.start)
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		visit(c.states[i].next)
+	}
+	return seen
+}
+
+// trapped returns the states that sessions can visit but that do not lead
+// to exit, in order. A session in one of them would never end.
+func (c *sessionChain) trapped() []int {
+	// Search backwards from exit along the edges of positive weight.
+	prev := make([][]int, len(c.states))
+	exits := make([]bool, len(c.states))
+	var stack []int
+	for i, s := range c.states {
+		for _, e := range s.next {
+			switch {
+			case e.Weight == 0:
+			case e.Value >= 0:
+				prev[e.Value] = append(prev[e.Value], i)
+			case !exits[i]:
+				exits[i] = true
+				stack = append(stack, i)
+			}
+		}
+	}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, p := range prev[i] {
+			if !exits[p] {
+				exits[p] = true
+				stack = append(stack, p)
+			}
+		}
+	}
+
+	var trapped []int
+	for i, ok := range c.reachable() {
+		if ok && !exits[i] {
+			trapped = append(trapped, i)
+		}
+	}
+	return trapped
+}
+
+// expectedLength returns the mean number of requests per session. No
+// state may be trapped.
+//
+// The expected number of requests x[i] of a session entering state i is
+// 1 + sum_j P(i, j) x[j], so x solves (I - P) x = 1, which has a single
+// solution over the reachable states as they all lead to exit.
+func (c *sessionChain) expectedLength() float64 {
+	n := len(c.states)
+	reachable := c.reachable()
+	// a is the augmented matrix of the system. Unreachable states get
+	// x[i] = 0; reachable ones never move to them.
+	a := make([][]float64, n)
+	for i, s := range c.states {
+		a[i] = make([]float64, n+1)
+		a[i][i] = 1
+		if !reachable[i] {
+			continue
+		}
+		a[i][n] = 1
+		total := 0
+		for _, e := range s.next {
+			total += e.Weight
+		}
+		for _, e := range s.next {
+			if e.Value >= 0 {
+				a[i][e.Value] -= float64(e.Weight) / float64(total)
+			}
+		}
+	}
+
+	// Gaussian elimination with partial pivoting.
+	for col := range n {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := range n {
+			if row == col || a[row][col] == 0 {
+				continue
+			}
+			f := a[row][col] / a[col][col]
+			for k := col; k <= n; k++ {
+				a[row][k] -= f * a[col][k]
+			}
+		}
+	}
+
+	total := 0
+	for _, s := range c.start {
+		total += s.Weight
+	}
+	expected := 0.0
+	for _, s := range c.start {
+		expected += float64(s.Weight) / float64(total) * a[s.Value][n] / a[s.Value][s.Value]
+	}
+	return expected
+}
+
+// session is a user currently browsing.
+type session struct {
+	at     int64 // Unix milliseconds of the next request
+	seq    uint64
+	state  int
+	userID string
+	ip     string
+}
+
+// sessionHeap orders active sessions by their next request time.
+type sessionHeap []*session
+
+func (h sessionHeap) Len() int { return len(h) }
+func (h sessionHeap) Less(i, j int) bool {
+	if h[i].at != h[j].at {
+		return h[i].at < h[j].at
+	}
+	return h[i].seq < h[j].seq
+}
+func (h sessionHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *sessionHeap) Push(x any)   { *h = append(*h, x.(*session)) }
+func (h *sessionHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// sessionSource emits the requests of overlapping sessions in time order.
+// Sessions arrive as a Poisson process whose rate is chosen so that
+// lineCount requests roughly span the profile time range. A session ends
+// early rather than send a request after the time range, so the source may
+// run out before lineCount requests.
+type sessionSource struct {
+	profile *Profile
+	chain   *sessionChain
+	rng     *rand.Rand
+	end     int64 // Unix milliseconds of the end of the time range
+
+	active           sessionHeap
+	nextArrival      float64 // Unix milliseconds
+	meanInterArrival float64 // milliseconds
+	seq              uint64
+}
+
+func newSessionSource(profile *Profile, chain *sessionChain, lineCount int, rng *rand.Rand) *sessionSource {
+	start := float64(profile.TimeRange.Start.UnixMilli())
+	span := float64(profile.TimeRange.End.UnixMilli()) - start
+	sessions := max(float64(lineCount)/chain.expectedLength(), 1)
+
+	s := &sessionSource{
+		profile:          profile,
+		chain:            chain,
+		rng:              rng,
+		end:              profile.TimeRange.End.UnixMilli(),
+		meanInterArrival: span / sessions,
+	}
+	s.nextArrival = start + rng.ExpFloat64()*s.meanInterArrival
+	return s
+}
+
+func (s *sessionSource) next() (LogEntry, bool) {
+	if int64(s.nextArrival) < s.end && (len(s.active) == 0 || int64(s.nextArrival) <= s.active[0].at) {
+		s.arrive()
+	}
+	if len(s.active) == 0 {
+		return LogEntry{}, false
+	}
+
+	sess := s.active[0]
+	state := s.chain.states[sess.state]
+
+	status := weightedRandom(s.profile.Statuses, s.rng).Value
+	responseTime := generateResponseTime(s.profile.ResponseTime, s.rng)
+	entry := LogEntry{
+		Timestamp:      time.UnixMilli(sess.at).UTC().Format(time.RFC3339Nano),
+		Method:         weightedRandom(state.methods, s.rng).Value,
+		Path:           state.path.render(s.rng),
+		Status:         status,
+		ResponseTimeMs: responseTime,
+		Bytes:          generateBytes(status, s.rng),
+		UserID:         sess.userID,
+		IP:             sess.ip,
+	}
+
+	next := weightedRandom(state.next, s.rng).Value
+	at := sess.at + int64(responseTime) + s.thinkTime()
+	if next < 0 || at >= s.end {
+		heap.Pop(&s.active)
+	} else {
+		sess.state = next
+		sess.at = at
+		heap.Fix(&s.active, 0)
+	}
+	return entry, true
+}
+
+// arrive starts a new session at the next arrival time.
+func (s *sessionSource) arrive() {
+	s.seq++
+	heap.Push(&s.active, &session{
+		at:     int64(s.nextArrival),
+		seq:    s.seq,
+		state:  weightedRandom(s.chain.start, s.rng).Value,
+		userID: fmt.Sprintf("user_%d", s.profile.UserIDs.draw(s.rng)),
+		ip:     generateIP(s.rng),
+	})
+	s.nextArrival += s.rng.ExpFloat64() * s.meanInterArrival
+}
+
+func (s *sessionSource) thinkTime() int64 {
+	think := s.rng.ExpFloat64() * s.profile.Sessions.ThinkTime.MeanMs
+	return int64(min(think, s.profile.Sessions.ThinkTime.MaxMs))
+}
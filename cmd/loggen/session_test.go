@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// TestCompileSessionsSlowExit checks that a chain leaving a loop only once
+// in a long while is accepted, with its exact mean session length.
+func TestCompileSessionsSlowExit(t *testing.T) {
+	p, err := LoadProfile("profiles/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every session ends with a checkout, which repeats 100000 times on
+	// average.
+	p.Sessions.Start = []Weighted[string]{{"/api/v2/checkout", 1}}
+	p.Sessions.States[4].Next = []Weighted[string]{{"/api/v2/checkout", 99999}, {sessionExit, 1}}
+
+	chain, err := compileSessions(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := chain.expectedLength(); math.Abs(got-100000) > 1e-6 {
+		t.Errorf("got a mean session length of %g, want 100000", got)
+	}
+}
+
+// TestCompileSessionsTrapped checks that a state from which sessions can
+// never exit is reported.
+func TestCompileSessionsTrapped(t *testing.T) {
+	p, err := LoadProfile("profiles/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Sessions.States[4].Next = []Weighted[string]{{"/api/v2/checkout", 1}, {sessionExit, 0}}
+
+	_, err = compileSessions(p)
+	if err == nil || !strings.Contains(err.Error(), "sessions.states[4]") {
+		t.Errorf("got error %v, want one about sessions.states[4]", err)
+	}
+}
@@ -0,0 +1,286 @@
+// logredact rewrites access logs so that they can be shared: user IDs are
+// pseudonymized with a keyed HMAC, IPs are truncated to a prefix or
+// hashed, and numeric IDs in paths are replaced, as set by a policy file.
+//
+//	LOGREDACT_KEY=... go run ./cmd/logredact --in=./logs --out=./logs-redacted
+//
+// Files are read in newline-aligned chunks that a pool of workers redacts
+// in parallel. A writer per file puts the chunks back in sequence, so the
+// lines of every output file are in the order of the input.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// keyEnv is the environment variable holding the HMAC key when no key file
+// is given.
+const keyEnv = "LOGREDACT_KEY"
+
+// minKeyLength is the shortest accepted HMAC key. User IDs such as
+// user_123 are easy to enumerate, so a short key would let anyone rebuild
+// the pseudonyms.
+const minKeyLength = 16
+
+func main() {
+	inDir := flag.String("in", "./logs", "Directory of the logs to redact")
+	outDir := flag.String("out", "./logs-redacted", "Directory of the redacted logs (created if needed)")
+	policyPath := flag.String("policy", "", "Policy file (JSON); empty uses the default policy")
+	keyFile := flag.String("key-file", "", "File holding the HMAC key (default: $"+keyEnv+")")
+	workers := flag.Int("workers", runtime.GOMAXPROCS(0), "Number of goroutines redacting chunks")
+	chunkSize := flag.Int("chunk", engine.DefaultChunkSize, "Bytes per chunk handed to a worker")
+	flag.Parse()
+
+	if err := run(*inDir, *outDir, *policyPath, *keyFile, max(*workers, 1), *chunkSize); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(inDir, outDir, policyPath, keyFile string, numWorkers, chunkSize int) error {
+	policy := DefaultPolicy()
+	if policyPath != "" {
+		var err error
+		if policy, err = LoadPolicy(policyPath); err != nil {
+			return err
+		}
+	}
+	var key []byte
+	if policy.NeedsKey() {
+		var err error
+		if key, err = loadKey(keyFile); err != nil {
+			return err
+		}
+	}
+
+	if same, err := sameDir(inDir, outDir); err != nil {
+		return err
+	} else if same {
+		return fmt.Errorf("-out must differ from -in")
+	}
+	in, err := os.OpenRoot(inDir)
+	if err != nil {
+		return fmt.Errorf("failed to open log directory: %w", err)
+	}
+	defer in.Close()
+	files, err := engine.ListLogFiles(in)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if engine.IsSegment(name) {
+			return fmt.Errorf("%s is a segment; redact the JSON logs before converting them", name)
+		}
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	out, err := os.OpenRoot(outDir)
+	if err != nil {
+		return fmt.Errorf("failed to open output directory: %w", err)
+	}
+	defer out.Close()
+
+	startTime := time.Now()
+	p := &pipeline{
+		in:       in,
+		out:      out,
+		reader:   engine.BufferedReader{ChunkSize: chunkSize},
+		redactor: NewRedactor(policy, key),
+	}
+	err = p.run(files, numWorkers)
+
+	fmt.Printf("Redacted %d files, %d lines in %.2fs\n", len(files), p.lines.Load(), time.Since(startTime).Seconds())
+	if n := p.dropped.Load(); n > 0 {
+		fmt.Printf("Dropped %d lines that are not valid log entries\n", n)
+	}
+	return err
+}
+
+// loadKey reads the HMAC key from keyFile, or from $LOGREDACT_KEY.
+func loadKey(keyFile string) ([]byte, error) {
+	var key []byte
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key: %w", err)
+		}
+		key = bytes.TrimRight(data, "\r\n")
+	} else {
+		key = []byte(os.Getenv(keyEnv))
+	}
+	if len(key) < minKeyLength {
+		return nil, fmt.Errorf("the policy hashes fields and needs a key of at least %d bytes in -key-file or $%s", minKeyLength, keyEnv)
+	}
+	return key, nil
+}
+
+func sameDir(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return absA == absB, nil
+}
+
+// chunk is a run of whole lines of one file, numbered in file order.
+type chunk struct {
+	file *output
+	seq  int
+	data []byte // the input lines, then the redacted lines
+}
+
+// output is a file being written. Its writer receives the redacted chunks
+// in any order and writes them in sequence.
+type output struct {
+	name    string
+	results chan *chunk
+	pending sync.WaitGroup // chunks read but not redacted yet
+}
+
+type pipeline struct {
+	in, out  *os.Root
+	reader   engine.BufferedReader
+	redactor *Redactor
+
+	lines   atomic.Int64
+	dropped atomic.Int64
+}
+
+// run redacts every file. Chunks of several files may be in flight at once,
+// but no more than twice the number of workers, which bounds memory when a
+// chunk is slow to redact and the ones after it wait to be written.
+func (p *pipeline) run(files []string, numWorkers int) error {
+	jobs := make(chan *chunk, numWorkers)
+	inFlight := make(chan struct{}, 2*numWorkers)
+
+	var workers sync.WaitGroup
+	for range numWorkers {
+		workers.Go(func() {
+			for c := range jobs {
+				c.data = p.redactChunk(c.data)
+				c.file.results <- c
+				c.file.pending.Done()
+			}
+		})
+	}
+
+	var writers sync.WaitGroup
+	readErrs := make([]error, len(files))
+	writeErrs := make([]error, len(files))
+	for i, name := range files {
+		f := &output{name: name, results: make(chan *chunk, cap(inFlight))}
+		writers.Go(func() {
+			writeErrs[i] = p.write(f, inFlight)
+		})
+
+		seq := 0
+		readErr := p.reader.ReadChunks(p.in, name, func(data []byte) error {
+			inFlight <- struct{}{}
+			f.pending.Add(1)
+			// The reader reuses its buffer, so the chunk gets a copy.
+			jobs <- &chunk{file: f, seq: seq, data: bytes.Clone(data)}
+			seq++
+			return nil
+		})
+		if readErr != nil {
+			readErrs[i] = fmt.Errorf("failed to read %s: %w", name, readErr)
+		}
+		// The writer stops once the last chunk of the file is redacted.
+		writers.Go(func() {
+			f.pending.Wait()
+			close(f.results)
+		})
+	}
+	close(jobs)
+	workers.Wait()
+	writers.Wait()
+	return errors.Join(append(readErrs, writeErrs...)...)
+}
+
+// write writes the chunks of f in sequence. It keeps receiving after an
+// error, so that the reader is never blocked on inFlight.
+func (p *pipeline) write(f *output, inFlight <-chan struct{}) (err error) {
+	file, err := p.out.Create(f.name)
+	if err != nil {
+		for range f.results {
+			<-inFlight
+		}
+		return fmt.Errorf("failed to create %s: %w", f.name, err)
+	}
+	var w io.Writer = file
+	var zw *gzip.Writer
+	if filepath.Ext(f.name) == ".gz" {
+		zw = gzip.NewWriter(file)
+		w = zw
+	}
+
+	pending := make(map[int]*chunk)
+	next := 0
+	for c := range f.results {
+		pending[c.seq] = c
+		for c := pending[next]; c != nil; c = pending[next] {
+			if err == nil {
+				if _, werr := w.Write(c.data); werr != nil {
+					err = fmt.Errorf("failed to write %s: %w", f.name, werr)
+				}
+			}
+			delete(pending, next)
+			next++
+			<-inFlight
+		}
+	}
+
+	if zw != nil {
+		if cerr := zw.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("failed to write %s: %w", f.name, cerr)
+		}
+	}
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = fmt.Errorf("failed to write %s: %w", f.name, cerr)
+	}
+	return err
+}
+
+// redactChunk redacts every line of a chunk. Lines that are not a log
+// entry are dropped rather than copied, as they may hold anything.
+func (p *pipeline) redactChunk(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	var lines, dropped int64
+	engine.Lines(data, func(line []byte) {
+		var entry logparser.LogEntry
+		if err := json.Unmarshal(line, &entry); err != nil || entry.Timestamp == "" {
+			dropped++
+			return
+		}
+		p.redactor.Redact(&entry)
+		encoder.Encode(&entry) // encoding a LogEntry cannot fail
+		lines++
+	})
+	p.lines.Add(lines)
+	p.dropped.Add(dropped)
+	return buf.Bytes()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// Field modes accepted by a Policy.
+const (
+	ModeKeep   = "keep"   // copy the value as is
+	ModeHMAC   = "hmac"   // replace the value with a keyed hash
+	ModePrefix = "prefix" // keep the network prefix of an IP only
+	ModeDrop   = "drop"   // empty the field
+)
+
+// AllPaths in Policy.Paths redacts the IDs of every path.
+const AllPaths = "*"
+
+// Policy says how every personal field of a log entry is redacted. Fields
+// that are not part of logparser.LogEntry are always dropped, so that
+// nothing unexpected leaves with the logs.
+type Policy struct {
+	// UserID is keep, hmac or drop. The pseudonym of a user is the same in
+	// every file and every run with the same key, so users can still be
+	// followed across sessions without being identified.
+	UserID string `json:"user_id"`
+	// IP is keep, prefix, hmac or drop. With prefix, the address is
+	// truncated to its IPBits (IPv4) or IPBits6 (IPv6) prefix and stays
+	// a valid address, e.g. 192.168.10.0.
+	IP      string `json:"ip"`
+	IPBits  int    `json:"ip_bits,omitempty"`
+	IPBits6 int    `json:"ip_bits6,omitempty"`
+	// Paths lists the path templates, as returned by
+	// logparser.PathTemplate, whose numeric segments are replaced with
+	// {id}, or "*" for every path.
+	Paths []string `json:"paths"`
+}
+
+// DefaultPolicy pseudonymizes users, truncates IPs to their /24 or /48
+// and replaces the IDs of every path.
+func DefaultPolicy() *Policy {
+	return &Policy{UserID: ModeHMAC, IP: ModePrefix, IPBits: 24, IPBits6: 48, Paths: []string{AllPaths}}
+}
+
+// LoadPolicy reads a policy file. Fields that are not set keep the values
+// of DefaultPolicy.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy: %w", err)
+	}
+	policy := DefaultPolicy()
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Validate reports every problem found in the policy.
+func (p *Policy) Validate() error {
+	var errs []error
+	if !slices.Contains([]string{ModeKeep, ModeHMAC, ModeDrop}, p.UserID) {
+		errs = append(errs, fmt.Errorf("user_id: unknown mode %q (want keep, hmac or drop)", p.UserID))
+	}
+	if !slices.Contains([]string{ModeKeep, ModePrefix, ModeHMAC, ModeDrop}, p.IP) {
+		errs = append(errs, fmt.Errorf("ip: unknown mode %q (want keep, prefix, hmac or drop)", p.IP))
+	}
+	if p.IPBits < 0 || p.IPBits > 32 {
+		errs = append(errs, fmt.Errorf("ip_bits must be in [0, 32], got %d", p.IPBits))
+	}
+	if p.IPBits6 < 0 || p.IPBits6 > 128 {
+		errs = append(errs, fmt.Errorf("ip_bits6 must be in [0, 128], got %d", p.IPBits6))
+	}
+	for i, path := range p.Paths {
+		if path != AllPaths && !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("paths[%d]: %q must start with / or be %q", i, path, AllPaths))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NeedsKey reports whether the policy hashes a field.
+func (p *Policy) NeedsKey() bool {
+	return p.UserID == ModeHMAC || p.IP == ModeHMAC
+}
+
+// Redactor applies a Policy. It is safe for concurrent use.
+type Redactor struct {
+	policy   *Policy
+	key      []byte
+	allPaths bool
+	paths    map[string]bool
+}
+
+// NewRedactor creates a Redactor. key is only used by the hmac mode.
+func NewRedactor(policy *Policy, key []byte) *Redactor {
+	r := &Redactor{policy: policy, key: key, paths: make(map[string]bool)}
+	for _, path := range policy.Paths {
+		if path == AllPaths {
+			r.allPaths = true
+		}
+		r.paths[path] = true
+	}
+	return r
+}
+
+// Redact rewrites the personal fields of entry in place.
+func (r *Redactor) Redact(entry *logparser.LogEntry) {
+	switch r.policy.UserID {
+	case ModeHMAC:
+		if entry.UserID != "" {
+			entry.UserID = "user_" + r.pseudonym("user_id", entry.UserID)
+		}
+	case ModeDrop:
+		entry.UserID = ""
+	}
+
+	switch r.policy.IP {
+	case ModePrefix:
+		entry.IP = r.truncate(entry.IP)
+	case ModeHMAC:
+		if entry.IP != "" {
+			entry.IP = "ip_" + r.pseudonym("ip", entry.IP)
+		}
+	case ModeDrop:
+		entry.IP = ""
+	}
+
+	if template := logparser.PathTemplate(entry.Path); r.allPaths || r.paths[template] {
+		entry.Path = template
+	}
+}
+
+// pseudonym returns the first 8 bytes of the HMAC-SHA256 of value, in hex.
+// The field name is part of the message, so that a user and an IP with
+// the same value get different pseudonyms.
+func (r *Redactor) pseudonym(field, value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// truncate keeps the network prefix of an IP. Values that are not an IP
+// are dropped, as they may hold anything.
+func (r *Redactor) truncate(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := r.policy.IPBits6
+	if addr.Is4() {
+		bits = r.policy.IPBits
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
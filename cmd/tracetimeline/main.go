@@ -0,0 +1,76 @@
+// tracetimeline turns a runtime/trace file into a per-goroutine timeline of
+// busy and idle time, as text or HTML.
+//
+// Record a trace with the --trace flag of any analyzer, for example
+//
+//	go run ./solutions/phase3 --trace=phase3.trace
+//	go run ./cmd/tracetimeline phase3.trace
+//
+// Each row is a goroutine started by the program (by default any goroutine
+// whose entry or creating function is in package main or in one of the
+// workshop's packages, such as the engine's workers) and shows when it
+// was running, in a system call, runnable but waiting for a P, or blocked.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+func main() {
+	match := flag.String("match", `^main\.|/go-concurrency-workshop/pkg/`,
+		"Regexp selecting goroutines by entry or creating function")
+	width := flag.Int("width", 80, "Number of time slices in the timeline")
+	htmlPath := flag.String("html", "", "Also write an HTML timeline to this file")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: tracetimeline [flags] <trace file>")
+		fmt.Fprintln(os.Stderr, "")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *match, max(*width, 10), *htmlPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(path, match string, width int, htmlPath string) error {
+	re, err := regexp.Compile(match)
+	if err != nil {
+		return fmt.Errorf("invalid -match: %w", err)
+	}
+
+	tl, err := readTimeline(path, re)
+	if err != nil {
+		return err
+	}
+	if len(tl.goroutines) == 0 {
+		return fmt.Errorf("no goroutine matches %q among %d in the trace", match, tl.all)
+	}
+
+	writeText(os.Stdout, tl, width)
+
+	if htmlPath != "" {
+		file, err := os.Create(htmlPath)
+		if err != nil {
+			return err
+		}
+		if err := writeHTML(file, tl, width); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+		fmt.Printf("\nHTML timeline written to %s\n", htmlPath)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+var stateNames = [numStates]string{"running", "syscall", "runnable", "blocked"}
+
+// stateChars are the timeline characters of each state; a blank cell means
+// the goroutine did not exist.
+var stateChars = [numStates]byte{'#', '=', '-', '.'}
+
+var stateColors = [numStates]string{"#2e7d32", "#f9a825", "#ef6c00", "#cfd8dc"}
+
+// summary aggregates the matched goroutines.
+type summary struct {
+	total   [numStates]time.Duration
+	reasons []reasonTime
+	// parallelism is the average number of matched goroutines executing
+	// (running or in a system call) over the trace.
+	parallelism float64
+}
+
+type reasonTime struct {
+	reason string
+	time   time.Duration
+}
+
+func summarize(tl *timeline) summary {
+	var s summary
+	reasons := make(map[string]time.Duration)
+	for _, g := range tl.goroutines {
+		for st, t := range g.total {
+			s.total[st] += t
+		}
+		for reason, t := range g.reasons {
+			if reason == "" {
+				reason = "unknown"
+			}
+			reasons[reason] += t
+		}
+	}
+	for _, reason := range slices.Sorted(maps.Keys(reasons)) {
+		s.reasons = append(s.reasons, reasonTime{reason, reasons[reason]})
+	}
+	slices.SortStableFunc(s.reasons, func(a, b reasonTime) int { return cmp.Compare(b.time, a.time) })
+	if tl.duration > 0 {
+		s.parallelism = float64(s.total[running]+s.total[syscall]) / float64(tl.duration)
+	}
+	return s
+}
+
+// shares returns the fraction of d spent in each state.
+func shares(total [numStates]time.Duration) [numStates]float64 {
+	var sum time.Duration
+	for _, t := range total {
+		sum += t
+	}
+	var out [numStates]float64
+	if sum > 0 {
+		for s, t := range total {
+			out[s] = float64(t) / float64(sum)
+		}
+	}
+	return out
+}
+
+func writeText(w io.Writer, tl *timeline, width int) {
+	labelWidth := 0
+	for _, g := range tl.goroutines {
+		labelWidth = max(labelWidth, len(g.label()))
+	}
+	labelWidth = min(labelWidth, 60)
+
+	fmt.Fprintf(w, "Trace: %.3fs, %d of %d goroutines selected, each timeline cell is %s\n\n",
+		tl.duration.Seconds(), len(tl.goroutines), tl.all, (tl.duration / time.Duration(width)).Round(time.Microsecond))
+	fmt.Fprintf(w, "%6s %-*s %8s %8s %8s %8s  %s\n",
+		"goid", labelWidth, "goroutine", "running", "syscall", "runnable", "blocked", "timeline")
+
+	for _, g := range tl.goroutines {
+		var line strings.Builder
+		for _, cell := range tl.cells(g, width) {
+			if s := dominant(cell); s == notAlive {
+				line.WriteByte(' ')
+			} else {
+				line.WriteByte(stateChars[s])
+			}
+		}
+		label := g.label()
+		if len(label) > labelWidth {
+			label = "..." + label[len(label)-labelWidth+3:]
+		}
+		sh := shares(g.total)
+		fmt.Fprintf(w, "%6d %-*s %7.1f%% %7.1f%% %7.1f%% %7.1f%%  |%s|\n",
+			g.id, labelWidth, label, sh[running]*100, sh[syscall]*100, sh[runnable]*100, sh[blocked]*100, line.String())
+	}
+
+	fmt.Fprintf(w, "\nLegend: %c running  %c syscall  %c runnable (waiting for a P)  %c blocked  (blank) not alive\n",
+		stateChars[running], stateChars[syscall], stateChars[runnable], stateChars[blocked])
+
+	s := summarize(tl)
+	sh := shares(s.total)
+	fmt.Fprintf(w, "\n=== Summary ===\n")
+	fmt.Fprintf(w, "Busy (running + syscall): %.1f%%\n", (sh[running]+sh[syscall])*100)
+	fmt.Fprintf(w, "Runnable, waiting for a P: %.1f%%\n", sh[runnable]*100)
+	fmt.Fprintf(w, "Blocked: %.1f%%\n", sh[blocked]*100)
+	fmt.Fprintf(w, "Average executing goroutines: %.2f\n", s.parallelism)
+	if len(s.reasons) > 0 {
+		fmt.Fprintf(w, "\nBlocked by:\n")
+		for _, r := range s.reasons[:min(len(s.reasons), 8)] {
+			fmt.Fprintf(w, "  %-32s %10s\n", r.reason, r.time.Round(time.Microsecond))
+		}
+	}
+}
+
+// htmlRow is a goroutine in the HTML template.
+type htmlRow struct {
+	ID     int64
+	Label  string
+	Shares [numStates]float64
+	Cells  []htmlCell
+}
+
+type htmlCell struct {
+	Color string
+	Title string
+}
+
+var htmlTemplate = template.Must(template.New("timeline").Funcs(template.FuncMap{
+	"pct": func(f float64) string { return fmt.Sprintf("%.1f%%", f*100) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Goroutine timeline</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 6px; text-align: right; white-space: nowrap; }
+td.label { text-align: left; font-family: monospace; }
+.bar { display: flex; }
+.bar span { display: inline-block; width: {{.CellWidth}}px; height: 14px; }
+.legend span { display: inline-block; width: 12px; height: 12px; margin: 0 4px 0 12px; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>Goroutine timeline</h1>
+<p>Trace: {{.Duration}}, {{len .Rows}} of {{.All}} goroutines selected, each cell is {{.Cell}}.</p>
+<p class="legend">{{range .Legend}}<span style="background: {{.Color}}"></span>{{.Title}}{{end}}</p>
+<table>
+<tr><th>goid</th><th>goroutine</th><th>running</th><th>syscall</th><th>runnable</th><th>blocked</th><th>timeline</th></tr>
+{{range .Rows}}<tr>
+<td>{{.ID}}</td><td class="label">{{.Label}}</td>
+{{range .Shares}}<td>{{pct .}}</td>{{end}}
+<td><div class="bar">{{range .Cells}}<span style="background: {{.Color}}" title="{{.Title}}"></span>{{end}}</div></td>
+</tr>
+{{end}}</table>
+<h2>Summary</h2>
+<table>
+<tr><td class="label">Busy (running + syscall)</td><td>{{pct .Busy}}</td></tr>
+<tr><td class="label">Runnable, waiting for a P</td><td>{{pct .Runnable}}</td></tr>
+<tr><td class="label">Blocked</td><td>{{pct .Blocked}}</td></tr>
+<tr><td class="label">Average executing goroutines</td><td>{{printf "%.2f" .Parallelism}}</td></tr>
+</table>
+{{if .Reasons}}<h2>Blocked by</h2>
+<table>
+{{range .Reasons}}<tr><td class="label">{{.Reason}}</td><td>{{.Time}}</td></tr>
+{{end}}</table>{{end}}
+</body>
+</html>
+`))
+
+func writeHTML(w io.Writer, tl *timeline, width int) error {
+	cellDuration := tl.duration / time.Duration(width)
+
+	type reason struct {
+		Reason string
+		Time   time.Duration
+	}
+	data := struct {
+		Duration, Cell       time.Duration
+		All, CellWidth       int
+		Legend               []htmlCell
+		Rows                 []htmlRow
+		Busy, Runnable       float64
+		Blocked, Parallelism float64
+		Reasons              []reason
+	}{
+		Duration:  tl.duration.Round(time.Microsecond),
+		Cell:      cellDuration.Round(time.Microsecond),
+		All:       tl.all,
+		CellWidth: max(1000/width, 2),
+	}
+
+	for s := range numStates {
+		data.Legend = append(data.Legend, htmlCell{Color: stateColors[s], Title: stateNames[s]})
+	}
+
+	for _, g := range tl.goroutines {
+		row := htmlRow{ID: int64(g.id), Label: g.label(), Shares: shares(g.total)}
+		for i, cell := range tl.cells(g, width) {
+			c := htmlCell{Color: "transparent"}
+			if s := dominant(cell); s != notAlive {
+				c.Color = stateColors[s]
+				c.Title = fmt.Sprintf("%s-%s: %s", (time.Duration(i) * cellDuration).Round(time.Microsecond),
+					(time.Duration(i+1) * cellDuration).Round(time.Microsecond), cellTitle(cell))
+			}
+			row.Cells = append(row.Cells, c)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+
+	s := summarize(tl)
+	sh := shares(s.total)
+	data.Busy = sh[running] + sh[syscall]
+	data.Runnable = sh[runnable]
+	data.Blocked = sh[blocked]
+	data.Parallelism = s.parallelism
+	for _, r := range s.reasons {
+		data.Reasons = append(data.Reasons, reason{r.reason, r.time.Round(time.Microsecond)})
+	}
+
+	return htmlTemplate.Execute(w, data)
+}
+
+// cellTitle describes the time spent in each state within a cell.
+func cellTitle(cell [numStates]time.Duration) string {
+	var parts []string
+	for s, t := range cell {
+		if t > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", stateNames[s], t.Round(time.Microsecond)))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
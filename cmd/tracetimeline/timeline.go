@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/trace"
+)
+
+// state is what a goroutine is doing, as far as the timeline is concerned.
+type state int
+
+const (
+	running  state = iota // executing Go code
+	syscall               // executing, but inside a system call
+	runnable              // ready, waiting for a P
+	blocked               // waiting on a channel, lock, I/O, ...
+	numStates
+	notAlive state = -1
+)
+
+// interval is a period a goroutine spent in one state.
+type interval struct {
+	from, to time.Duration // since the start of the trace
+	state    state
+}
+
+// goroutine is the timeline of one goroutine.
+type goroutine struct {
+	id      trace.GoID
+	entry   string // function the goroutine started in
+	creator string // first function outside the runtime that created it
+
+	state  state
+	since  time.Duration
+	reason string // why it is blocked, while blocked
+
+	intervals []interval
+	total     [numStates]time.Duration
+	reasons   map[string]time.Duration
+}
+
+func (g *goroutine) label() string {
+	entry, creator := shortName(g.entry), shortName(g.creator)
+	if creator == "" || creator == entry {
+		return entry
+	}
+	return entry + " (from " + creator + ")"
+}
+
+// shortName drops the import path of a function name, keeping the package:
+// github.com/x/y/pkg/sched.FIFO[...] becomes sched.FIFO[...].
+func shortName(fn string) string {
+	// The brackets of generic functions may contain slashes too.
+	name := fn
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return fn[i+1:]
+	}
+	return fn
+}
+
+func (g *goroutine) lifetime() time.Duration {
+	var d time.Duration
+	for _, t := range g.total {
+		d += t
+	}
+	return d
+}
+
+// switchTo ends the current interval at t and starts one in s.
+func (g *goroutine) switchTo(s state, reason string, t time.Duration) {
+	if g.state != notAlive && t > g.since {
+		g.intervals = append(g.intervals, interval{from: g.since, to: t, state: g.state})
+		g.total[g.state] += t - g.since
+		if g.state == blocked {
+			g.reasons[g.reason] += t - g.since
+		}
+	}
+	g.state, g.since, g.reason = s, t, reason
+}
+
+// timeline is the parsed trace.
+type timeline struct {
+	duration   time.Duration
+	goroutines []*goroutine // matched goroutines, by id
+	all        int          // goroutines seen in the trace
+}
+
+// readTimeline reads a runtime/trace file and keeps the goroutines whose
+// entry or creator function matches match.
+func readTimeline(path string, match *regexp.Regexp) (*timeline, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := trace.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace: %w", err)
+	}
+
+	byID := make(map[trace.GoID]*goroutine)
+	var begin, now trace.Time
+	first := true
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid trace: %w", err)
+		}
+		if first {
+			begin, first = ev.Time(), false
+		}
+		now = ev.Time()
+		if ev.Kind() != trace.EventStateTransition {
+			continue
+		}
+		st := ev.StateTransition()
+		if st.Resource.Kind != trace.ResourceGoroutine {
+			continue
+		}
+
+		id := st.Resource.Goroutine()
+		from, to := st.Goroutine()
+		g := byID[id]
+		if g == nil {
+			g = &goroutine{id: id, state: notAlive, reasons: make(map[string]time.Duration)}
+			byID[id] = g
+		}
+		if g.entry == "" {
+			// The outermost frame of a goroutine's stack is its entry
+			// function; on creation the stack holds only that frame.
+			g.entry = outermost(st.Stack)
+		}
+		if from == trace.GoNotExist {
+			g.creator = creatorOf(ev.Stack())
+		}
+		g.switchTo(classify(to), st.Reason, now.Sub(begin))
+	}
+
+	tl := &timeline{duration: now.Sub(begin), all: len(byID)}
+	for _, g := range byID {
+		g.switchTo(notAlive, "", tl.duration)
+		// Runtime goroutines such as GC workers are attributed to whatever
+		// happened to start them; they are never workers of the program.
+		if strings.HasPrefix(g.entry, "runtime.") {
+			continue
+		}
+		if match.MatchString(g.entry) || match.MatchString(g.creator) {
+			tl.goroutines = append(tl.goroutines, g)
+		}
+	}
+	slices.SortFunc(tl.goroutines, func(a, b *goroutine) int { return cmp.Compare(a.id, b.id) })
+	return tl, nil
+}
+
+func classify(s trace.GoState) state {
+	switch s {
+	case trace.GoRunning:
+		return running
+	case trace.GoSyscall:
+		return syscall
+	case trace.GoRunnable:
+		return runnable
+	case trace.GoWaiting:
+		return blocked
+	default:
+		return notAlive
+	}
+}
+
+func outermost(stack trace.Stack) string {
+	name := ""
+	for frame := range stack.Frames() {
+		name = frame.Func
+	}
+	return name
+}
+
+// creatorOf returns the innermost frame of the creating stack that is not
+// in the runtime or sync package, so that goroutines started through
+// helpers like sync.WaitGroup.Go are attributed to the caller.
+func creatorOf(stack trace.Stack) string {
+	for frame := range stack.Frames() {
+		if !strings.HasPrefix(frame.Func, "runtime.") && !strings.HasPrefix(frame.Func, "sync.") {
+			return frame.Func
+		}
+	}
+	return ""
+}
+
+// cells returns, for each of n equal slices of the trace, the time g spent
+// in each state.
+func (tl *timeline) cells(g *goroutine, n int) [][numStates]time.Duration {
+	cells := make([][numStates]time.Duration, n)
+	if tl.duration <= 0 {
+		return cells
+	}
+	width := float64(tl.duration) / float64(n)
+	for _, iv := range g.intervals {
+		first := min(int(float64(iv.from)/width), n-1)
+		last := min(int(float64(iv.to)/width), n-1)
+		for c := first; c <= last; c++ {
+			start := max(iv.from, time.Duration(float64(c)*width))
+			end := min(iv.to, time.Duration(float64(c+1)*width))
+			if end > start {
+				cells[c][iv.state] += end - start
+			}
+		}
+	}
+	return cells
+}
+
+// dominant returns the state a cell spent most time in, or notAlive.
+func dominant(cell [numStates]time.Duration) state {
+	best, bestTime := notAlive, time.Duration(0)
+	for s, t := range cell {
+		if t > bestTime {
+			best, bestTime = state(s), t
+		}
+	}
+	return best
+}
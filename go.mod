@@ -2,7 +2,10 @@ module github.com/nnnkkk7/go-concurrency-workshop
 
 go 1.25.0
 
-require github.com/bytedance/sonic v1.14.2
+require (
+	github.com/bytedance/sonic v1.14.2
+	golang.org/x/exp v0.0.0-20260611194520-c48552f49976
+)
 
 require (
 	github.com/bytedance/gopkg v0.1.3 // indirect
@@ -0,0 +1,263 @@
+// Package abuse finds clients, by IP and by user, that exceed a request
+// rate over a sliding window or that produce bursts of authentication
+// failures, as in credential stuffing.
+//
+// The rules run on the time-ordered stream of each key. Log files are not
+// ordered by client, and not even by time, so the requests of a key must be
+// brought together first: a Detector holds the events of the keys it owns
+// and sorts every stream before scanning it. Detectors are meant to be fed
+// by a stage that partitions events by key, such as pkg/shuffle.
+package abuse
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// KeyKind is the kind of client a key identifies.
+type KeyKind uint8
+
+// Kinds of keys.
+const (
+	IP KeyKind = iota
+	User
+)
+
+func (k KeyKind) String() string {
+	if k == User {
+		return "user"
+	}
+	return "ip"
+}
+
+// Key identifies a client.
+type Key struct {
+	Kind KeyKind
+	ID   string
+}
+
+func (k Key) String() string {
+	return k.Kind.String() + " " + k.ID
+}
+
+// Keys returns the keys of the clients of an entry: its IP and its user,
+// if they are set.
+func Keys(entry *logparser.LogEntry) []Key {
+	keys := make([]Key, 0, 2)
+	if entry.IP != "" {
+		keys = append(keys, Key{Kind: IP, ID: entry.IP})
+	}
+	if entry.UserID != "" {
+		keys = append(keys, Key{Kind: User, ID: entry.UserID})
+	}
+	return keys
+}
+
+// Config sets the limits of the rules.
+type Config struct {
+	// Rate is the number of requests per second a key may sustain over
+	// any window of RateWindow. Zero disables the rule.
+	Rate float64
+	// RateWindow is the length of the sliding window of the rate rule.
+	// Zero means 10s.
+	RateWindow time.Duration
+	// AuthFailures is the number of 401 and 403 responses to AuthPath a
+	// key may produce within any window of AuthWindow. Zero disables the
+	// rule.
+	AuthFailures int
+	// AuthWindow is the length of the sliding window of the
+	// authentication rule. Zero means 1m.
+	AuthWindow time.Duration
+	// AuthPath is the path template of the login endpoint. Empty means
+	// /api/auth/login.
+	AuthPath string
+}
+
+func (c Config) withDefaults() Config {
+	if c.RateWindow <= 0 {
+		c.RateWindow = 10 * time.Second
+	}
+	if c.AuthWindow <= 0 {
+		c.AuthWindow = time.Minute
+	}
+	if c.AuthPath == "" {
+		c.AuthPath = "/api/auth/login"
+	}
+	return c
+}
+
+// Rule names.
+const (
+	RateRule = "rate"
+	AuthRule = "auth-failures"
+)
+
+// rule flags a key whose matching events exceed limit within any window.
+type rule struct {
+	name   string
+	limit  int
+	window int64 // milliseconds
+	match  func(Event) bool
+}
+
+func (c Config) rules() []rule {
+	var rules []rule
+	if c.Rate > 0 {
+		rules = append(rules, rule{
+			name:   RateRule,
+			limit:  int(math.Floor(c.Rate * c.RateWindow.Seconds())),
+			window: c.RateWindow.Milliseconds(),
+			match:  func(Event) bool { return true },
+		})
+	}
+	if c.AuthFailures > 0 {
+		rules = append(rules, rule{
+			name:   AuthRule,
+			limit:  c.AuthFailures,
+			window: c.AuthWindow.Milliseconds(),
+			match:  func(e Event) bool { return e.AuthFailure },
+		})
+	}
+	return rules
+}
+
+// Event is the part of a log entry that the rules need.
+type Event struct {
+	At          int64 // Unix milliseconds
+	AuthFailure bool  // a 401 or 403 response to the login endpoint
+}
+
+// Event extracts the Event of a log entry.
+func (c Config) Event(entry *logparser.LogEntry) (Event, error) {
+	t, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		return Event{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	c = c.withDefaults()
+	return Event{
+		At: t.UnixMilli(),
+		AuthFailure: (entry.Status == 401 || entry.Status == 403) &&
+			logparser.PathTemplate(entry.Path) == c.AuthPath,
+	}, nil
+}
+
+// Violation is a period during which a key exceeded the limit of a rule:
+// the union of the overlapping windows that held too many events.
+type Violation struct {
+	Start, End time.Time // first and last event of the period
+	Events     int       // matching events in the period
+	Peak       int       // most matching events within one window
+}
+
+// Offender is a key that violated a rule.
+type Offender struct {
+	Key        Key
+	Rule       string
+	Limit      int
+	Window     time.Duration
+	Events     int // matching events of the key over the whole logs
+	Peak       int // highest Violation.Peak
+	Violations []Violation
+}
+
+// Detector collects the events of the keys it owns and applies the rules
+// to the stream of every key. It keeps every event in memory.
+type Detector struct {
+	rules  []rule
+	events map[Key][]Event
+}
+
+// NewDetector creates a Detector with the given limits.
+func NewDetector(config Config) *Detector {
+	return &Detector{rules: config.withDefaults().rules(), events: make(map[Key][]Event)}
+}
+
+// Add records an event of a key, in any order.
+func (d *Detector) Add(key Key, event Event) {
+	d.events[key] = append(d.events[key], event)
+}
+
+// Result applies the rules to every key and returns the offenders, by
+// decreasing peak.
+func (d *Detector) Result() []Offender {
+	var offenders []Offender
+	for key, events := range d.events {
+		slices.SortFunc(events, func(a, b Event) int { return cmp.Compare(a.At, b.At) })
+		for _, r := range d.rules {
+			if o, ok := r.scan(key, events); ok {
+				offenders = append(offenders, o)
+			}
+		}
+	}
+	SortOffenders(offenders)
+	return offenders
+}
+
+// SortOffenders sorts offenders by decreasing peak relative to the limit of
+// their rule, then by key, so that the results of several Detectors can be
+// combined.
+func SortOffenders(offenders []Offender) {
+	slices.SortFunc(offenders, func(a, b Offender) int {
+		return cmp.Or(
+			cmp.Compare(float64(b.Peak)/float64(max(b.Limit, 1)), float64(a.Peak)/float64(max(a.Limit, 1))),
+			cmp.Compare(a.Rule, b.Rule),
+			cmp.Compare(a.Key.Kind, b.Key.Kind),
+			cmp.Compare(a.Key.ID, b.Key.ID))
+	})
+}
+
+// scan slides the window of r over the time-ordered events of a key. The
+// window ending with event i holds the events of the last r.window
+// milliseconds up to it.
+func (r rule) scan(key Key, events []Event) (Offender, bool) {
+	var times []int64
+	for _, e := range events {
+		if r.match(e) {
+			times = append(times, e.At)
+		}
+	}
+	o := Offender{Key: key, Rule: r.name, Limit: r.limit, Window: time.Duration(r.window) * time.Millisecond, Events: len(times)}
+	if len(times) <= r.limit {
+		return o, false
+	}
+
+	// first is the index of the first event of the current violation, or
+	// -1 outside of one.
+	first, last := -1, -1
+	closeViolation := func() {
+		o.Violations[len(o.Violations)-1].End = time.UnixMilli(times[last]).UTC()
+		o.Violations[len(o.Violations)-1].Events = last - first + 1
+		first = -1
+	}
+	j := 0
+	for i, t := range times {
+		for j < i && t-times[j] >= r.window {
+			j++
+		}
+		count := i - j + 1
+		if count <= r.limit {
+			continue
+		}
+		if first >= 0 && j > last {
+			// The window does not overlap the current violation.
+			closeViolation()
+		}
+		if first < 0 {
+			first = j
+			o.Violations = append(o.Violations, Violation{Start: time.UnixMilli(times[j]).UTC()})
+		}
+		last = i
+		v := &o.Violations[len(o.Violations)-1]
+		v.Peak = max(v.Peak, count)
+		o.Peak = max(o.Peak, count)
+	}
+	if first >= 0 {
+		closeViolation()
+	}
+	return o, len(o.Violations) > 0
+}
@@ -0,0 +1,363 @@
+// Package anomaly finds unusual minutes in the per-minute series of
+// requests, 5xx ratio and p99 latency built by logparser.Series.
+//
+// Every minute is compared with a baseline of the minutes before it: the
+// median and the median absolute deviation (MAD) of a trailing window. Both
+// ignore the outliers inside the window, so an incident does not raise its
+// own baseline as it would with a mean and standard deviation, as long as
+// it lasts less than half of the window. The robust z-score of a minute is
+// its distance to the median in units of 1.4826*MAD, which is the standard
+// deviation for normally distributed data.
+package anomaly
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// Metric is a per-minute series the detector scores.
+type Metric int
+
+// Scored metrics.
+const (
+	Requests Metric = iota
+	ErrorRatio
+	P99Latency
+	numMetrics
+)
+
+func (m Metric) String() string {
+	switch m {
+	case Requests:
+		return "requests"
+	case ErrorRatio:
+		return "5xx ratio"
+	case P99Latency:
+		return "p99 latency"
+	}
+	return fmt.Sprintf("Metric(%d)", int(m))
+}
+
+// Format formats a value of the metric.
+func (m Metric) Format(v float64) string {
+	switch m {
+	case ErrorRatio:
+		return fmt.Sprintf("%.1f%%", v*100)
+	case P99Latency:
+		return fmt.Sprintf("%.0fms", v)
+	}
+	return fmt.Sprintf("%.0f/min", v)
+}
+
+// Severity grades a flagged window.
+type Severity int
+
+// Severities, from the least severe.
+const (
+	Warning Severity = iota
+	Critical
+)
+
+func (s Severity) String() string {
+	if s == Critical {
+		return "CRITICAL"
+	}
+	return "WARNING"
+}
+
+// Config tunes the detector.
+type Config struct {
+	// Window is the number of minutes of the trailing baseline. Zero means
+	// 60.
+	Window int
+	// Threshold is the robust z-score above which a minute is flagged.
+	// Twice the threshold is critical. Zero means 5.
+	Threshold float64
+	// MinRequests is the number of requests below which the 5xx ratio and
+	// the p99 latency of a minute are too noisy to be scored. Zero means 20.
+	// The p99 latency needs at least 100 requests in any case.
+	MinRequests int
+	// TopPaths is the number of contributing paths listed per window. Zero
+	// means 3.
+	TopPaths int
+	// Gap is the number of unflagged minutes that may separate two flagged
+	// minutes of the same window, as an incident can dip below the
+	// threshold. Negative means 0; zero means 2.
+	Gap int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Window <= 0 {
+		c.Window = 60
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 5
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 20
+	}
+	if c.TopPaths <= 0 {
+		c.TopPaths = 3
+	}
+	if c.Gap == 0 {
+		c.Gap = 2
+	}
+	c.Gap = max(c.Gap, 0)
+	return c
+}
+
+// Signal is the most anomalous minute of one metric within a window.
+type Signal struct {
+	Metric   Metric
+	At       time.Time
+	Value    float64
+	Baseline float64 // median of the trailing window
+	Score    float64 // robust z-score, negative for a drop
+}
+
+// Contribution is how much a path deviated from its baseline in a window,
+// in the unit of the window's main signal: requests, 5xx responses or
+// milliseconds of response time.
+type Contribution struct {
+	Path   string
+	Excess float64
+	Share  float64 // fraction of the deviation of all paths
+}
+
+// Window is a run of flagged minutes, at most Config.Gap minutes apart.
+type Window struct {
+	Start, End time.Time // End is exclusive
+	Severity   Severity
+	Signals    []Signal // by decreasing absolute score; Signals[0] is the main one
+	TopPaths   []Contribution
+}
+
+// Detect scores every minute between the first and the last minute of
+// series, counting missing minutes as minutes without requests, and
+// returns the flagged windows in time order.
+func Detect(series *logparser.Series, cfg Config) []Window {
+	cfg = cfg.withDefaults()
+	if len(series.Minutes) == 0 {
+		return nil
+	}
+
+	// at holds the minutes that are scored, in time order. Once a gap
+	// without requests lasts a whole window, the baseline is all zeros and
+	// the next empty minutes score the same, so longer gaps are cut to a
+	// window. Memory then grows with the minutes that have requests rather
+	// than with the span, which a single bogus timestamp could make huge.
+	var at []int64
+	for _, t := range slices.Sorted(maps.Keys(series.Minutes)) {
+		if len(at) > 0 {
+			prev := at[len(at)-1]
+			for gap := range min(t-prev-1, int64(cfg.Window)) {
+				at = append(at, prev+1+gap)
+			}
+		}
+		at = append(at, t)
+	}
+
+	n := len(at)
+	minutes := make([]*logparser.Minute, n)
+	var values [numMetrics][]float64
+	for m := range numMetrics {
+		values[m] = make([]float64, n)
+	}
+	for i := range n {
+		minute := series.Minutes[at[i]]
+		if minute == nil {
+			minute = &logparser.Minute{}
+		}
+		minutes[i] = minute
+		values[Requests][i] = float64(minute.Requests)
+		values[ErrorRatio][i] = math.NaN()
+		values[P99Latency][i] = math.NaN()
+		if minute.Requests >= cfg.MinRequests {
+			values[ErrorRatio][i] = float64(minute.Errors) / float64(minute.Requests)
+		}
+		// With fewer than 100 requests the p99 is the slowest request.
+		if minute.Requests >= max(cfg.MinRequests, 100) {
+			values[P99Latency][i] = minute.Latency.Quantile(0.99)
+		}
+	}
+
+	// flagged[i] holds the signals of minute i.
+	flagged := make([][]Signal, n)
+	for m := range numMetrics {
+		for i := range n {
+			x := values[m][i]
+			if math.IsNaN(x) {
+				continue
+			}
+			median, scale, ok := baseline(values[m][max(i-cfg.Window, 0):i], cfg.Window)
+			if !ok {
+				continue
+			}
+			scale = max(scale, noiseFloor(m, median, minutes[i].Requests))
+			score := (x - median) / scale
+			// Fewer errors or faster responses are never a problem.
+			if m != Requests && score < 0 {
+				continue
+			}
+			if math.Abs(score) >= cfg.Threshold {
+				flagged[i] = append(flagged[i], Signal{
+					Metric: Metric(m), At: unixMinute(at[i]),
+					Value: x, Baseline: median, Score: score,
+				})
+			}
+		}
+	}
+
+	var windows []Window
+	for i := 0; i < n; {
+		if flagged[i] == nil {
+			i++
+			continue
+		}
+		// j is one past the last flagged minute of the window.
+		j := i + 1
+		for k := j; k < n && at[k]-at[j-1] <= int64(cfg.Gap)+1; k++ {
+			if flagged[k] != nil {
+				j = k + 1
+			}
+		}
+		windows = append(windows, newWindow(at, i, j, flagged[i:j], minutes, cfg))
+		i = j
+	}
+	return windows
+}
+
+// baseline returns the median and 1.4826*MAD of the values of a trailing
+// window, ignoring minutes that were not scored. It needs at least half a
+// window of history.
+func baseline(window []float64, size int) (median, scale float64, ok bool) {
+	xs := make([]float64, 0, len(window))
+	for _, x := range window {
+		if !math.IsNaN(x) {
+			xs = append(xs, x)
+		}
+	}
+	if len(xs) < max(size/2, 1) {
+		return 0, 0, false
+	}
+	median = medianOf(xs)
+	for i, x := range xs {
+		xs[i] = math.Abs(x - median)
+	}
+	return median, 1.4826 * medianOf(xs), true
+}
+
+func medianOf(xs []float64) float64 {
+	slices.Sort(xs)
+	mid := len(xs) / 2
+	if len(xs)%2 == 1 {
+		return xs[mid]
+	}
+	return (xs[mid-1] + xs[mid]) / 2
+}
+
+// noiseFloor is the smallest scale of a metric, so that a quiet baseline
+// with a MAD near zero does not turn sampling noise into anomalies: the
+// standard deviation of a Poisson count, of a binomial ratio over the
+// minute's requests, and the 5% resolution of a LatencyHistogram.
+func noiseFloor(m Metric, median float64, requests int) float64 {
+	switch m {
+	case Requests:
+		return math.Sqrt(max(median, 1))
+	case ErrorRatio:
+		p := max(median, 1/float64(requests))
+		return math.Sqrt(p * (1 - p) / float64(requests))
+	default:
+		return max(0.05*median, 1)
+	}
+}
+
+func newWindow(at []int64, from, to int, flagged [][]Signal, minutes []*logparser.Minute, cfg Config) Window {
+	w := Window{Start: unixMinute(at[from]), End: unixMinute(at[to-1] + 1)}
+
+	// Keep the most anomalous minute of each metric.
+	var peaks [numMetrics]*Signal
+	for _, signals := range flagged {
+		for k := range signals {
+			s := &signals[k]
+			if p := peaks[s.Metric]; p == nil || math.Abs(s.Score) > math.Abs(p.Score) {
+				peaks[s.Metric] = s
+			}
+		}
+	}
+	for _, p := range peaks {
+		if p != nil {
+			w.Signals = append(w.Signals, *p)
+		}
+	}
+	slices.SortFunc(w.Signals, func(a, b Signal) int { return cmp.Compare(math.Abs(b.Score), math.Abs(a.Score)) })
+	if math.Abs(w.Signals[0].Score) >= 2*cfg.Threshold {
+		w.Severity = Critical
+	}
+
+	w.TopPaths = contributions(w.Signals[0], minutes[max(from-cfg.Window, 0):from], minutes[from:to], cfg.TopPaths)
+	return w
+}
+
+// contributions compares every path in the window with its average minute
+// in the baseline, in the unit of the main signal, and returns the paths
+// that moved the most in the direction of the signal.
+func contributions(main Signal, base, window []*logparser.Minute, top int) []Contribution {
+	value := func(p *logparser.PathMinute) float64 {
+		switch main.Metric {
+		case ErrorRatio:
+			return float64(p.Errors)
+		case P99Latency:
+			return float64(p.LatencyMs)
+		}
+		return float64(p.Requests)
+	}
+
+	expected := make(map[string]float64)
+	for _, m := range base {
+		for path, p := range m.Paths {
+			expected[path] += value(p) / float64(len(base))
+		}
+	}
+	excess := make(map[string]float64)
+	for path, perMinute := range expected {
+		excess[path] = -perMinute * float64(len(window))
+	}
+	for _, m := range window {
+		for path, p := range m.Paths {
+			excess[path] += value(p)
+		}
+	}
+
+	// A drop in requests is explained by the paths that lost the most.
+	sign := 1.0
+	if main.Score < 0 {
+		sign = -1
+	}
+	var list []Contribution
+	total := 0.0
+	for path, e := range excess {
+		if e*sign > 0 {
+			list = append(list, Contribution{Path: path, Excess: e})
+			total += e * sign
+		}
+	}
+	slices.SortFunc(list, func(a, b Contribution) int {
+		return cmp.Or(cmp.Compare(b.Excess*sign, a.Excess*sign), cmp.Compare(a.Path, b.Path))
+	})
+	list = list[:min(len(list), top)]
+	for i := range list {
+		list[i].Share = list[i].Excess * sign / total
+	}
+	return list
+}
+
+func unixMinute(t int64) time.Time {
+	return time.Unix(t*60, 0).UTC()
+}
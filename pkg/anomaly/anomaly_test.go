@@ -0,0 +1,34 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// TestDetectFarOutlier checks that a timestamp centuries away from the
+// others costs a window of minutes rather than the whole span between them.
+func TestDetectFarOutlier(t *testing.T) {
+	series := logparser.NewSeries()
+	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	add := func(at time.Time) {
+		series.Add(&logparser.LogEntry{Timestamp: at.Format(time.RFC3339Nano), Path: "/api/products", Status: 200})
+	}
+	for m := range 180 {
+		for range 50 {
+			add(start.Add(time.Duration(m) * time.Minute))
+		}
+	}
+	add(time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	windows := Detect(series, Config{})
+	// The traffic stops after three hours: the first minutes of the gap are
+	// a drop, and the lone request centuries later is unremarkable.
+	if len(windows) != 1 {
+		t.Fatalf("got %d windows, want 1: %+v", len(windows), windows)
+	}
+	if w := windows[0]; !w.Start.Equal(start.Add(180*time.Minute)) || w.Signals[0].Metric != Requests || w.Signals[0].Score >= 0 {
+		t.Errorf("got window %s - %s with %+v, want a drop in requests at %s", w.Start, w.End, w.Signals, start.Add(180*time.Minute))
+	}
+}
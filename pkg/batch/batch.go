@@ -0,0 +1,138 @@
+// Package batch groups items sent over channels into pooled slices.
+//
+// Sending one item per channel operation is fine when items are files, but
+// when they become lines or chunks the channel synchronization dominates.
+// A Batcher collects items into slices taken from a Pool and sends a slice
+// when it is full or when its oldest item has waited FlushInterval.
+// Consumers hand the slices back to the Pool once they are done with them.
+package batch
+
+import (
+	"sync"
+	"time"
+)
+
+// Pool recycles slices of T with a fixed capacity.
+type Pool[T any] struct {
+	size int
+	pool sync.Pool
+}
+
+// NewPool creates a Pool of slices with capacity size.
+func NewPool[T any](size int) *Pool[T] {
+	size = max(size, 1)
+	p := &Pool[T]{size: size}
+	p.pool.New = func() any {
+		s := make([]T, 0, size)
+		return &s
+	}
+	return p
+}
+
+// Size returns the capacity of the slices in the pool.
+func (p *Pool[T]) Size() int {
+	return p.size
+}
+
+// Get returns an empty slice with capacity Size.
+func (p *Pool[T]) Get() []T {
+	return (*p.pool.Get().(*[]T))[:0]
+}
+
+// Put returns a slice obtained from Get to the pool. Slices that grew beyond
+// the pool size are dropped.
+func (p *Pool[T]) Put(s []T) {
+	if cap(s) != p.size {
+		return
+	}
+	clear(s[:cap(s)]) // drop references so pooled slices do not pin memory
+	s = s[:0]
+	p.pool.Put(&s)
+}
+
+// Config configures a Batcher.
+type Config struct {
+	// Size is the number of items per batch.
+	Size int
+	// FlushInterval bounds how long an item may wait in a partial batch.
+	// Zero disables time based flushing.
+	FlushInterval time.Duration
+}
+
+// Batcher collects items into batches and sends them on a channel.
+// It is safe for concurrent use, but one Batcher per producer avoids
+// contention on its lock.
+type Batcher[T any] struct {
+	mu       sync.Mutex
+	out      chan<- []T
+	pool     *Pool[T]
+	buf      []T
+	size     int
+	interval time.Duration
+	timer    *time.Timer
+}
+
+// New creates a Batcher that sends to out. Batches are taken from pool, so
+// cfg.Size is capped at pool.Size().
+func New[T any](out chan<- []T, pool *Pool[T], cfg Config) *Batcher[T] {
+	return &Batcher[T]{
+		out:      out,
+		pool:     pool,
+		interval: cfg.FlushInterval,
+		buf:      pool.Get(),
+		size:     min(max(cfg.Size, 1), pool.Size()),
+	}
+}
+
+// Add appends an item, sending the batch once it is full.
+func (b *Batcher[T]) Add(item T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.buf) == 0 && b.interval > 0 {
+		b.startTimer()
+	}
+	b.buf = append(b.buf, item)
+	if len(b.buf) == b.size {
+		b.flushLocked()
+	}
+}
+
+// Flush sends the current partial batch, if any.
+func (b *Batcher[T]) Flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+// Close flushes the pending items and stops the flush timer. It does not
+// close the output channel, which may be shared by several Batchers.
+func (b *Batcher[T]) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.pool.Put(b.buf)
+	b.buf = nil
+}
+
+func (b *Batcher[T]) startTimer() {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.Flush)
+		return
+	}
+	b.timer.Reset(b.interval)
+}
+
+func (b *Batcher[T]) flushLocked() {
+	if len(b.buf) == 0 {
+		return
+	}
+	b.out <- b.buf
+	b.buf = b.pool.Get()
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+}
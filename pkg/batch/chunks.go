@@ -0,0 +1,47 @@
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ReadChunks splits r into newline-aligned chunks and passes them to emit.
+// Chunks are taken from pool and hold whole lines only, up to pool.Size()
+// bytes; a single line longer than that gets a larger chunk of its own.
+// emit takes ownership of the chunk and should return it to pool when done.
+func ReadChunks(r io.Reader, pool *Pool[byte], emit func(chunk []byte)) error {
+	buf := pool.Get()
+	for {
+		n, err := r.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+
+		if errors.Is(err, io.EOF) {
+			if len(buf) > 0 {
+				emit(buf)
+			} else {
+				pool.Put(buf)
+			}
+			return nil
+		}
+		if err != nil {
+			pool.Put(buf)
+			return err
+		}
+		if len(buf) < cap(buf) {
+			continue
+		}
+
+		end := bytes.LastIndexByte(buf, '\n')
+		if end < 0 {
+			// The chunk holds part of a single long line: grow it.
+			buf = append(buf, 0)[:len(buf)]
+			continue
+		}
+
+		next := pool.Get()
+		next = append(next, buf[end+1:]...)
+		emit(buf[:end+1])
+		buf = next
+	}
+}
@@ -0,0 +1,307 @@
+// Package dashboard draws the activity of worker goroutines on a terminal
+// with plain ANSI escape codes: what every worker is processing, a
+// throughput sparkline per worker and how full the watched channels are.
+//
+// It does not depend on how the work is organized. Every goroutine that
+// processes files registers itself with Worker, so a sequential loop shows
+// one row, a goroutine per file shows one row per file and a worker pool
+// shows one row per worker. A nil *Dashboard and the nil *Worker it hands
+// out ignore every call, so instrumented code runs unchanged without it.
+package dashboard
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// historyLen is the number of samples shown by a sparkline.
+const historyLen = 24
+
+// DefaultMaxRows is the number of worker rows drawn when MaxRows is zero.
+const DefaultMaxRows = 16
+
+// Dashboard samples the registered workers and channels and redraws them.
+type Dashboard struct {
+	// MaxRows limits the number of worker rows; busy workers come first.
+	MaxRows int
+
+	out        io.Writer
+	title      string
+	totalFiles int
+	start      time.Time
+	stop       chan struct{}
+	wg         sync.WaitGroup
+
+	mu      sync.Mutex
+	workers []*Worker
+	gauges  []gauge
+	total   []float64 // throughput history of all workers
+	drawn   int       // lines drawn by the previous frame
+}
+
+// gauge is a watched channel.
+type gauge struct {
+	name     string
+	len, cap func() int
+}
+
+// New returns a dashboard drawing to out, which should be a terminal.
+func New(out io.Writer, title string, totalFiles int) *Dashboard {
+	return &Dashboard{out: out, title: title, totalFiles: totalFiles}
+}
+
+// Start redraws the dashboard every interval until Stop.
+func (d *Dashboard) Start(interval time.Duration) {
+	if d == nil {
+		return
+	}
+	d.start = time.Now()
+	d.stop = make(chan struct{})
+	d.wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := d.start
+		for {
+			select {
+			case <-d.stop:
+				d.frame(time.Since(last))
+				return
+			case now := <-ticker.C:
+				d.frame(now.Sub(last))
+				last = now
+			}
+		}
+	})
+}
+
+// Stop draws the final frame and leaves it on the screen.
+func (d *Dashboard) Stop() {
+	if d == nil {
+		return
+	}
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// Watch adds the occupancy of ch to the dashboard.
+func Watch[T any](d *Dashboard, name string, ch chan T) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gauges = append(d.gauges, gauge{
+		name: name,
+		len:  func() int { return len(ch) },
+		cap:  func() int { return cap(ch) },
+	})
+}
+
+// Worker registers a goroutine and returns its row.
+func (d *Dashboard) Worker() *Worker {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := &Worker{id: len(d.workers)}
+	d.workers = append(d.workers, w)
+	return w
+}
+
+// Worker is the row of one goroutine. Its methods are called by the
+// goroutine itself; the dashboard only reads the atomic fields.
+//
+// The bytes of a file count toward the throughput of the worker when it
+// finishes the file, so that only the loop handing out the files is
+// instrumented and the code processing them stays as it is.
+type Worker struct {
+	id     int
+	file   atomic.Pointer[string]
+	bytes  atomic.Int64
+	files  atomic.Int64
+	exited atomic.Bool
+	size   int64 // of the current file; owned by the worker goroutine
+
+	// Owned by the drawing goroutine.
+	sampled int64
+	history []float64
+}
+
+// Begin records that the worker started processing the file name of root.
+func (w *Worker) Begin(root *os.Root, name string) {
+	if w == nil {
+		return
+	}
+	w.size = 0
+	if info, err := root.Stat(name); err == nil {
+		w.size = info.Size()
+	}
+	w.file.Store(&name)
+}
+
+// End records that the worker finished its current file.
+func (w *Worker) End() {
+	if w != nil {
+		w.file.Store(nil)
+		w.bytes.Add(w.size)
+		w.files.Add(1)
+	}
+}
+
+// Exit records that the worker goroutine returned.
+func (w *Worker) Exit() {
+	if w != nil {
+		w.exited.Store(true)
+	}
+}
+
+// frame samples everything and redraws the dashboard.
+func (d *Dashboard) frame(interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seconds := max(interval.Seconds(), 1e-9)
+	var totalRate float64
+	var filesDone int64
+	for _, w := range d.workers {
+		bytes := w.bytes.Load()
+		rate := float64(bytes-w.sampled) / seconds
+		w.sampled = bytes
+		w.history = appendSample(w.history, rate)
+		totalRate += rate
+		filesDone += w.files.Load()
+	}
+	d.total = appendSample(d.total, totalRate)
+
+	var b strings.Builder
+	lines := 0
+	line := func(format string, args ...any) {
+		// \x1b[2K clears the line left over from the previous frame.
+		fmt.Fprintf(&b, "\x1b[2K"+format+"\n", args...)
+		lines++
+	}
+
+	busy := 0
+	for _, w := range d.workers {
+		if w.file.Load() != nil {
+			busy++
+		}
+	}
+
+	line("\x1b[1m%s\x1b[0m  %.1fs  files %d/%d  goroutines %d busy / %d started",
+		d.title, time.Since(d.start).Seconds(), filesDone, d.totalFiles, busy, len(d.workers))
+	line("total   %s %8.1fMB/s", sparkline(d.total, peak(d.total)), totalRate/(1024*1024))
+
+	if len(d.gauges) > 0 {
+		line("")
+		for _, g := range d.gauges {
+			n, c := g.len(), g.cap()
+			line("%-8s %s %d/%d", g.name, bar(n, c, 20), n, c)
+		}
+	}
+
+	line("")
+	rows := d.rows()
+	workerPeak := 0.0
+	for _, w := range rows {
+		workerPeak = max(workerPeak, peak(w.history))
+	}
+	for _, w := range rows {
+		state := "idle"
+		if f := w.file.Load(); f != nil {
+			state = *f
+		} else if w.exited.Load() {
+			state = "done"
+		}
+		line("#%-4d %-20s %s %8.1fMB/s %5d files",
+			w.id, state, sparkline(w.history, workerPeak), w.history[len(w.history)-1]/(1024*1024), w.files.Load())
+	}
+	if hidden := len(d.workers) - len(rows); hidden > 0 {
+		line("(+%d more)", hidden)
+	}
+
+	// Move back to the first line of the previous frame and draw over it,
+	// then clear whatever a longer previous frame left below.
+	var out strings.Builder
+	if d.drawn > 0 {
+		fmt.Fprintf(&out, "\x1b[%dA", d.drawn)
+	}
+	out.WriteString(b.String())
+	if d.drawn > lines {
+		out.WriteString("\x1b[J")
+	}
+	d.drawn = lines
+	io.WriteString(d.out, out.String())
+}
+
+// rows returns the workers to draw: busy ones first, then those that
+// finished the most files, up to MaxRows.
+func (d *Dashboard) rows() []*Worker {
+	rows := slices.Clone(d.workers)
+	slices.SortStableFunc(rows, func(a, b *Worker) int {
+		aBusy, bBusy := a.file.Load() != nil, b.file.Load() != nil
+		if aBusy != bBusy {
+			if aBusy {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(b.files.Load(), a.files.Load())
+	})
+	maxRows := d.MaxRows
+	if maxRows <= 0 {
+		maxRows = DefaultMaxRows
+	}
+	rows = rows[:min(len(rows), maxRows)]
+	slices.SortFunc(rows, func(a, b *Worker) int { return cmp.Compare(a.id, b.id) })
+	return rows
+}
+
+func appendSample(history []float64, v float64) []float64 {
+	history = append(history, v)
+	if len(history) > historyLen {
+		history = history[len(history)-historyLen:]
+	}
+	return history
+}
+
+func peak(history []float64) float64 {
+	p := 0.0
+	for _, v := range history {
+		p = max(p, v)
+	}
+	return p
+}
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws history scaled to top, padded to historyLen.
+func sparkline(history []float64, top float64) string {
+	var b strings.Builder
+	b.WriteString(strings.Repeat(" ", historyLen-len(history)))
+	for _, v := range history {
+		i := 0
+		if top > 0 {
+			i = min(int(v/top*float64(len(sparks)-1)+0.5), len(sparks)-1)
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
+
+// bar draws n out of c as a bar of width cells.
+func bar(n, c, width int) string {
+	filled := 0
+	if c > 0 {
+		filled = min(n*width/c, width)
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
@@ -0,0 +1,362 @@
+// Package dist distributes the analysis over several processes, possibly on
+// different hosts sharing the log directory.
+//
+// A Coordinator holds the tasks planned by engine.PlanTasks, whole files or
+// ranges of files, and leases them to worker processes over net/rpc. A
+// worker renews its lease while it works and returns the serialized Result
+// of the task. A lease that is not renewed in time, because its worker died
+// or hung, expires and its task is leased to another worker. The results
+// are merged per file like engine.Run merges the ranges of a file.
+package dist
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// DefaultLeaseTTL is how long a lease lasts without being renewed.
+const DefaultLeaseTTL = 10 * time.Second
+
+// serviceName is the name of the coordinator's net/rpc service.
+const serviceName = "Coordinator"
+
+// LeaseArgs asks for a task.
+type LeaseArgs struct {
+	Worker string
+}
+
+// LeaseReply is a leased task, or tells the worker to wait or to stop.
+type LeaseReply struct {
+	Lease int64
+	Task  engine.Task
+	// TTL is how long the lease lasts unless renewed.
+	TTL time.Duration
+	// Wait means that every remaining task is leased; ask again later, as
+	// a lease may expire.
+	Wait bool
+	// Done means that every task is completed.
+	Done bool
+}
+
+// RenewArgs extends a lease by its TTL.
+type RenewArgs struct {
+	Lease int64
+}
+
+// RenewReply tells whether the lease is still held. A lost lease can still
+// be completed, but another worker may complete it first.
+type RenewReply struct {
+	OK bool
+}
+
+// CompleteArgs returns the outcome of a leased task: its Result encoded by
+// logparser.Result.MarshalBinary, or the error that processing it failed
+// with.
+type CompleteArgs struct {
+	Lease  int64
+	Worker string
+	Result []byte
+	Err    string
+}
+
+// CompleteReply acknowledges a completion.
+type CompleteReply struct{}
+
+// Config tunes a Coordinator.
+type Config struct {
+	// LeaseTTL is how long a lease lasts without being renewed. Zero means
+	// DefaultLeaseTTL.
+	LeaseTTL time.Duration
+	// Logger receives every expired lease. Nil disables logging.
+	Logger *log.Logger
+}
+
+type taskStatus int
+
+const (
+	pending taskStatus = iota
+	leased
+	completed
+)
+
+type taskState struct {
+	task     engine.Task
+	status   taskStatus
+	lease    int64 // current lease while leased
+	worker   string
+	deadline time.Time
+}
+
+// Coordinator leases tasks to workers and collects their results. It is
+// safe for concurrent use.
+type Coordinator struct {
+	cfg   Config
+	files []string
+
+	mu        sync.Mutex
+	tasks     []taskState
+	queue     []int          // pending tasks, next first
+	leases    map[int64]int  // every lease granted, to its task
+	remaining map[string]int // unfinished tasks per file
+	results   map[string]*logparser.Result
+	failed    map[string]bool
+	errs      []error
+	nextLease int64
+	expired   int
+	left      int
+	done      chan struct{}
+	conns     int // connected workers
+}
+
+// NewCoordinator returns a coordinator for tasks planned over files.
+func NewCoordinator(files []string, tasks []engine.Task, cfg Config) *Coordinator {
+	if cfg.LeaseTTL <= 0 {
+		cfg.LeaseTTL = DefaultLeaseTTL
+	}
+	c := &Coordinator{
+		cfg:       cfg,
+		files:     files,
+		tasks:     make([]taskState, len(tasks)),
+		queue:     make([]int, len(tasks)),
+		leases:    make(map[int64]int),
+		remaining: make(map[string]int),
+		results:   make(map[string]*logparser.Result),
+		failed:    make(map[string]bool),
+		left:      len(tasks),
+		done:      make(chan struct{}),
+	}
+	for i, task := range tasks {
+		c.tasks[i] = taskState{task: task}
+		c.queue[i] = i
+		c.remaining[task.File]++
+	}
+	if c.left == 0 {
+		close(c.done)
+	}
+	return c
+}
+
+// Serve answers the workers connecting to l until l is closed.
+func (c *Coordinator) Serve(l net.Listener) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(serviceName, &service{c}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		c.mu.Lock()
+		c.conns++
+		c.mu.Unlock()
+		go func() {
+			server.ServeConn(conn)
+			c.mu.Lock()
+			c.conns--
+			c.mu.Unlock()
+		}()
+	}
+}
+
+// WaitDisconnect waits up to timeout for every worker to disconnect, which
+// they do once told that every task is completed, and reports whether they
+// did. Stopping to serve earlier would make them fail.
+func (c *Coordinator) WaitDisconnect(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		c.mu.Lock()
+		conns := c.conns
+		c.mu.Unlock()
+		if conns == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Done is closed when every task is completed.
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+// Remaining returns the number of tasks not completed yet.
+func (c *Coordinator) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.left
+}
+
+// Stats returns the number of leases granted and how many of them expired.
+func (c *Coordinator) Stats() (leases, expired int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.leases), c.expired
+}
+
+// Results returns one Result per file whose tasks all completed, in the
+// order of the files, and the errors of the tasks that failed. Call it
+// after Done is closed.
+func (c *Coordinator) Results() ([]*logparser.Result, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := make([]*logparser.Result, 0, len(c.files))
+	for _, name := range c.files {
+		if r, ok := c.results[name]; ok && !c.failed[name] && c.remaining[name] == 0 {
+			results = append(results, r)
+		}
+	}
+	return results, errors.Join(c.errs...)
+}
+
+// lease hands out the next pending task, after returning the tasks of
+// expired leases to the front of the queue.
+func (c *Coordinator) lease(worker string, reply *LeaseReply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for i := range c.tasks {
+		t := &c.tasks[i]
+		if t.status == leased && now.After(t.deadline) {
+			c.logf("lease %d of %s by %s expired, reassigning", t.lease, t.task, t.worker)
+			t.status = pending
+			c.queue = append([]int{i}, c.queue...)
+			c.expired++
+		}
+	}
+
+	switch {
+	case c.left == 0:
+		reply.Done = true
+	case len(c.queue) == 0:
+		reply.Wait = true
+	default:
+		i := c.queue[0]
+		c.queue = c.queue[1:]
+		c.nextLease++
+		c.leases[c.nextLease] = i
+		c.tasks[i] = taskState{
+			task:     c.tasks[i].task,
+			status:   leased,
+			lease:    c.nextLease,
+			worker:   worker,
+			deadline: now.Add(c.cfg.LeaseTTL),
+		}
+		reply.Lease = c.nextLease
+		reply.Task = c.tasks[i].task
+		reply.TTL = c.cfg.LeaseTTL
+	}
+}
+
+func (c *Coordinator) renew(lease int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, ok := c.leases[lease]
+	if !ok || c.tasks[i].status != leased || c.tasks[i].lease != lease {
+		return false
+	}
+	c.tasks[i].deadline = time.Now().Add(c.cfg.LeaseTTL)
+	return true
+}
+
+// complete records the outcome of a task. A task completed by one lease
+// ignores the completions of the others, so a worker that was presumed
+// dead does not count its task twice. A failure is only recorded under
+// the current lease: the task of an expired one is, or will be, processed
+// again by another worker, which may well succeed.
+func (c *Coordinator) complete(args *CompleteArgs) error {
+	var result *logparser.Result
+	if args.Err == "" {
+		result = &logparser.Result{}
+		if err := result.UnmarshalBinary(args.Result); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i, ok := c.leases[args.Lease]
+	if !ok {
+		return fmt.Errorf("unknown lease %d", args.Lease)
+	}
+	t := &c.tasks[i]
+	if t.status == completed {
+		return nil
+	}
+	if args.Err != "" && (t.status != leased || t.lease != args.Lease) {
+		c.logf("lease %d of %s by %s failed after expiring, ignored: %s", args.Lease, t.task, args.Worker, args.Err)
+		return nil
+	}
+	if t.status == pending {
+		// An expired lease completed before the task was leased again.
+		c.queue = deleteValue(c.queue, i)
+	}
+	t.status = completed
+
+	file := t.task.File
+	switch {
+	case args.Err != "":
+		c.errs = append(c.errs, fmt.Errorf("%s (worker %s): %s", t.task, args.Worker, args.Err))
+		c.failed[file] = true
+	case c.results[file] == nil:
+		result.FileName = file
+		c.results[file] = result
+	default:
+		c.results[file].Merge(result)
+	}
+	c.remaining[file]--
+	if c.left--; c.left == 0 {
+		close(c.done)
+	}
+	return nil
+}
+
+func (c *Coordinator) logf(format string, args ...any) {
+	if c.cfg.Logger != nil {
+		c.cfg.Logger.Printf(format, args...)
+	}
+}
+
+func deleteValue(s []int, v int) []int {
+	for i, x := range s {
+		if x == v {
+			return append(s[:i], s[i+1:]...)
+		}
+	}
+	return s
+}
+
+// service exposes the coordinator over net/rpc, which requires methods of
+// this exact shape.
+type service struct {
+	c *Coordinator
+}
+
+func (s *service) Lease(args *LeaseArgs, reply *LeaseReply) error {
+	s.c.lease(args.Worker, reply)
+	return nil
+}
+
+func (s *service) Renew(args *RenewArgs, reply *RenewReply) error {
+	reply.OK = s.c.renew(args.Lease)
+	return nil
+}
+
+func (s *service) Complete(args *CompleteArgs, _ *CompleteReply) error {
+	return s.c.complete(args)
+}
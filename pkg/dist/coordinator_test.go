@@ -0,0 +1,72 @@
+package dist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// TestExpiredLeaseFailure checks that a failure reported under an expired
+// lease neither completes the task nor drops its file from the results.
+func TestExpiredLeaseFailure(t *testing.T) {
+	files := []string{"access_1.json", "access_2.json"}
+	tasks := []engine.Task{{File: files[0], Length: -1}, {File: files[1], Length: -1}}
+	const ttl = 50 * time.Millisecond
+	c := NewCoordinator(files, tasks, Config{LeaseTTL: ttl})
+
+	var hung, slow LeaseReply
+	c.lease("hung", &hung)
+	c.lease("slow", &slow)
+	time.Sleep(2 * ttl)
+
+	// Both leases expire: the second task is leased again and the first one
+	// is pending.
+	var second LeaseReply
+	c.lease("healthy", &second)
+	if second.Task != tasks[1] {
+		t.Fatalf("got %s leased again, want %s", second.Task, tasks[1])
+	}
+	fail := func(lease int64, worker string) {
+		t.Helper()
+		if err := c.complete(&CompleteArgs{Lease: lease, Worker: worker, Err: "read timeout"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fail(hung.Lease, "hung")
+	fail(slow.Lease, "slow")
+	if left := c.Remaining(); left != 2 {
+		t.Fatalf("got %d tasks left after failures under expired leases, want 2", left)
+	}
+
+	// The pending task stayed queued.
+	var first LeaseReply
+	c.lease("healthy", &first)
+	if first.Task != tasks[0] {
+		t.Fatalf("got %s leased, want %s", first.Task, tasks[0])
+	}
+
+	for _, lease := range []int64{first.Lease, second.Lease} {
+		result := &logparser.Result{TotalCount: 3, StatusCounts: map[int]int{200: 3}}
+		data, err := result.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.complete(&CompleteArgs{Lease: lease, Worker: "healthy", Result: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("not done after every task completed")
+	}
+	results, err := c.Results()
+	if err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+	if len(results) != len(files) {
+		t.Errorf("got results for %d files, want %d", len(results), len(files))
+	}
+}
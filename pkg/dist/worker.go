@@ -0,0 +1,110 @@
+package dist
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/engine"
+)
+
+// ErrAbandoned is returned by Work when it gives up a lease on purpose.
+var ErrAbandoned = errors.New("lease abandoned")
+
+// waitInterval is how long a worker waits when every task is leased.
+const waitInterval = 200 * time.Millisecond
+
+// WorkerConfig configures Work.
+type WorkerConfig struct {
+	// Name identifies the worker in the coordinator's logs.
+	Name string
+	// Reader is the file reading backend. Nil means engine.BufferedReader.
+	Reader engine.Reader
+	// Logger receives every task processed. Nil disables logging.
+	Logger *log.Logger
+	// AbandonAfter, if positive, makes Work return ErrAbandoned without
+	// completing or renewing its lease once it has taken that many leases,
+	// as if the process died. It exists to exercise lease expiry.
+	AbandonAfter int
+}
+
+// Work processes the tasks leased by the coordinator at addr, reading the
+// log files under root, until every task is completed. It returns the
+// number of tasks it completed.
+func Work(addr string, root *os.Root, cfg WorkerConfig) (int, error) {
+	if cfg.Reader == nil {
+		cfg.Reader = engine.BufferedReader{}
+	}
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	completed, leases := 0, 0
+	for {
+		var lease LeaseReply
+		if err := client.Call(serviceName+".Lease", &LeaseArgs{Worker: cfg.Name}, &lease); err != nil {
+			return completed, err
+		}
+		switch {
+		case lease.Done:
+			return completed, nil
+		case lease.Wait:
+			time.Sleep(waitInterval)
+			continue
+		}
+
+		if leases++; cfg.AbandonAfter > 0 && leases >= cfg.AbandonAfter {
+			return completed, ErrAbandoned
+		}
+
+		args := &CompleteArgs{Lease: lease.Lease, Worker: cfg.Name}
+		stop := keepRenewing(client, lease)
+		result, err := engine.CountTask(cfg.Reader, root, lease.Task)
+		stop()
+		if err == nil {
+			args.Result, err = result.MarshalBinary()
+		}
+		if err != nil {
+			args.Err = err.Error()
+		}
+		if err := client.Call(serviceName+".Complete", args, &CompleteReply{}); err != nil {
+			return completed, err
+		}
+		completed++
+		if cfg.Logger != nil {
+			cfg.Logger.Printf("%s: completed %s", cfg.Name, lease.Task)
+		}
+	}
+}
+
+// keepRenewing renews the lease three times per TTL until stop is called.
+func keepRenewing(client *rpc.Client, lease LeaseReply) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		ticker := time.NewTicker(max(lease.TTL/3, time.Millisecond))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				var reply RenewReply
+				if err := client.Call(serviceName+".Renew", &RenewArgs{Lease: lease.Lease}, &reply); err != nil || !reply.OK {
+					// Finish the task anyway; the coordinator keeps the
+					// first completion.
+					return
+				}
+			}
+		}
+	})
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"sync"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// cacheVersion is the version of the cache file format.
+const cacheVersion = 1
+
+// Aggregator identifies how Execute turns a file into a Result. Cached
+// results computed by a different aggregator are discarded, so it must
+// change whenever countTask counts something differently.
+const Aggregator = "status-codes/v1"
+
+// cachePrefixSize is how much of a file is hashed for its CacheKey.
+const cachePrefixSize = 64 * 1024
+
+// CacheKey identifies the content of a file well enough to reuse its
+// result: a rotated log keeps its size and modification time, and a file
+// rewritten with the same ones is very unlikely to keep its first bytes.
+type CacheKey struct {
+	Fingerprint
+	Prefix string `json:"prefix_sha256"`
+}
+
+// FileCacheKey returns the cache key of a file under root.
+func FileCacheKey(root *os.Root, name string) (CacheKey, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return CacheKey{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return CacheKey{}, err
+	}
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, file, cachePrefixSize); err != nil && err != io.EOF {
+		return CacheKey{}, err
+	}
+	return CacheKey{
+		Fingerprint: Fingerprint{Size: info.Size(), ModTime: info.ModTime().UnixNano()},
+		Prefix:      hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+type cacheEntry struct {
+	CacheKey
+	Result *logparser.Result `json:"result"`
+}
+
+// Cache keeps the result of every file analyzed, so that a later run only
+// parses the files that are new or changed. It is safe for concurrent use.
+type Cache struct {
+	path string
+
+	mu         sync.Mutex
+	aggregator string
+	entries    map[string]cacheEntry
+	used       map[string]bool
+}
+
+type cacheFile struct {
+	Version    int                   `json:"version"`
+	Aggregator string                `json:"aggregator"`
+	Files      map[string]cacheEntry `json:"files"`
+}
+
+// OpenCache loads the cache stored at path. A missing file, or one written
+// by another version, gives an empty cache.
+func OpenCache(path string) (*Cache, error) {
+	c := &Cache{path: path, entries: make(map[string]cacheEntry), used: make(map[string]bool)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored cacheFile
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("invalid cache %s: %w", path, err)
+	}
+	if stored.Version != cacheVersion {
+		return c, nil
+	}
+	c.aggregator = stored.Aggregator
+	for name, entry := range stored.Files {
+		if entry.Result != nil && entry.Result.StatusCounts != nil {
+			c.entries[name] = entry
+		}
+	}
+	return c, nil
+}
+
+// Save writes the entries looked up or stored since OpenCache, dropping
+// those of files that are gone. Like Checkpoint.Save it is atomic.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	stored := cacheFile{Version: cacheVersion, Aggregator: c.aggregator, Files: make(map[string]cacheEntry)}
+	for name, entry := range c.entries {
+		if c.used[name] {
+			stored.Files[name] = entry
+		}
+	}
+	c.mu.Unlock()
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(c.path, data)
+}
+
+// use discards the entries if they were computed by another aggregator.
+func (c *Cache) use(aggregator string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aggregator != aggregator {
+		c.aggregator = aggregator
+		clear(c.entries)
+	}
+}
+
+// lookup returns a copy of the cached result of name if key still matches.
+func (c *Cache) lookup(name string, key CacheKey) (*logparser.Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[name]
+	if !ok || entry.CacheKey != key {
+		return nil, false
+	}
+	c.used[name] = true
+	return cloneResult(entry.Result), true
+}
+
+// keep makes Save keep the entry of name, if any, without checking it,
+// for files that a run skips for another reason.
+func (c *Cache) keep(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.used[name] = true
+}
+
+// store records the result of name.
+func (c *Cache) store(name string, key CacheKey, result *logparser.Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[name] = cacheEntry{CacheKey: key, Result: cloneResult(result)}
+	c.used[name] = true
+}
+
+func cloneResult(r *logparser.Result) *logparser.Result {
+	clone := *r
+	clone.StatusCounts = maps.Clone(r.StatusCounts)
+	return &clone
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// checkpointVersion is the version of the checkpoint file format.
+const checkpointVersion = 1
+
+// CheckpointOptions makes Execute save its progress so that an interrupted
+// run can be resumed.
+type CheckpointOptions struct {
+	// Path is the checkpoint file.
+	Path string
+	// Interval is how often the checkpoint is saved. Zero means 5s. It is
+	// also saved when the run ends.
+	Interval time.Duration
+	// Resume skips the files completed in an existing checkpoint and
+	// starts from its aggregate. A missing checkpoint starts a new run.
+	Resume bool
+}
+
+// Fingerprint identifies the content of a file cheaply.
+type Fingerprint struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime_ns"`
+}
+
+// FileFingerprint returns the fingerprint of a file under root.
+func FileFingerprint(root *os.Root, name string) (Fingerprint, error) {
+	info, err := root.Stat(name)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	return Fingerprint{Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+// Checkpoint is the progress of a run: the files completed so far and the
+// aggregate of their results.
+type Checkpoint struct {
+	Version int                    `json:"version"`
+	Files   map[string]Fingerprint `json:"files"`
+	Total   *logparser.TotalResult `json:"total"`
+}
+
+// NewCheckpoint returns an empty checkpoint.
+func NewCheckpoint() *Checkpoint {
+	return &Checkpoint{
+		Version: checkpointVersion,
+		Files:   make(map[string]Fingerprint),
+		Total:   logparser.NewTotalResult(),
+	}
+}
+
+// LoadCheckpoint reads a checkpoint file. It returns an error wrapping
+// fs.ErrNotExist if there is none.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Checkpoint{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %w", path, err)
+	}
+	if c.Version != checkpointVersion {
+		return nil, fmt.Errorf("checkpoint %s has version %d, expected %d", path, c.Version, checkpointVersion)
+	}
+	if c.Files == nil || c.Total == nil || c.Total.StatusCounts == nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: missing files or total", path)
+	}
+	return c, nil
+}
+
+// Save writes the checkpoint atomically: a crash while saving leaves the
+// previous checkpoint in place.
+func (c *Checkpoint) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(path, data)
+}
+
+// writeAtomic replaces the file at path with data through a temporary file
+// in the same directory.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// clone returns a deep copy, so that it can be saved while the run goes on.
+func (c *Checkpoint) clone() *Checkpoint {
+	total := *c.Total
+	total.StatusCounts = maps.Clone(c.Total.StatusCounts)
+	return &Checkpoint{Version: c.Version, Files: maps.Clone(c.Files), Total: &total}
+}
+
+// add records a completed file.
+func (c *Checkpoint) add(name string, fp Fingerprint, result *logparser.Result) {
+	c.Files[name] = fp
+	c.Total.Add(result)
+}
+
+// pending returns the files that the checkpoint has not completed. The
+// aggregate cannot be taken apart again, so it fails if a completed file
+// was changed or removed since.
+func (c *Checkpoint) pending(files []string, fingerprints map[string]Fingerprint) ([]string, error) {
+	var errs []error
+	for name, fp := range c.Files {
+		current, ok := fingerprints[name]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s was completed but is no longer present", name))
+		case current != fp:
+			errs = append(errs, fmt.Errorf("%s changed after it was completed", name))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("checkpoint does not match the log files: %w", errors.Join(errs...))
+	}
+
+	var rest []string
+	for _, name := range files {
+		if _, done := c.Files[name]; !done {
+			rest = append(rest, name)
+		}
+	}
+	return rest, nil
+}
+
+// openCheckpoint returns the checkpoint a run starts from and the files it
+// still has to process.
+func openCheckpoint(opts *CheckpointOptions, files []string, fingerprints map[string]Fingerprint) (*Checkpoint, []string, error) {
+	if !opts.Resume {
+		return NewCheckpoint(), files, nil
+	}
+	c, err := LoadCheckpoint(opts.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return NewCheckpoint(), files, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	rest, err := c.pending(files, fingerprints)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, rest, nil
+}
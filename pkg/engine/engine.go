@@ -0,0 +1,90 @@
+// Package engine provides the building blocks shared by the concurrent log
+// analyzers: discovering log files and streaming their entries.
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+)
+
+// ListLogFiles returns the names of the access_*.json files directly under
+// root, including gzip-compressed access_*.json.gz archives and
+// access_*.seg segments.
+func ListLogFiles(root *os.Root) ([]string, error) {
+	entries, err := fs.ReadDir(root.FS(), ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log directory: %w", err)
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, "access_") &&
+			(strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".json.gz") || IsSegment(name)) {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// IsSegment reports whether a log file is a columnar segment written by
+// pkg/segment rather than JSON lines.
+func IsSegment(filename string) bool {
+	return strings.HasSuffix(filename, segment.Ext)
+}
+
+// ScanFile decodes every entry of a log file and passes it to fn.
+// Malformed entries are skipped and gzip-compressed files are decompressed.
+// The entry is reused between calls, so fn must copy anything it keeps.
+func ScanFile(root *os.Root, filename string, fn func(*logparser.LogEntry)) error {
+	return ScanColumns(root, filename, segment.All, fn)
+}
+
+// ScanColumns is ScanFile for an analysis that only uses the given fields.
+// JSON files are decoded in full, but only the given columns of segments
+// are read, and the other fields of the entries are left empty. With
+// segment.PathTemplate but not segment.Path, entry.Path may be the path
+// template instead of the path.
+func ScanColumns(root *os.Root, filename string, columns segment.Columns, fn func(*logparser.LogEntry)) error {
+	if IsSegment(filename) {
+		return scanSegment(root, filename, columns, fn)
+	}
+
+	// Lines are decoded one by one: a syntax error is sticky in a
+	// json.Decoder, so a single malformed line would stop it for good.
+	var entry logparser.LogEntry
+	return BufferedReader{}.ReadChunks(root, filename, func(chunk []byte) error {
+		Lines(chunk, func(line []byte) {
+			entry = logparser.LogEntry{}
+			if json.Unmarshal(line, &entry) == nil {
+				fn(&entry)
+			}
+		})
+		return nil
+	})
+}
+
+func scanSegment(root *os.Root, filename string, columns segment.Columns, fn func(*logparser.LogEntry)) error {
+	file, err := segment.Open(root, filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	batch, err := file.Read(columns)
+	if err != nil {
+		return err
+	}
+	var entry logparser.LogEntry
+	for i := range batch.Rows {
+		batch.Entry(i, &entry)
+		fn(&entry)
+	}
+	return nil
+}
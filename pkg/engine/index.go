@@ -0,0 +1,335 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"time"
+)
+
+// IndexExt is appended to the name of a log file to get the name of its
+// index.
+const IndexExt = ".idx"
+
+// DefaultIndexInterval is the number of lines per block of an index.
+const DefaultIndexInterval = 4096
+
+// indexVersion is the version of the index format.
+const indexVersion = 1
+
+// indexMagic starts every index.
+var indexMagic = []byte("LIDX")
+
+// ErrStaleIndex is returned by LoadIndex for an index built before its log
+// file last changed.
+var ErrStaleIndex = errors.New("stale index")
+
+// Block is a run of consecutive lines of a log file.
+type Block struct {
+	Offset, Length int64 // byte range of the lines in the file
+	Lines          int
+	// Timestamps is the number of lines with a valid timestamp, and Min
+	// and Max their earliest and latest, in Unix nanoseconds.
+	Timestamps int
+	Min, Max   int64
+}
+
+// Index is a sparse index of the timestamps of a log file: the time span
+// of every block of Interval lines. Log files are not sorted by time, so
+// blocks usually overlap, but a file that covers a period of its own, or
+// a sorted one, can be skipped entirely or read from the middle.
+type Index struct {
+	// Fingerprint is the size and modification time of the file when the
+	// index was built. LoadIndex rejects the index once they change.
+	Fingerprint Fingerprint
+	Interval    int
+	// Seekable reports whether the blocks can be read on their own. Blocks
+	// of gzip-compressed files are offsets in the decompressed stream, so
+	// these are read whole if any block overlaps a range.
+	Seekable bool
+	Blocks   []Block
+}
+
+// BuildIndex reads a log file and indexes every interval lines. A zero
+// interval means DefaultIndexInterval.
+func BuildIndex(root *os.Root, name string, interval int) (*Index, error) {
+	if interval <= 0 {
+		interval = DefaultIndexInterval
+	}
+	if IsSegment(name) {
+		return nil, fmt.Errorf("%s is a segment, whose header holds its time span", name)
+	}
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 2)
+	n, _ := file.ReadAt(header, 0)
+
+	ix := &Index{
+		Fingerprint: Fingerprint{Size: info.Size(), ModTime: info.ModTime().UnixNano()},
+		Interval:    interval,
+		Seekable:    !isGzip(header[:n]),
+	}
+	var block Block
+	var pos int64
+	flush := func() {
+		if block.Lines > 0 {
+			ix.Blocks = append(ix.Blocks, block)
+		}
+		block = Block{Offset: pos}
+	}
+	err = BufferedReader{}.readFrom(file, func(chunk []byte) error {
+		for len(chunk) > 0 {
+			line := chunk
+			if i := bytes.IndexByte(chunk, '\n'); i >= 0 {
+				line = chunk[:i+1]
+			}
+			chunk = chunk[len(line):]
+			pos += int64(len(line))
+			if len(bytes.TrimSpace(line)) == 0 {
+				block.Length += int64(len(line))
+				continue
+			}
+			block.add(line)
+			if block.Lines == interval {
+				flush()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	flush()
+	return ix, nil
+}
+
+// add counts a line of the block.
+func (b *Block) add(line []byte) {
+	b.Lines++
+	b.Length += int64(len(line))
+	var entry struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if json.Unmarshal(line, &entry) != nil {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		return
+	}
+	ns := t.UnixNano()
+	if b.Timestamps == 0 {
+		b.Min, b.Max = ns, ns
+	}
+	b.Min, b.Max = min(b.Min, ns), max(b.Max, ns)
+	b.Timestamps++
+}
+
+// Span returns the earliest and latest timestamps of the file, or false if
+// it has none.
+func (ix *Index) Span() (first, last time.Time, ok bool) {
+	var lo, hi int64
+	for _, b := range ix.Blocks {
+		if b.Timestamps == 0 {
+			continue
+		}
+		if !ok {
+			lo, hi, ok = b.Min, b.Max, true
+		}
+		lo, hi = min(lo, b.Min), max(hi, b.Max)
+	}
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(0, lo).UTC(), time.Unix(0, hi).UTC(), true
+}
+
+// Ranges returns the byte ranges of the file holding the lines that may
+// fall in r, merging adjacent blocks. Unseekable files are a single range.
+func (ix *Index) Ranges(r TimeRange) []Block {
+	var ranges []Block
+	for _, b := range ix.Blocks {
+		if b.Timestamps == 0 || !r.overlaps(b.Min, b.Max) {
+			continue
+		}
+		if !ix.Seekable {
+			return []Block{{Length: ix.Fingerprint.Size}}
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].Offset+ranges[n-1].Length == b.Offset {
+			last := &ranges[n-1]
+			last.Length += b.Length
+			last.Lines += b.Lines
+			last.Timestamps += b.Timestamps
+			last.Min, last.Max = min(last.Min, b.Min), max(last.Max, b.Max)
+			continue
+		}
+		ranges = append(ranges, b)
+	}
+	return ranges
+}
+
+// IndexName returns the name of the index of a log file.
+func IndexName(name string) string {
+	return name + IndexExt
+}
+
+// MarshalBinary encodes ix with a trailing CRC-32 of the rest.
+func (ix *Index) MarshalBinary() ([]byte, error) {
+	buf := bytes.Clone(indexMagic)
+	buf = binary.AppendUvarint(buf, indexVersion)
+	buf = binary.AppendVarint(buf, ix.Fingerprint.Size)
+	buf = binary.AppendVarint(buf, ix.Fingerprint.ModTime)
+	buf = binary.AppendUvarint(buf, uint64(ix.Interval))
+	seekable := byte(0)
+	if ix.Seekable {
+		seekable = 1
+	}
+	buf = append(buf, seekable)
+	buf = binary.AppendUvarint(buf, uint64(len(ix.Blocks)))
+	for _, b := range ix.Blocks {
+		// Blocks are consecutive, so the offsets follow from the lengths.
+		buf = binary.AppendUvarint(buf, uint64(b.Length))
+		buf = binary.AppendUvarint(buf, uint64(b.Lines))
+		buf = binary.AppendUvarint(buf, uint64(b.Timestamps))
+		buf = binary.AppendVarint(buf, b.Min)
+		buf = binary.AppendVarint(buf, b.Max-b.Min)
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf)), nil
+}
+
+// UnmarshalBinary decodes data encoded by MarshalBinary.
+func (ix *Index) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, indexMagic) || len(data) < len(indexMagic)+4 {
+		return errors.New("invalid index: bad magic number")
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return errors.New("invalid index: checksum mismatch")
+	}
+	d := indexDecoder{data: body[len(indexMagic):]}
+	if v := d.uvarint(); d.err == nil && v != indexVersion {
+		return fmt.Errorf("unsupported index version %d", v)
+	}
+	decoded := Index{
+		Fingerprint: Fingerprint{Size: d.varint(), ModTime: d.varint()},
+		Interval:    int(d.uvarint()),
+		Seekable:    d.byte() == 1,
+	}
+	n := d.uvarint()
+	var offset int64
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		b := Block{
+			Offset:     offset,
+			Length:     int64(d.uvarint()),
+			Lines:      int(d.uvarint()),
+			Timestamps: int(d.uvarint()),
+			Min:        d.varint(),
+		}
+		b.Max = b.Min + d.varint()
+		offset += b.Length
+		decoded.Blocks = append(decoded.Blocks, b)
+	}
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d trailing bytes", len(d.data))
+	}
+	if d.err != nil {
+		return fmt.Errorf("invalid index: %w", d.err)
+	}
+	*ix = decoded
+	return nil
+}
+
+// SaveIndex writes the index of a log file next to it.
+func SaveIndex(root *os.Root, name string, ix *Index) error {
+	data, err := ix.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	tmp := IndexName(name) + ".tmp"
+	if err := root.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := root.Rename(tmp, IndexName(name)); err != nil {
+		root.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LoadIndex reads the index of a log file. It returns an error wrapping
+// fs.ErrNotExist if there is none, and ErrStaleIndex if the size or the
+// modification time of the file changed since the index was built.
+func LoadIndex(root *os.Root, name string) (*Index, error) {
+	data, err := root.ReadFile(IndexName(name))
+	if err != nil {
+		return nil, err
+	}
+	ix := &Index{}
+	if err := ix.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", IndexName(name), err)
+	}
+	fp, err := FileFingerprint(root, name)
+	if err != nil {
+		return nil, err
+	}
+	if fp != ix.Fingerprint {
+		return nil, fmt.Errorf("%s: %w", IndexName(name), ErrStaleIndex)
+	}
+	return ix, nil
+}
+
+// indexDecoder reads the fields of an index, remembering the first error.
+type indexDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *indexDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errors.New("truncated data")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *indexDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errors.New("truncated data")
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *indexDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.data) == 0 {
+		d.err = errors.New("truncated data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
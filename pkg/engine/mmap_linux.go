@@ -0,0 +1,111 @@
+//go:build linux
+
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+)
+
+// MmapReader maps files into memory with syscall.Mmap and hands out slices
+// of the mapping, so lines are parsed without being copied into a buffer.
+// Files that cannot be mapped (compressed, empty or not regular files, or
+// when mmap fails) are read with BufferedReader instead.
+type MmapReader struct {
+	ChunkSize int
+}
+
+// Name implements Reader.
+func (MmapReader) Name() string { return ReaderMmap }
+
+// ReadChunks implements Reader.
+func (r MmapReader) ReadChunks(root *os.Root, name string, fn func(chunk []byte) error) error {
+	return r.withMapping(root, name, func(file *os.File, fallback BufferedReader) error {
+		return fallback.readFrom(file, fn)
+	}, func(data []byte) error {
+		return r.emit(data, fn)
+	})
+}
+
+// ReadRange implements RangeReader.
+func (r MmapReader) ReadRange(root *os.Root, name string, offset, length int64, fn func(chunk []byte) error) error {
+	return r.withMapping(root, name, func(*os.File, BufferedReader) error {
+		return BufferedReader{ChunkSize: r.ChunkSize}.ReadRange(root, name, offset, length, fn)
+	}, func(data []byte) error {
+		lr := newLineRange(offset, length, fn)
+		if lr.from() >= int64(len(data)) {
+			return nil
+		}
+		err := r.emit(data[lr.from():], lr.chunk)
+		if errors.Is(err, errRangeDone) {
+			return nil
+		}
+		return err
+	})
+}
+
+// withMapping maps the file and calls mapped with its contents, or calls
+// fallback if the file cannot be mapped.
+func (r MmapReader) withMapping(root *os.Root, name string,
+	fallback func(file *os.File, buffered BufferedReader) error, mapped func(data []byte) error) error {
+	file, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	buffered := BufferedReader{ChunkSize: r.ChunkSize}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+	if !info.Mode().IsRegular() || size == 0 || int64(int(size)) != size {
+		return fallback(file, buffered)
+	}
+
+	header := make([]byte, 2)
+	if n, _ := file.ReadAt(header, 0); isGzip(header[:n]) {
+		return fallback(file, buffered)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return fallback(file, buffered)
+	}
+	defer syscall.Munmap(data)
+
+	// The file is read once from start to end: let the kernel read ahead
+	// aggressively and drop pages behind us. The hint is best effort.
+	_ = syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+
+	return mapped(data)
+}
+
+// emit hands out data in newline-aligned chunks of about ChunkSize bytes.
+func (r MmapReader) emit(data []byte, fn func(chunk []byte) error) error {
+	chunkSize := r.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	for len(data) > 0 {
+		end := len(data)
+		if end > chunkSize {
+			// Extend the chunk to the end of the line it stops in.
+			end = chunkSize
+			if i := bytes.IndexByte(data[end:], '\n'); i >= 0 {
+				end += i + 1
+			} else {
+				end = len(data)
+			}
+		}
+		if err := fn(data[:end]); err != nil {
+			return err
+		}
+		data = data[end:]
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package engine
+
+import "os"
+
+// MmapReader falls back to BufferedReader on platforms other than Linux.
+type MmapReader struct {
+	ChunkSize int
+}
+
+// Name implements Reader.
+func (MmapReader) Name() string { return ReaderMmap }
+
+// ReadChunks implements Reader.
+func (r MmapReader) ReadChunks(root *os.Root, name string, fn func(chunk []byte) error) error {
+	return BufferedReader{ChunkSize: r.ChunkSize}.ReadChunks(root, name, fn)
+}
+
+// ReadRange implements RangeReader.
+func (r MmapReader) ReadRange(root *os.Root, name string, offset, length int64, fn func(chunk []byte) error) error {
+	return BufferedReader{ChunkSize: r.ChunkSize}.ReadRange(root, name, offset, length, fn)
+}
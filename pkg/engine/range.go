@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// RangeReader is a Reader that can also read part of an uncompressed file,
+// which lets a scheduler split large files into several tasks.
+type RangeReader interface {
+	Reader
+	// ReadRange is ReadChunks restricted to the lines that start in
+	// [offset, offset+length). Splitting a file into consecutive ranges
+	// therefore hands out every line exactly once.
+	ReadRange(root *os.Root, name string, offset, length int64, fn func(chunk []byte) error) error
+}
+
+// errRangeDone stops reading once the end of a range has been passed.
+var errRangeDone = errors.New("end of range")
+
+// lineRange trims chunks read from position from (offset-1 or 0) down to
+// the lines starting in [offset, end).
+type lineRange struct {
+	pos, offset, end int64
+	started          bool
+	fn               func(chunk []byte) error
+}
+
+func newLineRange(offset, length int64, fn func(chunk []byte) error) *lineRange {
+	return &lineRange{
+		pos:     max(offset-1, 0),
+		offset:  offset,
+		end:     offset + length,
+		started: offset == 0,
+		fn:      fn,
+	}
+}
+
+// from returns the position reading has to start at.
+func (lr *lineRange) from() int64 { return lr.pos }
+
+func (lr *lineRange) chunk(chunk []byte) error {
+	pos := lr.pos
+	lr.pos += int64(len(chunk))
+
+	if !lr.started {
+		// Skip the rest of the line that starts before offset; the byte
+		// before offset tells whether a line starts right at offset.
+		i := bytes.IndexByte(chunk, '\n')
+		if i < 0 {
+			return nil
+		}
+		chunk = chunk[i+1:]
+		pos += int64(i + 1)
+		lr.started = true
+	}
+
+	if pos >= lr.end {
+		return errRangeDone
+	}
+	if pos+int64(len(chunk)) > lr.end {
+		// The last line is the one containing end-1.
+		k := lr.end - 1 - pos
+		if i := bytes.IndexByte(chunk[k:], '\n'); i >= 0 {
+			if err := lr.fn(chunk[:k+int64(i)+1]); err != nil {
+				return err
+			}
+			return errRangeDone
+		}
+	}
+	if len(chunk) == 0 {
+		return nil
+	}
+	return lr.fn(chunk)
+}
+
+// ReadRange implements RangeReader.
+func (r BufferedReader) ReadRange(root *os.Root, name string, offset, length int64, fn func(chunk []byte) error) error {
+	file, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lr := newLineRange(offset, length, fn)
+	if _, err := file.Seek(lr.from(), io.SeekStart); err != nil {
+		return err
+	}
+	err = r.readPlain(file, lr.chunk)
+	if errors.Is(err, errRangeDone) {
+		return nil
+	}
+	return err
+}
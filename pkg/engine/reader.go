@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Reader is a file reading backend. Implementations hand out the file in
+// newline-aligned chunks so that lines can be parsed in place without
+// copying them.
+type Reader interface {
+	// Name identifies the backend in reports.
+	Name() string
+	// ReadChunks calls fn with consecutive chunks of the file that end on a
+	// line boundary (except possibly the last one). A chunk is only valid
+	// until fn returns.
+	ReadChunks(root *os.Root, name string, fn func(chunk []byte) error) error
+}
+
+// Reader backend names accepted by NewReader.
+const (
+	ReaderBuffered = "buffered"
+	ReaderMmap     = "mmap"
+)
+
+// DefaultChunkSize is the chunk size used when a Reader is created with zero.
+const DefaultChunkSize = 256 * 1024
+
+// NewReader returns the backend with the given name. A chunkSize of zero
+// means DefaultChunkSize.
+func NewReader(name string, chunkSize int) (Reader, error) {
+	switch name {
+	case ReaderBuffered:
+		return BufferedReader{ChunkSize: chunkSize}, nil
+	case ReaderMmap:
+		return MmapReader{ChunkSize: chunkSize}, nil
+	default:
+		return nil, fmt.Errorf("unknown reader %q (want %s or %s)", name, ReaderBuffered, ReaderMmap)
+	}
+}
+
+// BufferedReader reads files through a buffer of ChunkSize bytes, the same
+// way phase4 does with bufio.Reader. Gzip-compressed files are decompressed
+// transparently.
+type BufferedReader struct {
+	ChunkSize int
+}
+
+// Name implements Reader.
+func (BufferedReader) Name() string { return ReaderBuffered }
+
+// ReadChunks implements Reader.
+func (r BufferedReader) ReadChunks(root *os.Root, name string, fn func(chunk []byte) error) error {
+	file, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return r.readFrom(file, fn)
+}
+
+func (r BufferedReader) readFrom(file io.Reader, fn func(chunk []byte) error) error {
+	src, err := decompress(file)
+	if err != nil {
+		return err
+	}
+	return r.readPlain(src, fn)
+}
+
+// readPlain is readFrom without decompression.
+func (r BufferedReader) readPlain(src io.Reader, fn func(chunk []byte) error) error {
+	size := r.ChunkSize
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	buf := make([]byte, size)
+	filled := 0
+	for {
+		n, err := src.Read(buf[filled:])
+		filled += n
+
+		if err == io.EOF {
+			if filled > 0 {
+				return fn(buf[:filled])
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if filled < len(buf) {
+			continue
+		}
+
+		end := bytes.LastIndexByte(buf, '\n')
+		if end < 0 {
+			// A single line longer than the buffer: grow it.
+			buf = append(buf, make([]byte, len(buf))...)
+			continue
+		}
+		if err := fn(buf[:end+1]); err != nil {
+			return err
+		}
+		filled = copy(buf, buf[end+1:])
+	}
+}
+
+// decompress wraps r with a gzip reader if it starts with the gzip magic.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !isGzip(header) {
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip stream: %w", err)
+	}
+	return zr, nil
+}
+
+func isGzip(header []byte) bool {
+	return len(header) >= 2 && header[0] == 0x1f && header[1] == 0x8b
+}
+
+// Lines calls fn for every non-empty line of a chunk, without the newline.
+func Lines(chunk []byte, fn func(line []byte)) {
+	for len(chunk) > 0 {
+		i := bytes.IndexByte(chunk, '\n')
+		if i < 0 {
+			fn(chunk)
+			return
+		}
+		if i > 0 {
+			fn(chunk[:i])
+		}
+		chunk = chunk[i+1:]
+	}
+}
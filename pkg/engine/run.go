@@ -0,0 +1,406 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/pool"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/progress"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/sched"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+)
+
+// Schedules accepted in Options.Schedule.
+const (
+	// ScheduleFIFO dispatches whole files in listing order through a shared
+	// channel, like phase3.
+	ScheduleFIFO = "fifo"
+	// ScheduleLargest dispatches whole files largest first, so that a large
+	// file is never the last one to start.
+	ScheduleLargest = "largest"
+	// ScheduleSteal splits uncompressed files into ranges of TaskSize bytes,
+	// deals the files largest first to per-worker deques and lets idle
+	// workers steal ranges from the others.
+	ScheduleSteal = "steal"
+)
+
+// DefaultTaskSize is the size of the file ranges stolen by ScheduleSteal.
+const DefaultTaskSize = 4 * 1024 * 1024
+
+// Options configures Run.
+type Options struct {
+	// Workers is the number of files processed concurrently.
+	// Zero means runtime.GOMAXPROCS(0).
+	Workers int
+	// Reader is the file reading backend. Nil means BufferedReader.
+	Reader Reader
+	// Adaptive, if set, replaces the fixed pool of Workers with a pool that
+	// resizes itself within the configured bounds while running. It cannot
+	// be combined with ScheduleSteal.
+	Adaptive *pool.Config
+	// Schedule decides the order and granularity of the work. Empty means
+	// ScheduleFIFO.
+	Schedule string
+	// TaskSize is the range size used by ScheduleSteal. Zero means
+	// DefaultTaskSize.
+	TaskSize int64
+	// Progress, if set, receives the files, bytes and lines processed.
+	Progress *progress.Counters
+	// Checkpoint, if set, makes Execute save its progress periodically and
+	// optionally resume from an earlier run.
+	Checkpoint *CheckpointOptions
+	// Cache, if set, provides the results of files unchanged since an
+	// earlier run and receives the results of the others.
+	Cache *Cache
+}
+
+func (o Options) withDefaults() Options {
+	if o.Workers <= 0 {
+		o.Workers = runtime.GOMAXPROCS(0)
+	}
+	if o.Reader == nil {
+		o.Reader = BufferedReader{}
+	}
+	if o.Schedule == "" {
+		o.Schedule = ScheduleFIFO
+	}
+	if o.TaskSize <= 0 {
+		o.TaskSize = DefaultTaskSize
+	}
+	return o
+}
+
+// Validate reports whether the options can be run.
+func (o Options) Validate() error {
+	switch o.Schedule {
+	case "", ScheduleFIFO, ScheduleLargest:
+	case ScheduleSteal:
+		if o.Adaptive != nil {
+			return fmt.Errorf("schedule %q cannot be combined with an adaptive pool", ScheduleSteal)
+		}
+	default:
+		return fmt.Errorf("unknown schedule %q (want %s, %s or %s)",
+			o.Schedule, ScheduleFIFO, ScheduleLargest, ScheduleSteal)
+	}
+	return nil
+}
+
+// Report is the outcome of Execute.
+type Report struct {
+	Results  []*logparser.Result
+	Schedule sched.Stats // zero with opts.Adaptive
+	// Resumed is the aggregate of the files skipped because a resumed
+	// checkpoint had completed them. It is nil without opts.Checkpoint.
+	Resumed *logparser.TotalResult
+	// Cached is the number of Results taken from opts.Cache.
+	Cached int
+}
+
+// Total merges the results of the run with the resumed aggregate.
+func (r *Report) Total() *logparser.TotalResult {
+	total := logparser.MergeResults(r.Results)
+	if r.Resumed != nil {
+		total.Merge(r.Resumed)
+	}
+	return total
+}
+
+// Run counts the status codes of every file with a worker pool, like phase3,
+// and returns one Result per successfully processed file. Files that fail
+// are reported in the returned error. Files skipped by resuming a
+// checkpoint are not returned; use Execute and Report.Total to include them.
+//
+// With opts.Adaptive the pool is sized by measuring throughput and I/O wait
+// per chunk instead of being fixed at startup.
+func Run(root *os.Root, files []string, opts Options) ([]*logparser.Result, error) {
+	report, err := Execute(root, files, opts)
+	if report == nil {
+		return nil, err
+	}
+	return report.Results, err
+}
+
+// Execute is Run also reporting how the work was scheduled, saving or
+// resuming a checkpoint and reusing cached results if configured. Cached
+// results are returned in Report.Results like the others.
+func Execute(root *os.Root, files []string, opts Options) (*Report, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	opts = opts.withDefaults()
+
+	report := &Report{}
+	var checkpoint *Checkpoint
+	var fingerprints map[string]Fingerprint
+	if opts.Checkpoint != nil {
+		fingerprints = make(map[string]Fingerprint, len(files))
+		for _, name := range files {
+			fp, err := FileFingerprint(root, name)
+			if err != nil {
+				return nil, err
+			}
+			fingerprints[name] = fp
+		}
+		var err error
+		checkpoint, files, err = openCheckpoint(opts.Checkpoint, files, fingerprints)
+		if err != nil {
+			return nil, err
+		}
+		report.Resumed = checkpoint.clone().Total
+
+		var skippedBytes int64
+		for _, fp := range checkpoint.Files {
+			skippedBytes += fp.Size
+		}
+		opts.Progress.Skip(int64(len(checkpoint.Files)), skippedBytes)
+	}
+
+	byFile := make(map[string]*logparser.Result, len(files))
+	pending := files
+	var keys map[string]CacheKey
+	if opts.Cache != nil {
+		opts.Cache.use(Aggregator)
+		if checkpoint != nil {
+			for name := range checkpoint.Files {
+				opts.Cache.keep(name)
+			}
+		}
+		keys = make(map[string]CacheKey, len(files))
+		pending = nil
+		var cachedBytes int64
+		for _, name := range files {
+			key, err := FileCacheKey(root, name)
+			if err != nil {
+				return nil, err
+			}
+			keys[name] = key
+			result, ok := opts.Cache.lookup(name, key)
+			if !ok {
+				pending = append(pending, name)
+				continue
+			}
+			byFile[name] = result
+			report.Cached++
+			cachedBytes += key.Size
+			if checkpoint != nil {
+				checkpoint.add(name, fingerprints[name], result)
+			}
+		}
+		opts.Progress.Skip(int64(report.Cached), cachedBytes)
+	}
+
+	tasks, err := planTasks(root, pending, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var errs []error
+	failed := make(map[string]bool)
+	remaining := make(map[string]int, len(files))
+	for _, task := range tasks {
+		remaining[task.File]++
+	}
+
+	process := func(task Task, meter *pool.Meter) {
+		opts.Progress.WorkerBusy()
+		result, err := countTask(opts.Reader, root, task, meter, opts.Progress)
+		opts.Progress.WorkerIdle()
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", task, err))
+			failed[task.File] = true
+		case byFile[task.File] == nil:
+			byFile[task.File] = result
+		default:
+			byFile[task.File].Merge(result)
+		}
+		if remaining[task.File]--; remaining[task.File] == 0 {
+			opts.Progress.AddFiles(1)
+			if !failed[task.File] {
+				if checkpoint != nil {
+					checkpoint.add(task.File, fingerprints[task.File], byFile[task.File])
+				}
+				if opts.Cache != nil {
+					opts.Cache.store(task.File, keys[task.File], byFile[task.File])
+				}
+			}
+		}
+	}
+
+	stopSaving := func() error { return nil }
+	if checkpoint != nil {
+		stopSaving = saveEvery(opts.Checkpoint, func() *Checkpoint {
+			mu.Lock()
+			defer mu.Unlock()
+			return checkpoint.clone()
+		})
+	}
+
+	switch {
+	case opts.Adaptive != nil:
+		jobs := make(chan Task, opts.Workers)
+		workers := pool.Start(jobs, *opts.Adaptive, process)
+		for _, task := range tasks {
+			jobs <- task
+		}
+		close(jobs)
+		workers.Wait()
+	case opts.Schedule == ScheduleSteal:
+		queues := dealTasks(tasks, opts.Workers)
+		report.Schedule = sched.Steal(queues, func(_ int, task Task) { process(task, nil) })
+	default:
+		report.Schedule = sched.FIFO(tasks, opts.Workers, func(_ int, task Task) { process(task, nil) })
+	}
+
+	if err := stopSaving(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Keep the order of files so that reports do not depend on scheduling.
+	report.Results = make([]*logparser.Result, 0, len(files))
+	for _, filename := range files {
+		if result, ok := byFile[filename]; ok && !failed[filename] {
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// saveEvery saves the checkpoint returned by snapshot every opts.Interval.
+// The returned function stops saving, saves a last time and reports the
+// first error encountered.
+func saveEvery(opts *CheckpointOptions, snapshot func() *Checkpoint) (stop func() error) {
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	done := make(chan struct{})
+	var saveErr error
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := snapshot().Save(opts.Path); err != nil && saveErr == nil {
+					saveErr = err
+				}
+			}
+		}
+	})
+
+	return func() error {
+		close(done)
+		wg.Wait()
+		if err := snapshot().Save(opts.Path); err != nil && saveErr == nil {
+			saveErr = err
+		}
+		if saveErr != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", saveErr)
+		}
+		return nil
+	}
+}
+
+// CountFile counts the status codes of one file. Lines are parsed in place
+// with logparser.ParseStatus; lines without a status are skipped.
+func CountFile(reader Reader, root *os.Root, filename string) (*logparser.Result, error) {
+	return countFile(reader, root, filename, nil)
+}
+
+// CountTask is CountFile for a task planned by PlanTasks, which may cover
+// only part of the file.
+func CountTask(reader Reader, root *os.Root, task Task) (*logparser.Result, error) {
+	return countTask(reader, root, task, nil, nil)
+}
+
+// countFile is CountFile reporting to meter, if not nil, how long it waited
+// for each chunk and how long it spent parsing it.
+func countFile(reader Reader, root *os.Root, filename string, meter *pool.Meter) (*logparser.Result, error) {
+	return countTask(reader, root, Task{File: filename, Length: -1}, meter, nil)
+}
+
+// countTask is countFile for a task, which may cover only part of the file,
+// also reporting every chunk to counters if not nil.
+func countTask(reader Reader, root *os.Root, task Task, meter *pool.Meter, counters *progress.Counters) (*logparser.Result, error) {
+	if IsSegment(task.File) {
+		return countSegment(root, task.File, meter, counters)
+	}
+
+	result := logparser.NewResult(task.File)
+	last := time.Now()
+	err := task.read(reader, root, func(chunk []byte) error {
+		var start time.Time
+		if meter != nil {
+			start = time.Now()
+		}
+
+		counted := result.TotalCount
+		Lines(chunk, func(line []byte) {
+			if status, err := logparser.ParseStatus(line); err == nil {
+				result.AddStatus(status)
+			}
+		})
+		counters.AddBytes(int64(len(chunk)))
+		counters.AddLines(int64(result.TotalCount - counted))
+
+		if meter != nil {
+			done := time.Now()
+			meter.Add(int64(len(chunk)), start.Sub(last), done.Sub(start))
+			last = done
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// countSegment counts a segment from its status column alone, which it
+// reads at once. Status 0 stands for entries without a status, which
+// countTask skips in JSON files.
+func countSegment(root *os.Root, filename string, meter *pool.Meter, counters *progress.Counters) (*logparser.Result, error) {
+	start := time.Now()
+	file, err := segment.Open(root, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	batch, err := file.Read(segment.Of(segment.Status))
+	if err != nil {
+		return nil, err
+	}
+	read := time.Now()
+
+	result := logparser.NewResult(filename)
+	for _, status := range batch.Statuses {
+		if status != 0 {
+			result.AddStatus(status)
+		}
+	}
+	// Progress is measured against the size of the files.
+	info, err := root.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	counters.AddBytes(info.Size())
+	counters.AddLines(int64(result.TotalCount))
+	if meter != nil {
+		meter.Add(info.Size(), read.Sub(start), time.Since(read))
+	}
+	return result, nil
+}
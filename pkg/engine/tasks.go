@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Task is a unit of scheduled work: the lines of File starting in
+// [Offset, Offset+Length), or the whole file if Length is negative.
+type Task struct {
+	File           string
+	Offset, Length int64
+
+	size int64 // bytes to read, for dealing tasks to workers
+}
+
+func (t Task) String() string {
+	if t.Length < 0 {
+		return t.File
+	}
+	return fmt.Sprintf("%s[%d:%d]", t.File, t.Offset, t.Offset+t.Length)
+}
+
+func (t Task) read(reader Reader, root *os.Root, fn func(chunk []byte) error) error {
+	if t.Length < 0 {
+		return reader.ReadChunks(root, t.File, fn)
+	}
+	return reader.(RangeReader).ReadRange(root, t.File, t.Offset, t.Length, fn)
+}
+
+// fileSize is a file to be scheduled.
+type fileSize struct {
+	name  string
+	size  int64
+	split bool // whether the file can be read in ranges
+}
+
+// PlanTasks lists the tasks Execute would schedule for files with opts, so
+// that they can be handed out to other processes.
+func PlanTasks(root *os.Root, files []string, opts Options) ([]Task, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return planTasks(root, files, opts.withDefaults())
+}
+
+// planTasks lists the tasks for files in the order of opts.Schedule. With
+// ScheduleSteal, the ranges of a file are consecutive.
+func planTasks(root *os.Root, files []string, opts Options) ([]Task, error) {
+	if opts.Schedule == ScheduleFIFO {
+		tasks := make([]Task, len(files))
+		for i, name := range files {
+			tasks[i] = Task{File: name, Length: -1}
+		}
+		return tasks, nil
+	}
+
+	sizes, err := statFiles(root, files)
+	if err != nil {
+		return nil, err
+	}
+	// Largest first; the stable sort keeps the listing order among equals.
+	slices.SortStableFunc(sizes, func(a, b fileSize) int { return cmp.Compare(b.size, a.size) })
+
+	_, canSplit := opts.Reader.(RangeReader)
+	var tasks []Task
+	for _, f := range sizes {
+		if opts.Schedule != ScheduleSteal || !canSplit || !f.split || f.size <= opts.TaskSize {
+			tasks = append(tasks, Task{File: f.name, Length: -1, size: f.size})
+			continue
+		}
+		for offset := int64(0); offset < f.size; offset += opts.TaskSize {
+			length := min(opts.TaskSize, f.size-offset)
+			tasks = append(tasks, Task{File: f.name, Offset: offset, Length: length, size: length})
+		}
+	}
+	return tasks, nil
+}
+
+// statFiles returns the size of every file, using the fs.FileInfo of the
+// open file, and whether it can be split (it is neither gzip-compressed nor
+// a segment).
+func statFiles(root *os.Root, files []string) ([]fileSize, error) {
+	sizes := make([]fileSize, len(files))
+	header := make([]byte, 2)
+	for i, name := range files {
+		file, err := root.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		n, _ := file.ReadAt(header, 0)
+		file.Close()
+
+		sizes[i] = fileSize{
+			name:  name,
+			size:  info.Size(),
+			split: info.Mode().IsRegular() && !isGzip(header[:n]) && !IsSegment(name),
+		}
+	}
+	return sizes, nil
+}
+
+// TotalSize returns the combined size of files on disk. Compressed files
+// count with their compressed size.
+func TotalSize(root *os.Root, files []string) (int64, error) {
+	sizes, err := statFiles(root, files)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, f := range sizes {
+		total += f.size
+	}
+	return total, nil
+}
+
+// dealTasks distributes tasks, largest files first, to the worker with the
+// fewest bytes so far. All ranges of a file go to the same worker so that
+// it reads the file sequentially unless another worker steals from it.
+// Each queue is returned in reverse processing order, as sched.Steal pops
+// from the back.
+func dealTasks(tasks []Task, numWorkers int) [][]Task {
+	queues := make([][]Task, numWorkers)
+	load := make([]int64, numWorkers)
+	for i := 0; i < len(tasks); {
+		// The tasks of one file are consecutive.
+		j := i + 1
+		for j < len(tasks) && tasks[j].File == tasks[i].File {
+			j++
+		}
+		var size int64
+		for _, t := range tasks[i:j] {
+			size += t.size
+		}
+
+		w := 0
+		for k := range load {
+			if load[k] < load[w] {
+				w = k
+			}
+		}
+		queues[w] = append(queues[w], tasks[i:j]...)
+		load[w] += size
+		i = j
+	}
+	for _, q := range queues {
+		slices.Reverse(q)
+	}
+	return queues
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/segment"
+)
+
+// TimeRange is the period [From, To). A zero From or To leaves that side
+// open, and the zero TimeRange covers all times.
+type TimeRange struct {
+	From, To time.Time
+}
+
+// IsZero reports whether r covers all times.
+func (r TimeRange) IsZero() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// containsNano reports whether the Unix nanoseconds ns fall in r.
+func (r TimeRange) containsNano(ns int64) bool {
+	return (r.From.IsZero() || ns >= r.From.UnixNano()) && (r.To.IsZero() || ns < r.To.UnixNano())
+}
+
+// overlaps reports whether r overlaps the closed period [lo, hi] of Unix
+// nanoseconds.
+func (r TimeRange) overlaps(lo, hi int64) bool {
+	return (r.From.IsZero() || hi >= r.From.UnixNano()) && (r.To.IsZero() || lo < r.To.UnixNano())
+}
+
+// ScanStats reports how much of the logs a scan read.
+type ScanStats struct {
+	Files   int
+	Indexed int // files whose index or segment header was used
+	Skipped int // files not read at all
+	Size    int64
+	Read    int64 // bytes read
+}
+
+// Add adds the stats of another scan.
+func (s *ScanStats) Add(other ScanStats) {
+	s.Files += other.Files
+	s.Indexed += other.Indexed
+	s.Skipped += other.Skipped
+	s.Size += other.Size
+	s.Read += other.Read
+}
+
+// ScanRange is ScanColumns restricted to the entries with a timestamp in r.
+// Entries without a valid timestamp are skipped, unless r is zero.
+//
+// A segment is skipped if the time span in its header does not overlap r.
+// A JSON file with an up-to-date index, built by BuildIndex and saved by
+// SaveIndex, is skipped in the same way or read only in the byte ranges of
+// its overlapping blocks. Without an index, or with a stale one, the whole
+// file is read.
+func ScanRange(root *os.Root, filename string, columns segment.Columns, r TimeRange, fn func(*logparser.LogEntry)) (ScanStats, error) {
+	info, err := root.Stat(filename)
+	if err != nil {
+		return ScanStats{}, err
+	}
+	stats := ScanStats{Files: 1, Size: info.Size(), Read: info.Size()}
+	if r.IsZero() {
+		return stats, ScanColumns(root, filename, columns, fn)
+	}
+	if IsSegment(filename) {
+		return scanSegmentRange(root, filename, columns, r, stats, fn)
+	}
+
+	filter := func(entry *logparser.LogEntry) {
+		if t, err := time.Parse(time.RFC3339Nano, entry.Timestamp); err == nil && r.containsNano(t.UnixNano()) {
+			fn(entry)
+		}
+	}
+	ix, err := LoadIndex(root, filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, ErrStaleIndex) {
+			return stats, ScanColumns(root, filename, columns, filter)
+		}
+		return stats, err
+	}
+
+	stats.Indexed = 1
+	ranges := ix.Ranges(r)
+	stats.Read = 0
+	for _, b := range ranges {
+		stats.Read += b.Length
+	}
+	switch {
+	case len(ranges) == 0:
+		stats.Skipped = 1
+		return stats, nil
+	case !ix.Seekable:
+		return stats, ScanColumns(root, filename, columns, filter)
+	}
+	var entry logparser.LogEntry
+	for _, b := range ranges {
+		err := BufferedReader{}.ReadRange(root, filename, b.Offset, b.Length, func(chunk []byte) error {
+			Lines(chunk, func(line []byte) {
+				entry = logparser.LogEntry{}
+				if json.Unmarshal(line, &entry) == nil {
+					filter(&entry)
+				}
+			})
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func scanSegmentRange(root *os.Root, filename string, columns segment.Columns, r TimeRange, stats ScanStats, fn func(*logparser.LogEntry)) (ScanStats, error) {
+	file, err := segment.Open(root, filename)
+	if err != nil {
+		return stats, err
+	}
+	defer file.Close()
+
+	stats.Indexed = 1
+	info, _ := file.Header.Column(segment.Timestamp)
+	if file.Header.Rows == 0 || !r.overlaps(info.Min, info.Max) {
+		stats.Skipped, stats.Read = 1, 0
+		return stats, nil
+	}
+
+	columns |= segment.Of(segment.Timestamp)
+	stats.Read = file.Size(columns)
+	batch, err := file.Read(columns)
+	if err != nil {
+		return stats, err
+	}
+	var entry logparser.LogEntry
+	for i := range batch.Rows {
+		if ns, ok := batch.UnixNano(i); ok && r.containsNano(ns) {
+			batch.Entry(i, &entry)
+			fn(&entry)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,274 @@
+// Package extsort sorts more records than fit in memory: Sorters collect
+// records up to a memory budget and spill them as sorted runs to temporary
+// files, and Merge combines all the runs with a k-way heap merge.
+//
+// Records are opaque bytes ordered by an int64 key, then by a sequence
+// number that makes the order total, so that the output does not depend on
+// how records were distributed among Sorters. Each goroutine uses its own
+// Sorter and sorts its runs in parallel with the others; the merge is
+// sequential.
+package extsort
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+)
+
+// Config configures the Sorters and Merge.
+type Config struct {
+	// MemoryLimit is the number of bytes of records a Sorter holds before
+	// spilling them. Zero means 64 MiB.
+	MemoryLimit int64
+	// TempDir is the directory of the spilled runs. Empty means
+	// os.TempDir().
+	TempDir string
+	// FanIn is the largest number of spilled runs merged at once. Merge
+	// first combines runs into larger ones until there are no more. Zero
+	// means 64.
+	FanIn int
+}
+
+func (c Config) withDefaults() Config {
+	if c.MemoryLimit <= 0 {
+		c.MemoryLimit = 64 << 20
+	}
+	if c.TempDir == "" {
+		c.TempDir = os.TempDir()
+	}
+	if c.FanIn < 2 {
+		c.FanIn = 64
+	}
+	return c
+}
+
+// recordOverhead approximates the memory a record takes besides its data.
+const recordOverhead = 32
+
+// record is a record held in memory. Its data is in the arena of its
+// Sorter or run.
+type record struct {
+	key       int64
+	seq       uint64
+	off, size int
+}
+
+func compareRecords(a, b record) int {
+	return cmp.Or(cmp.Compare(a.key, b.key), cmp.Compare(a.seq, b.seq))
+}
+
+// Sorter collects records and spills them as sorted runs. It is not safe
+// for concurrent use.
+type Sorter struct {
+	cfg     Config
+	arena   []byte
+	records []record
+	runs    []*Run
+
+	// Spilled counts the runs written to disk and their bytes.
+	Spilled      int
+	SpilledBytes int64
+}
+
+// NewSorter creates a Sorter.
+func NewSorter(cfg Config) *Sorter {
+	return &Sorter{cfg: cfg.withDefaults()}
+}
+
+// Add records data, which is copied, under key and seq.
+func (s *Sorter) Add(key int64, seq uint64, data []byte) error {
+	s.records = append(s.records, record{key: key, seq: seq, off: len(s.arena), size: len(data)})
+	s.arena = append(s.arena, data...)
+	if int64(len(s.arena)+recordOverhead*len(s.records)) >= s.cfg.MemoryLimit {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill writes the records in memory as a sorted run.
+func (s *Sorter) spill() error {
+	slices.SortFunc(s.records, compareRecords)
+	w, err := newRunWriter(s.cfg.TempDir)
+	if err != nil {
+		return err
+	}
+	for _, r := range s.records {
+		w.write(r.key, r.seq, s.arena[r.off:r.off+r.size])
+	}
+	run, err := w.finish()
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run)
+	s.Spilled++
+	s.SpilledBytes += run.size
+	s.arena = s.arena[:0]
+	s.records = s.records[:0]
+	return nil
+}
+
+// Finish returns the runs of the Sorter. The records still in memory are
+// sorted and returned as a last run that stays in memory.
+func (s *Sorter) Finish() []*Run {
+	runs := s.runs
+	if len(s.records) > 0 {
+		slices.SortFunc(s.records, compareRecords)
+		runs = append(runs, &Run{arena: s.arena, records: s.records})
+	}
+	s.runs, s.arena, s.records = nil, nil, nil
+	return runs
+}
+
+// Run is a sorted sequence of records, in memory or in a temporary file.
+type Run struct {
+	// In memory.
+	arena   []byte
+	records []record
+
+	// On disk.
+	path string
+	size int64
+}
+
+// Remove deletes the file of a spilled run, if it still exists.
+func (r *Run) Remove() error {
+	if r.path == "" {
+		return nil
+	}
+	if err := os.Remove(r.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// RemoveAll deletes the files of runs, for example when giving up before
+// Merge.
+func RemoveAll(runs []*Run) error {
+	var errs []error
+	for _, r := range runs {
+		errs = append(errs, r.Remove())
+	}
+	return errors.Join(errs...)
+}
+
+// runWriter writes a run to a temporary file. A record is
+//
+//	key (varint) seq (uvarint) length (uvarint) data
+type runWriter struct {
+	file *os.File
+	w    *bufio.Writer
+	buf  []byte
+	size int64
+}
+
+func newRunWriter(dir string) (*runWriter, error) {
+	file, err := os.CreateTemp(dir, "extsort-*.run")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run: %w", err)
+	}
+	return &runWriter{file: file, w: bufio.NewWriterSize(file, 256*1024)}, nil
+}
+
+func (w *runWriter) write(key int64, seq uint64, data []byte) {
+	w.buf = binary.AppendVarint(w.buf[:0], key)
+	w.buf = binary.AppendUvarint(w.buf, seq)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(data)))
+	// Errors are sticky in bufio.Writer and reported by finish.
+	w.w.Write(w.buf)
+	w.w.Write(data)
+	w.size += int64(len(w.buf) + len(data))
+}
+
+func (w *runWriter) finish() (*Run, error) {
+	err := w.w.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(w.file.Name())
+		return nil, fmt.Errorf("failed to write run: %w", err)
+	}
+	return &Run{path: w.file.Name(), size: w.size}, nil
+}
+
+// cursor reads the records of a run in order.
+type cursor struct {
+	run  *Run
+	file *os.File
+	r    *bufio.Reader
+	next int // next record of an in-memory run
+
+	key  int64
+	seq  uint64
+	data []byte // valid until the next call to advance
+}
+
+func openCursor(run *Run) (*cursor, error) {
+	c := &cursor{run: run}
+	if run.path != "" {
+		file, err := os.Open(run.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open run: %w", err)
+		}
+		c.file = file
+		c.r = bufio.NewReaderSize(file, 64*1024)
+	}
+	return c, nil
+}
+
+// advance moves to the next record. It returns io.EOF after the last one.
+func (c *cursor) advance() error {
+	if c.file == nil {
+		if c.next == len(c.run.records) {
+			return io.EOF
+		}
+		r := c.run.records[c.next]
+		c.next++
+		c.key, c.seq, c.data = r.key, r.seq, c.run.arena[r.off:r.off+r.size]
+		return nil
+	}
+
+	key, err := binary.ReadVarint(c.r)
+	if err != nil {
+		return err // io.EOF between records
+	}
+	seq, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return corrupt(err)
+	}
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return corrupt(err)
+	}
+	if size > uint64(c.run.size) {
+		return errors.New("corrupt run: record larger than the run")
+	}
+	if uint64(cap(c.data)) < size {
+		c.data = make([]byte, size)
+	}
+	c.data = c.data[:size]
+	if _, err := io.ReadFull(c.r, c.data); err != nil {
+		return corrupt(err)
+	}
+	c.key, c.seq = key, seq
+	return nil
+}
+
+func corrupt(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("corrupt run: %w", err)
+}
+
+func (c *cursor) close() {
+	if c.file != nil {
+		c.file.Close()
+	}
+}
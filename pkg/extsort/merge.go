@@ -0,0 +1,116 @@
+package extsort
+
+import (
+	"container/heap"
+	"errors"
+	"io"
+)
+
+// Merge calls fn with the records of all runs in order of key, then of
+// sequence number. data is only valid until fn returns. Merge stops at the
+// first error of fn. The files of the runs are removed in any case.
+//
+// If there are more than cfg.FanIn spilled runs, groups of them are first
+// merged into larger runs, so that no more than FanIn files are open at
+// once. Runs in memory take part in the last merge only.
+func Merge(runs []*Run, cfg Config, fn func(key int64, data []byte) error) (err error) {
+	cfg = cfg.withDefaults()
+	var disk, memory []*Run
+	for _, r := range runs {
+		if r.path != "" {
+			disk = append(disk, r)
+		} else {
+			memory = append(memory, r)
+		}
+	}
+	defer func() {
+		err = errors.Join(err, RemoveAll(disk))
+	}()
+
+	for len(disk) > cfg.FanIn {
+		group := disk[:cfg.FanIn]
+		w, err := newRunWriter(cfg.TempDir)
+		if err != nil {
+			return err
+		}
+		err = merge(group, func(c *cursor) error {
+			w.write(c.key, c.seq, c.data)
+			return nil
+		})
+		run, ferr := w.finish()
+		if err = errors.Join(err, ferr); err != nil {
+			if run != nil {
+				run.Remove()
+			}
+			return err
+		}
+		if err := RemoveAll(group); err != nil {
+			return err
+		}
+		disk = append(disk[cfg.FanIn:], run)
+	}
+	return merge(append(memory, disk...), func(c *cursor) error {
+		return fn(c.key, c.data)
+	})
+}
+
+// merge calls emit with the cursor of the next record of runs until all
+// are exhausted.
+func merge(runs []*Run, emit func(*cursor) error) error {
+	h := &mergeHeap{}
+	defer func() {
+		for _, c := range h.cursors {
+			c.close()
+		}
+	}()
+	for _, run := range runs {
+		c, err := openCursor(run)
+		if err != nil {
+			return err
+		}
+		if err := c.advance(); err != nil {
+			c.close()
+			if err == io.EOF {
+				continue
+			}
+			return err
+		}
+		h.cursors = append(h.cursors, c)
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		c := h.cursors[0]
+		if err := emit(c); err != nil {
+			return err
+		}
+		switch err := c.advance(); err {
+		case nil:
+			heap.Fix(h, 0)
+		case io.EOF:
+			c.close()
+			heap.Pop(h)
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeHeap orders the cursors by their current record.
+type mergeHeap struct {
+	cursors []*cursor
+}
+
+func (h *mergeHeap) Len() int { return len(h.cursors) }
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	return a.key < b.key || (a.key == b.key && a.seq < b.seq)
+}
+func (h *mergeHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *mergeHeap) Push(x any)    { h.cursors = append(h.cursors, x.(*cursor)) }
+func (h *mergeHeap) Pop() any {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
@@ -0,0 +1,319 @@
+// Package inflight reconstructs how many requests were in flight at once
+// from the start timestamp and response time of every log entry.
+//
+// Every request is an interval [start, start+response time) and becomes
+// two events, +1 at its start and -1 at its end. Sweeping the events in
+// time order gives the number of requests in flight at any instant, overall
+// and per path template. Workers collect and sort the events of their own
+// files, and Sweep merges the sorted streams as it goes, so the events are
+// never sorted as a whole.
+//
+// Percentiles are time-weighted: the p99 is the concurrency that was not
+// exceeded during 99% of the period, which is what a server pool has to
+// hold without queueing, unlike a percentile over requests.
+package inflight
+
+import (
+	"cmp"
+	"container/heap"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// event is the start (+1) or the end (-1) of a request.
+type event struct {
+	at    int64 // Unix milliseconds
+	path  int32 // index in Events.paths
+	delta int32
+}
+
+// compare orders events by time, ends first, so that a request ending
+// when another one starts does not overlap it.
+func (e event) compare(o event) int {
+	return cmp.Or(cmp.Compare(e.at, o.at), cmp.Compare(e.delta, o.delta))
+}
+
+// Events collects the events of a set of log entries, such as the files of
+// one worker.
+type Events struct {
+	paths  []string // path templates
+	index  map[string]int32
+	events []event
+	sorted bool
+}
+
+// NewEvents creates an empty Events.
+func NewEvents() *Events {
+	return &Events{index: make(map[string]int32)}
+}
+
+// Add records the interval of a request. Requests without a response time
+// were never in flight and are ignored.
+func (e *Events) Add(entry *logparser.LogEntry) error {
+	t, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	if entry.ResponseTimeMs <= 0 {
+		return nil
+	}
+	template := logparser.PathTemplate(entry.Path)
+	path, ok := e.index[template]
+	if !ok {
+		path = int32(len(e.paths))
+		e.paths = append(e.paths, template)
+		e.index[template] = path
+	}
+	start := t.UnixMilli()
+	e.events = append(e.events,
+		event{at: start, path: path, delta: 1},
+		event{at: start + int64(entry.ResponseTimeMs), path: path, delta: -1})
+	e.sorted = false
+	return nil
+}
+
+// Sort sorts the events in time order. Sweep sorts unsorted streams, but
+// calling Sort from the worker that filled e spreads the work.
+func (e *Events) Sort() {
+	if !e.sorted {
+		slices.SortFunc(e.events, event.compare)
+		e.sorted = true
+	}
+}
+
+// Config tunes Sweep.
+type Config struct {
+	// Quantiles are the time-weighted percentiles to report, as fractions.
+	// Nil means 0.5, 0.9, 0.99 and 0.999.
+	Quantiles []float64
+	// Interval, if positive, reports the overall peak of every interval of
+	// this length. Of a run of intervals without events, only the first is
+	// reported: the requests carried over stay in flight until the next
+	// reported interval.
+	Interval time.Duration
+}
+
+func (c Config) withDefaults() Config {
+	if c.Quantiles == nil {
+		c.Quantiles = []float64{0.5, 0.9, 0.99, 0.999}
+	}
+	return c
+}
+
+// Percentile is a time-weighted percentile of the concurrency.
+type Percentile struct {
+	Quantile float64
+	Value    int
+}
+
+// Stats describe the concurrency of all requests or of one path template
+// over the period of the logs.
+type Stats struct {
+	Path        string // empty for all requests
+	Requests    int
+	Mean        float64
+	Percentiles []Percentile
+	Max         int
+	MaxAt       time.Time // first time Max was reached
+}
+
+// Peak is the highest concurrency within an interval.
+type Peak struct {
+	Start time.Time
+	Max   int
+	At    time.Time // first time Max was reached; the start if carried over
+}
+
+// Report is the result of Sweep.
+type Report struct {
+	// Start and End are the first start and last end of a request.
+	Start, End time.Time
+	Overall    Stats
+	Paths      []Stats // by decreasing Max, then path
+	Intervals  []Peak  // if Config.Interval is positive
+}
+
+// level tracks the concurrency of one path, or of all requests, and how
+// long every level lasted.
+type level struct {
+	requests int
+	current  int
+	since    int64   // time of the last change
+	duration []int64 // milliseconds spent at each level
+	max      int
+	maxAt    int64
+}
+
+func (l *level) change(at int64, delta int32) {
+	if len(l.duration) <= l.current {
+		l.duration = append(l.duration, make([]int64, l.current+1-len(l.duration))...)
+	}
+	l.duration[l.current] += at - l.since
+	l.since = at
+	l.current += int(delta)
+	if delta > 0 {
+		l.requests++
+		if l.current > l.max {
+			l.max, l.maxAt = l.current, at
+		}
+	}
+}
+
+func (l *level) stats(path string, start, end int64, quantiles []float64) Stats {
+	// Every request has ended, so the rest of the period is spent at 0.
+	l.duration[0] += end - l.since
+	span := end - start
+	s := Stats{Path: path, Requests: l.requests, Max: l.max, MaxAt: time.UnixMilli(l.maxAt).UTC()}
+	if span <= 0 {
+		return s
+	}
+	weighted := 0.0
+	for n, d := range l.duration {
+		weighted += float64(n) * float64(d)
+	}
+	s.Mean = weighted / float64(span)
+	for _, q := range quantiles {
+		// The smallest level that, with the levels below, lasted at least
+		// q of the period.
+		var seen int64
+		value := len(l.duration) - 1
+		for n, d := range l.duration {
+			seen += d
+			if float64(seen) >= q*float64(span) {
+				value = n
+				break
+			}
+		}
+		s.Percentiles = append(s.Percentiles, Percentile{Quantile: q, Value: value})
+	}
+	return s
+}
+
+// Sweep merges the events of all streams in time order and measures the
+// concurrency overall, per path template and, optionally, per interval.
+func Sweep(streams []*Events, cfg Config) *Report {
+	cfg = cfg.withDefaults()
+
+	// Map the path indexes of every stream to global ones.
+	var paths []string
+	index := make(map[string]int32)
+	remap := make([][]int32, len(streams))
+	merge := &mergeHeap{}
+	for i, s := range streams {
+		s.Sort()
+		for _, p := range s.paths {
+			global, ok := index[p]
+			if !ok {
+				global = int32(len(paths))
+				paths = append(paths, p)
+				index[p] = global
+			}
+			remap[i] = append(remap[i], global)
+		}
+		if len(s.events) > 0 {
+			merge.cursors = append(merge.cursors, cursor{events: s.events, remap: remap[i]})
+		}
+	}
+	if merge.Len() == 0 {
+		return &Report{}
+	}
+	heap.Init(merge)
+
+	start := merge.cursors[0].events[0].at
+	for _, c := range merge.cursors[1:] {
+		start = min(start, c.events[0].at)
+	}
+	overall := &level{since: start}
+	perPath := make([]*level, len(paths))
+	for i := range perPath {
+		perPath[i] = &level{since: start}
+	}
+
+	var intervals []Peak
+	interval := cfg.Interval.Milliseconds()
+	var end int64
+	for merge.Len() > 0 {
+		c := &merge.cursors[0]
+		e := c.events[c.next]
+		path := c.remap[e.path]
+		c.next++
+		if c.next == len(c.events) {
+			heap.Pop(merge)
+		} else {
+			heap.Fix(merge, 0)
+		}
+
+		if interval > 0 {
+			bucket := e.at / interval * interval
+			if len(intervals) == 0 {
+				at := time.UnixMilli(bucket).UTC()
+				intervals = append(intervals, Peak{Start: at, At: at})
+			}
+			// Every new interval starts with the requests carried over. The
+			// intervals without events in between all hold them, so only the
+			// first one is listed: a single bogus timestamp would otherwise
+			// list every interval up to it.
+			if last := intervals[len(intervals)-1].Start.UnixMilli(); last < bucket {
+				starts := []int64{bucket}
+				if last+interval < bucket {
+					starts = []int64{last + interval, bucket}
+				}
+				for _, from := range starts {
+					at := time.UnixMilli(from).UTC()
+					intervals = append(intervals, Peak{Start: at, Max: overall.current, At: at})
+				}
+			}
+		}
+
+		overall.change(e.at, e.delta)
+		perPath[path].change(e.at, e.delta)
+		if interval > 0 && e.delta > 0 {
+			if p := &intervals[len(intervals)-1]; overall.current > p.Max {
+				p.Max, p.At = overall.current, time.UnixMilli(e.at).UTC()
+			}
+		}
+		end = e.at
+	}
+
+	r := &Report{
+		Start:     time.UnixMilli(start).UTC(),
+		End:       time.UnixMilli(end).UTC(),
+		Overall:   overall.stats("", start, end, cfg.Quantiles),
+		Intervals: intervals,
+	}
+	for i, l := range perPath {
+		r.Paths = append(r.Paths, l.stats(paths[i], start, end, cfg.Quantiles))
+	}
+	slices.SortFunc(r.Paths, func(a, b Stats) int {
+		return cmp.Or(cmp.Compare(b.Max, a.Max), cmp.Compare(a.Path, b.Path))
+	})
+	return r
+}
+
+// cursor is the position in one sorted stream.
+type cursor struct {
+	events []event
+	next   int
+	remap  []int32
+}
+
+// mergeHeap orders the streams by their next event.
+type mergeHeap struct {
+	cursors []cursor
+}
+
+func (h *mergeHeap) Len() int { return len(h.cursors) }
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	return a.events[a.next].compare(b.events[b.next]) < 0
+}
+func (h *mergeHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+func (h *mergeHeap) Push(x any)    { h.cursors = append(h.cursors, x.(cursor)) }
+func (h *mergeHeap) Pop() any {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
@@ -0,0 +1,44 @@
+package inflight
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// TestSweepFarOutlier checks that a timestamp centuries away from the
+// others adds one interval after the gap rather than every interval of it.
+func TestSweepFarOutlier(t *testing.T) {
+	events := NewEvents()
+	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	add := func(at time.Time, ms int) {
+		entry := &logparser.LogEntry{Timestamp: at.Format(time.RFC3339Nano), Path: "/api/products", ResponseTimeMs: ms}
+		if err := events.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The second request is still in flight when the third interval starts.
+	add(start, 500)
+	add(start.Add(1500*time.Millisecond), 2000)
+	far := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	add(far, 10)
+
+	r := Sweep([]*Events{events}, Config{Interval: time.Second})
+	want := []Peak{
+		{Start: start, Max: 1, At: start},
+		{Start: start.Add(time.Second), Max: 1, At: start.Add(1500 * time.Millisecond)},
+		{Start: start.Add(2 * time.Second), Max: 1, At: start.Add(2 * time.Second)},
+		{Start: start.Add(3 * time.Second), Max: 1, At: start.Add(3 * time.Second)},
+		{Start: start.Add(4 * time.Second), Max: 0, At: start.Add(4 * time.Second)},
+		{Start: far, Max: 1, At: far},
+	}
+	if len(r.Intervals) != len(want) {
+		t.Fatalf("got %d intervals, want %d: %+v", len(r.Intervals), len(want), r.Intervals)
+	}
+	for i, p := range r.Intervals {
+		if !p.Start.Equal(want[i].Start) || p.Max != want[i].Max || !p.At.Equal(want[i].At) {
+			t.Errorf("interval %d: got %+v, want %+v", i, p, want[i])
+		}
+	}
+}
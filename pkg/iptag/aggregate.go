@@ -0,0 +1,163 @@
+package iptag
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// Untagged is the label of addresses that no prefix of the table contains.
+const Untagged = "untagged"
+
+// Counts are the requests of a group of clients.
+type Counts struct {
+	Requests     int
+	ClientErrors int // 4xx responses
+	ServerErrors int // 5xx responses
+}
+
+func (c *Counts) add(other Counts) {
+	c.Requests += other.Requests
+	c.ClientErrors += other.ClientErrors
+	c.ServerErrors += other.ServerErrors
+}
+
+// ClientErrorRate returns the percentage of 4xx responses.
+func (c Counts) ClientErrorRate() float64 {
+	return percent(c.ClientErrors, c.Requests)
+}
+
+// ServerErrorRate returns the percentage of 5xx responses.
+func (c Counts) ServerErrorRate() float64 {
+	return percent(c.ServerErrors, c.Requests)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+// Key identifies the requests of one prefix with one label. A prefix
+// shorter than a table entry, such as a /16 containing a tagged /24, is
+// split by label.
+type Key struct {
+	Label  string // empty without a table
+	Prefix netip.Prefix
+}
+
+// Aggregator counts requests by label and by prefix of the client address.
+// Aggregators of disjoint sets of entries, such as different files, can be
+// merged.
+type Aggregator struct {
+	table        *Table
+	bits4, bits6 int
+	Groups       map[Key]*Counts
+	Invalid      Counts // entries whose IP does not parse
+}
+
+// NewAggregator creates an Aggregator that groups IPv4 addresses by their
+// /bits4 prefix and IPv6 addresses by their /bits6 prefix, and tags them
+// with the labels of table if it is not nil.
+func NewAggregator(table *Table, bits4, bits6 int) *Aggregator {
+	return &Aggregator{
+		table:  table,
+		bits4:  min(max(bits4, 0), 32),
+		bits6:  min(max(bits6, 0), 128),
+		Groups: make(map[Key]*Counts),
+	}
+}
+
+// Add counts a log entry.
+func (a *Aggregator) Add(entry *logparser.LogEntry) {
+	var c Counts
+	c.Requests = 1
+	switch {
+	case entry.Status >= 400 && entry.Status < 500:
+		c.ClientErrors = 1
+	case entry.Status >= 500 && entry.Status < 600:
+		c.ServerErrors = 1
+	}
+
+	addr, err := netip.ParseAddr(entry.IP)
+	if err != nil {
+		a.Invalid.add(c)
+		return
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := a.bits6
+	if addr.Is4() {
+		bits = a.bits4
+	}
+	prefix, _ := addr.Prefix(bits)
+	key := Key{Prefix: prefix}
+	if a.table != nil {
+		key.Label = Untagged
+		if label, _, ok := a.table.Lookup(addr); ok {
+			key.Label = label
+		}
+	}
+	a.group(key).add(c)
+}
+
+// Merge adds the counts of other to a.
+func (a *Aggregator) Merge(other *Aggregator) {
+	for key, c := range other.Groups {
+		a.group(key).add(*c)
+	}
+	a.Invalid.add(other.Invalid)
+}
+
+func (a *Aggregator) group(key Key) *Counts {
+	c := a.Groups[key]
+	if c == nil {
+		c = &Counts{}
+		a.Groups[key] = c
+	}
+	return c
+}
+
+// PrefixCounts are the requests of one prefix.
+type PrefixCounts struct {
+	Prefix netip.Prefix
+	Counts
+}
+
+// Group is the requests of one label.
+type Group struct {
+	Label string // empty without a table
+	Counts
+	Prefixes    int            // number of distinct prefixes
+	TopPrefixes []PrefixCounts // by decreasing requests
+}
+
+// Report sums the requests by label, by decreasing requests, with the top
+// prefixes of every label. Without a table there is one group, with an
+// empty label. A negative top lists no prefixes.
+func (a *Aggregator) Report(top int) []Group {
+	top = max(top, 0)
+	byLabel := make(map[string][]PrefixCounts)
+	for key, c := range a.Groups {
+		byLabel[key.Label] = append(byLabel[key.Label], PrefixCounts{Prefix: key.Prefix, Counts: *c})
+	}
+
+	var groups []Group
+	for label, prefixes := range byLabel {
+		g := Group{Label: label, Prefixes: len(prefixes)}
+		for _, p := range prefixes {
+			g.add(p.Counts)
+		}
+		slices.SortFunc(prefixes, func(a, b PrefixCounts) int {
+			return cmp.Or(cmp.Compare(b.Requests, a.Requests), a.Prefix.Addr().Compare(b.Prefix.Addr()))
+		})
+		g.TopPrefixes = prefixes[:min(len(prefixes), top)]
+		groups = append(groups, g)
+	}
+	slices.SortFunc(groups, func(a, b Group) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(a.Label, b.Label))
+	})
+	return groups
+}
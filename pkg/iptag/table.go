@@ -0,0 +1,142 @@
+// Package iptag groups client addresses by network prefix and tags them
+// with the label of the longest matching prefix of a CIDR table, such as
+// office, VPN or datacenter ranges.
+package iptag
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+)
+
+// node is a node of a binary trie over the bits of a prefix.
+type node struct {
+	child  [2]*node
+	prefix netip.Prefix
+	label  string
+	set    bool
+}
+
+// Table maps prefixes to labels. A lookup returns the longest prefix that
+// contains the address, walking at most 32 nodes for IPv4 and 128 for
+// IPv6. IPv4-mapped IPv6 addresses are looked up as IPv4.
+type Table struct {
+	v4, v6 node
+	n      int
+}
+
+// NewTable creates an empty Table.
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Len returns the number of prefixes in t.
+func (t *Table) Len() int {
+	return t.n
+}
+
+// Insert maps prefix, which must be canonical (10.0.0.0/8, not
+// 10.1.2.3/8), to label.
+func (t *Table) Insert(prefix netip.Prefix, label string) error {
+	if !prefix.IsValid() {
+		return fmt.Errorf("invalid prefix %s", prefix)
+	}
+	if prefix.Addr().Is4In6() || prefix.Addr().Zone() != "" {
+		return fmt.Errorf("prefix %s: use a plain IPv4 or IPv6 prefix", prefix)
+	}
+	if masked := prefix.Masked(); masked != prefix {
+		return fmt.Errorf("prefix %s has host bits set, did you mean %s?", prefix, masked)
+	}
+	n := t.root(prefix.Addr())
+	raw := prefix.Addr().AsSlice()
+	for i := range prefix.Bits() {
+		b := bit(raw, i)
+		if n.child[b] == nil {
+			n.child[b] = &node{}
+		}
+		n = n.child[b]
+	}
+	if n.set {
+		return fmt.Errorf("duplicate prefix %s (%q and %q)", prefix, n.label, label)
+	}
+	n.prefix, n.label, n.set = prefix, label, true
+	t.n++
+	return nil
+}
+
+// Lookup returns the label and the longest prefix of t containing addr.
+func (t *Table) Lookup(addr netip.Addr) (label string, prefix netip.Prefix, ok bool) {
+	addr = addr.Unmap().WithZone("")
+	if !addr.IsValid() {
+		return "", netip.Prefix{}, false
+	}
+	n := t.root(addr)
+	raw := addr.AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.set {
+			label, prefix, ok = n.label, n.prefix, true
+		}
+		if i == len(raw)*8 {
+			break
+		}
+		n = n.child[bit(raw, i)]
+	}
+	return label, prefix, ok
+}
+
+func (t *Table) root(addr netip.Addr) *node {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+// bit returns bit i of an address, from the most significant.
+func bit(raw []byte, i int) int {
+	return int(raw[i/8]>>(7-i%8)) & 1
+}
+
+// LoadTable reads a JSON object mapping CIDR prefixes to labels:
+//
+//	{"10.0.0.0/8": "datacenter", "2001:db8::/32": "vpn"}
+func LoadTable(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CIDR table: %w", err)
+	}
+	var entries map[string]string
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to parse CIDR table %s: %w", path, err)
+	}
+
+	t := NewTable()
+	var errs []error
+	// Sorted, so that errors come in a stable order.
+	cidrs := make([]string, 0, len(entries))
+	for cidr := range entries {
+		cidrs = append(cidrs, cidr)
+	}
+	slices.Sort(cidrs)
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case entries[cidr] == "":
+			errs = append(errs, fmt.Errorf("prefix %s: label is empty", cidr))
+		default:
+			if err := t.Insert(prefix, entries[cidr]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid CIDR table %s: %w", path, err)
+	}
+	return t, nil
+}
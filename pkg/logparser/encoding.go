@@ -0,0 +1,272 @@
+package logparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Versions of the encodings. Decoding rejects any other version, so they
+// must change whenever an encoding does.
+const (
+	resultEncodingVersion    = 1
+	resultSetEncodingVersion = 1
+)
+
+// resultSetMagic starts the binary encoding of a ResultSet.
+var resultSetMagic = []byte("LPRS")
+
+var errTruncated = errors.New("truncated data")
+
+// MarshalBinary encodes r compactly. Status codes are written in ascending
+// order, so equal results have equal encodings.
+func (r *Result) MarshalBinary() ([]byte, error) {
+	buf := []byte{resultEncodingVersion}
+	buf = binary.AppendUvarint(buf, uint64(len(r.FileName)))
+	buf = append(buf, r.FileName...)
+	buf = binary.AppendVarint(buf, int64(r.TotalCount))
+	return appendStatusCounts(buf, r.StatusCounts), nil
+}
+
+// UnmarshalBinary decodes data encoded by MarshalBinary.
+func (r *Result) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.version(resultEncodingVersion)
+	name := d.bytes()
+	total := d.varint()
+	counts := d.statusCounts()
+	if err := d.finish(); err != nil {
+		return fmt.Errorf("invalid result: %w", err)
+	}
+	*r = Result{FileName: string(name), TotalCount: int(total), StatusCounts: counts}
+	return nil
+}
+
+// MarshalBinary encodes tr like Result.MarshalBinary.
+func (tr *TotalResult) MarshalBinary() ([]byte, error) {
+	buf := []byte{resultEncodingVersion}
+	buf = binary.AppendVarint(buf, int64(tr.FileCount))
+	buf = binary.AppendVarint(buf, int64(tr.TotalCount))
+	return appendStatusCounts(buf, tr.StatusCounts), nil
+}
+
+// UnmarshalBinary decodes data encoded by MarshalBinary.
+func (tr *TotalResult) UnmarshalBinary(data []byte) error {
+	d := decoder{data: data}
+	d.version(resultEncodingVersion)
+	files := d.varint()
+	total := d.varint()
+	counts := d.statusCounts()
+	if err := d.finish(); err != nil {
+		return fmt.Errorf("invalid total result: %w", err)
+	}
+	*tr = TotalResult{FileCount: int(files), TotalCount: int(total), StatusCounts: counts}
+	return nil
+}
+
+// Equal reports whether tr and other count the same.
+func (tr *TotalResult) Equal(other *TotalResult) bool {
+	return tr.FileCount == other.FileCount && tr.TotalCount == other.TotalCount &&
+		maps.Equal(tr.StatusCounts, other.StatusCounts)
+}
+
+// ResultSet is the output of one analysis run in a form that can be stored
+// and merged with the output of other runs: the result of every file and
+// their total, which guards against truncated or edited data.
+type ResultSet struct {
+	Version int          `json:"version"`
+	Results []*Result    `json:"results"`
+	Total   *TotalResult `json:"total"`
+}
+
+// NewResultSet returns the set of results.
+func NewResultSet(results []*Result) *ResultSet {
+	return &ResultSet{
+		Version: resultSetEncodingVersion,
+		Results: results,
+		Total:   MergeResults(results),
+	}
+}
+
+// MarshalBinary encodes s as a magic number and a version followed by the
+// length-prefixed binary encodings of the total and of every result.
+func (s *ResultSet) MarshalBinary() ([]byte, error) {
+	buf := slices.Clone(resultSetMagic)
+	buf = binary.AppendUvarint(buf, resultSetEncodingVersion)
+
+	total, err := s.Total.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(total)))
+	buf = append(buf, total...)
+
+	buf = binary.AppendUvarint(buf, uint64(len(s.Results)))
+	for _, r := range s.Results {
+		data, err := r.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = binary.AppendUvarint(buf, uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes data encoded by MarshalBinary.
+func (s *ResultSet) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, resultSetMagic) {
+		return errors.New("invalid result set: bad magic number")
+	}
+	d := decoder{data: data[len(resultSetMagic):]}
+	if v := d.uvarint(); d.err == nil && v != resultSetEncodingVersion {
+		return fmt.Errorf("unsupported result set version %d", v)
+	}
+
+	total := &TotalResult{}
+	if data := d.bytes(); d.err == nil {
+		if err := total.UnmarshalBinary(data); err != nil {
+			return err
+		}
+	}
+	n := d.uvarint()
+	var results []*Result
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		r := &Result{}
+		if data := d.bytes(); d.err == nil {
+			if err := r.UnmarshalBinary(data); err != nil {
+				return err
+			}
+		}
+		results = append(results, r)
+	}
+	if err := d.finish(); err != nil {
+		return fmt.Errorf("invalid result set: %w", err)
+	}
+	*s = ResultSet{Version: resultSetEncodingVersion, Results: results, Total: total}
+	return nil
+}
+
+// DecodeResultSet decodes a ResultSet in either encoding and checks that
+// its results add up to its total.
+func DecodeResultSet(data []byte) (*ResultSet, error) {
+	s := &ResultSet{}
+	if bytes.HasPrefix(data, resultSetMagic) {
+		if err := s.UnmarshalBinary(data); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("invalid result set: %w", err)
+		}
+		if s.Version != resultSetEncodingVersion {
+			return nil, fmt.Errorf("unsupported result set version %d", s.Version)
+		}
+		if s.Total == nil || s.Total.StatusCounts == nil || slices.ContainsFunc(s.Results, func(r *Result) bool {
+			return r == nil || r.StatusCounts == nil
+		}) {
+			return nil, errors.New("invalid result set: missing fields")
+		}
+	}
+
+	if !MergeResults(s.Results).Equal(s.Total) {
+		return nil, errors.New("invalid result set: results do not add up to the total")
+	}
+	return s, nil
+}
+
+func appendStatusCounts(buf []byte, counts map[int]int) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(counts)))
+	for _, status := range slices.Sorted(maps.Keys(counts)) {
+		buf = binary.AppendVarint(buf, int64(status))
+		buf = binary.AppendVarint(buf, int64(counts[status]))
+	}
+	return buf
+}
+
+// decoder reads the binary encodings. After the first error every read
+// returns zero, so that the error is checked once at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) version(want byte) {
+	if d.err != nil {
+		return
+	}
+	if len(d.data) == 0 {
+		d.err = errTruncated
+		return
+	}
+	if d.data[0] != want {
+		d.err = fmt.Errorf("unsupported version %d", d.data[0])
+		return
+	}
+	d.data = d.data[1:]
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.data)) {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) statusCounts() map[int]int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.data)) {
+		d.err = errTruncated // each entry takes at least one byte
+	}
+	counts := make(map[int]int, min(n, 1024))
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		status := d.varint()
+		counts[int(status)] = int(d.varint())
+	}
+	return counts
+}
+
+// finish returns the first error, or an error if data is left over.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.data) > 0 {
+		d.err = fmt.Errorf("%d unexpected trailing bytes", len(d.data))
+	}
+	return d.err
+}
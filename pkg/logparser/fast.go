@@ -0,0 +1,59 @@
+package logparser
+
+import (
+	"bytes"
+	"errors"
+)
+
+var (
+	statusKey = []byte(`"status":`)
+
+	errNoStatus = errors.New("status field not found")
+)
+
+// ParseStatus extracts the status field from a JSON log line without
+// decoding the rest of the line and without allocating.
+func ParseStatus(line []byte) (int, error) {
+	i := statusValue(line)
+	if i < 0 {
+		return 0, errNoStatus
+	}
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+
+	status, digits := 0, 0
+	for ; i < len(line) && line[i] >= '0' && line[i] <= '9'; i++ {
+		status = status*10 + int(line[i]-'0')
+		digits++
+	}
+	if digits == 0 || digits > 3 {
+		return 0, errNoStatus
+	}
+	return status, nil
+}
+
+// statusValue returns the offset of the value of the status key, or -1.
+// A key follows '{' or ','; other matches are inside a string, such as a
+// path ending in \"status\":, where the quotes are escaped.
+func statusValue(line []byte) int {
+	for from := 0; ; {
+		i := bytes.Index(line[from:], statusKey)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		j := i - 1
+		for j >= 0 && isSpace(line[j]) {
+			j--
+		}
+		if j >= 0 && (line[j] == '{' || line[j] == ',') {
+			return i + len(statusKey)
+		}
+		from = i + 1
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -29,10 +29,11 @@ func ParseLine(line string) (*LogEntry, error) {
 }
 
 // Result represents the analysis result for a single log file.
+// Its JSON encoding is stable: map keys are written in sorted order.
 type Result struct {
-	FileName     string
-	TotalCount   int
-	StatusCounts map[int]int
+	FileName     string      `json:"file_name"`
+	TotalCount   int         `json:"total_count"`
+	StatusCounts map[int]int `json:"status_counts"`
 }
 
 // NewResult creates a new Result with initialized maps.
@@ -45,15 +46,30 @@ func NewResult(filename string) *Result {
 
 // AddEntry adds a log entry to the result, updating counters.
 func (r *Result) AddEntry(entry *LogEntry) {
+	r.AddStatus(entry.Status)
+}
+
+// AddStatus counts a log entry with the given status code.
+func (r *Result) AddStatus(status int) {
 	r.TotalCount++
-	r.StatusCounts[entry.Status]++
+	r.StatusCounts[status]++
+}
+
+// Merge adds the counts of other, e.g. a result for another part of the
+// same file, to r.
+func (r *Result) Merge(other *Result) {
+	r.TotalCount += other.TotalCount
+	for status, count := range other.StatusCounts {
+		r.StatusCounts[status] += count
+	}
 }
 
 // TotalResult represents the aggregated result from all log files.
+// Its JSON encoding is stable like that of Result.
 type TotalResult struct {
-	FileCount    int
-	TotalCount   int
-	StatusCounts map[int]int
+	FileCount    int         `json:"file_count"`
+	TotalCount   int         `json:"total_count"`
+	StatusCounts map[int]int `json:"status_counts"`
 }
 
 // NewTotalResult creates a new TotalResult with initialized maps.
@@ -66,18 +82,30 @@ func NewTotalResult() *TotalResult {
 // MergeResults merges multiple Results into a single TotalResult.
 func MergeResults(results []*Result) *TotalResult {
 	total := NewTotalResult()
-	total.FileCount = len(results)
-
 	for _, r := range results {
-		total.TotalCount += r.TotalCount
-		for status, count := range r.StatusCounts {
-			total.StatusCounts[status] += count
-		}
+		total.Add(r)
 	}
-
 	return total
 }
 
+// Add counts the result of one more file.
+func (tr *TotalResult) Add(r *Result) {
+	tr.FileCount++
+	tr.TotalCount += r.TotalCount
+	for status, count := range r.StatusCounts {
+		tr.StatusCounts[status] += count
+	}
+}
+
+// Merge adds the totals of other, e.g. computed by an earlier run, to tr.
+func (tr *TotalResult) Merge(other *TotalResult) {
+	tr.FileCount += other.FileCount
+	tr.TotalCount += other.TotalCount
+	for status, count := range other.StatusCounts {
+		tr.StatusCounts[status] += count
+	}
+}
+
 // ErrorRate calculates the percentage of 4xx and 5xx status codes.
 func (tr *TotalResult) ErrorRate() float64 {
 	if tr.TotalCount == 0 {
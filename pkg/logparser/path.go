@@ -0,0 +1,32 @@
+package logparser
+
+import "strings"
+
+// PathTemplate normalizes a request path to its route template by replacing
+// numeric segments with "{id}", e.g. "/api/products/42" becomes
+// "/api/products/{id}". Paths without numeric segments are returned as is.
+func PathTemplate(path string) string {
+	if !strings.ContainsAny(path, "0123456789") {
+		return path
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isNumeric(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+package logparser
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// MaxFunnelSteps is the maximum number of steps in a funnel.
+const MaxFunnelSteps = 64
+
+// FunnelStep is one step of a conversion funnel.
+// An empty Method matches any method.
+type FunnelStep struct {
+	Method   string
+	Template string
+}
+
+// ParseFunnel parses a comma separated funnel such as
+// "/api/auth/login,/api/products/{id},POST /api/orders".
+func ParseFunnel(spec string) ([]FunnelStep, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	var steps []FunnelStep
+	for _, part := range strings.Split(spec, ",") {
+		fields := strings.Fields(part)
+		switch len(fields) {
+		case 1:
+			steps = append(steps, FunnelStep{Template: fields[0]})
+		case 2:
+			steps = append(steps, FunnelStep{Method: strings.ToUpper(fields[0]), Template: fields[1]})
+		default:
+			return nil, fmt.Errorf("invalid funnel step %q", part)
+		}
+	}
+	if len(steps) > MaxFunnelSteps {
+		return nil, fmt.Errorf("funnel has %d steps, at most %d are supported", len(steps), MaxFunnelSteps)
+	}
+	return steps, nil
+}
+
+// String returns the step in the form accepted by ParseFunnel.
+func (s FunnelStep) String() string {
+	if s.Method == "" {
+		return s.Template
+	}
+	return s.Method + " " + s.Template
+}
+
+// SessionConfig controls how requests are grouped into sessions.
+type SessionConfig struct {
+	// Gap is the inactivity period after which a new session starts.
+	Gap time.Duration
+	// Funnel is the ordered path sequence whose conversion is measured.
+	Funnel []FunnelStep
+}
+
+// SessionEvent is the part of a log entry that sessionization needs.
+type SessionEvent struct {
+	At    int64  // Unix milliseconds
+	Steps uint64 // bit i is set if the request matches Funnel[i]
+}
+
+// Event extracts the SessionEvent of a log entry.
+func (c *SessionConfig) Event(entry *LogEntry) (SessionEvent, error) {
+	t, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		return SessionEvent{}, fmt.Errorf("invalid timestamp: %w", err)
+	}
+
+	event := SessionEvent{At: t.UnixMilli()}
+	if len(c.Funnel) > 0 {
+		template := PathTemplate(entry.Path)
+		for i, step := range c.Funnel {
+			if step.Template == template && (step.Method == "" || step.Method == entry.Method) {
+				event.Steps |= 1 << i
+			}
+		}
+	}
+	return event, nil
+}
+
+// SessionStats is the aggregated result of sessionization.
+type SessionStats struct {
+	Users         int
+	Sessions      int
+	Requests      int
+	Bounces       int // sessions with a single request
+	TotalDuration time.Duration
+	MaxDuration   time.Duration
+	Funnel        []FunnelStep
+	FunnelReached []int // sessions that reached each funnel step in order
+}
+
+// NewSessionStats creates an empty SessionStats for the given funnel.
+func NewSessionStats(funnel []FunnelStep) *SessionStats {
+	return &SessionStats{
+		Funnel:        funnel,
+		FunnelReached: make([]int, len(funnel)),
+	}
+}
+
+// Merge adds the counts of other, which must use the same funnel.
+func (s *SessionStats) Merge(other *SessionStats) {
+	s.Users += other.Users
+	s.Sessions += other.Sessions
+	s.Requests += other.Requests
+	s.Bounces += other.Bounces
+	s.TotalDuration += other.TotalDuration
+	s.MaxDuration = max(s.MaxDuration, other.MaxDuration)
+	for i, n := range other.FunnelReached {
+		s.FunnelReached[i] += n
+	}
+}
+
+// AvgDuration returns the mean session duration.
+func (s *SessionStats) AvgDuration() time.Duration {
+	if s.Sessions == 0 {
+		return 0
+	}
+	return s.TotalDuration / time.Duration(s.Sessions)
+}
+
+// PagesPerSession returns the mean number of requests per session.
+func (s *SessionStats) PagesPerSession() float64 {
+	if s.Sessions == 0 {
+		return 0
+	}
+	return float64(s.Requests) / float64(s.Sessions)
+}
+
+// Conversion returns the percentage of sessions that reached step i among
+// those that reached step i-1 (or among all sessions for the first step).
+func (s *SessionStats) Conversion(i int) float64 {
+	base := s.Sessions
+	if i > 0 {
+		base = s.FunnelReached[i-1]
+	}
+	if base == 0 {
+		return 0
+	}
+	return float64(s.FunnelReached[i]) / float64(base) * 100
+}
+
+// Sessionizer groups events by user and splits them into sessions.
+// It keeps every event of every user in memory, so when users span many
+// files it should be fed by a stage that partitions events by user.
+type Sessionizer struct {
+	config SessionConfig
+	events map[string][]SessionEvent
+}
+
+// NewSessionizer creates a Sessionizer with the given configuration.
+func NewSessionizer(config SessionConfig) *Sessionizer {
+	return &Sessionizer{
+		config: config,
+		events: make(map[string][]SessionEvent),
+	}
+}
+
+// Add records a log entry.
+func (s *Sessionizer) Add(entry *LogEntry) error {
+	event, err := s.config.Event(entry)
+	if err != nil {
+		return err
+	}
+	s.AddEvent(entry.UserID, event)
+	return nil
+}
+
+// AddEvent records an event that was already extracted with SessionConfig.Event.
+func (s *Sessionizer) AddEvent(userID string, event SessionEvent) {
+	s.events[userID] = append(s.events[userID], event)
+}
+
+// Result sessionizes every user seen so far.
+func (s *Sessionizer) Result() *SessionStats {
+	stats := NewSessionStats(s.config.Funnel)
+	gap := s.config.Gap.Milliseconds()
+
+	for _, events := range s.events {
+		slices.SortFunc(events, func(a, b SessionEvent) int {
+			return cmp.Compare(a.At, b.At)
+		})
+		stats.Users++
+
+		start := 0
+		for i := 1; i <= len(events); i++ {
+			if i == len(events) || events[i].At-events[i-1].At > gap {
+				s.addSession(stats, events[start:i])
+				start = i
+			}
+		}
+	}
+	return stats
+}
+
+func (s *Sessionizer) addSession(stats *SessionStats, events []SessionEvent) {
+	duration := time.Duration(events[len(events)-1].At-events[0].At) * time.Millisecond
+
+	stats.Sessions++
+	stats.Requests += len(events)
+	stats.TotalDuration += duration
+	stats.MaxDuration = max(stats.MaxDuration, duration)
+	if len(events) == 1 {
+		stats.Bounces++
+	}
+
+	step := 0
+	for _, event := range events {
+		if step == len(s.config.Funnel) {
+			break
+		}
+		if event.Steps&(1<<step) != 0 {
+			stats.FunnelReached[step]++
+			step++
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package logparser
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// latencyBuckets is the number of buckets of a LatencyHistogram. Bucket i
+// holds response times in [latencyBound(i-1), latencyBound(i)), each bound
+// about 5% above the previous, and the last one everything above.
+const latencyBuckets = 192
+
+var latencyBase = math.Log(1.05)
+
+// LatencyHistogram counts response times in logarithmic buckets, so that
+// quantiles are accurate to about 5% and histograms can be merged.
+type LatencyHistogram [latencyBuckets]uint32
+
+// Add counts a response time in milliseconds.
+func (h *LatencyHistogram) Add(ms int) {
+	i := 0
+	if ms > 0 {
+		i = min(int(math.Log1p(float64(ms))/latencyBase), latencyBuckets-1)
+	}
+	h[i]++
+}
+
+// Merge adds the counts of other to h.
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	for i, n := range other {
+		h[i] += n
+	}
+}
+
+// Quantile returns the upper bound in milliseconds of the bucket holding
+// the q quantile, or 0 if h is empty.
+func (h *LatencyHistogram) Quantile(q float64) float64 {
+	var total uint64
+	for _, n := range h {
+		total += uint64(n)
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, n := range h {
+		seen += uint64(n)
+		if seen >= max(rank, 1) {
+			return latencyBound(i)
+		}
+	}
+	return latencyBound(latencyBuckets - 1)
+}
+
+func latencyBound(i int) float64 {
+	return math.Expm1(float64(i+1) * latencyBase)
+}
+
+// PathMinute counts the requests to one path template in one minute.
+type PathMinute struct {
+	Requests  int
+	Errors    int   // 5xx responses
+	LatencyMs int64 // sum of the response times
+}
+
+// Minute aggregates the requests of one minute.
+type Minute struct {
+	Requests int
+	Errors   int // 5xx responses
+	Latency  LatencyHistogram
+	Paths    map[string]*PathMinute // by PathTemplate
+}
+
+// Series buckets log entries by minute. Series of disjoint sets of entries,
+// such as different files, can be merged.
+type Series struct {
+	Minutes map[int64]*Minute // by Unix minute
+}
+
+// NewSeries creates an empty Series.
+func NewSeries() *Series {
+	return &Series{Minutes: make(map[int64]*Minute)}
+}
+
+// Add counts a log entry in the minute of its timestamp.
+func (s *Series) Add(entry *LogEntry) error {
+	t, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	m := s.minute(t.Unix() / 60)
+	m.Requests++
+	m.Latency.Add(entry.ResponseTimeMs)
+
+	template := PathTemplate(entry.Path)
+	p := m.Paths[template]
+	if p == nil {
+		p = &PathMinute{}
+		m.Paths[template] = p
+	}
+	p.Requests++
+	p.LatencyMs += int64(entry.ResponseTimeMs)
+	if entry.Status >= 500 && entry.Status < 600 {
+		m.Errors++
+		p.Errors++
+	}
+	return nil
+}
+
+// Merge adds the minutes of other to s.
+func (s *Series) Merge(other *Series) {
+	for t, om := range other.Minutes {
+		m := s.minute(t)
+		m.Requests += om.Requests
+		m.Errors += om.Errors
+		m.Latency.Merge(&om.Latency)
+		for template, op := range om.Paths {
+			p := m.Paths[template]
+			if p == nil {
+				p = &PathMinute{}
+				m.Paths[template] = p
+			}
+			p.Requests += op.Requests
+			p.Errors += op.Errors
+			p.LatencyMs += op.LatencyMs
+		}
+	}
+}
+
+// Span returns the first and last minute with requests, as Unix minutes.
+func (s *Series) Span() (first, last int64, ok bool) {
+	for t := range s.Minutes {
+		if !ok || t < first {
+			first = t
+		}
+		if !ok || t > last {
+			last = t
+		}
+		ok = true
+	}
+	return first, last, ok
+}
+
+func (s *Series) minute(t int64) *Minute {
+	m := s.Minutes[t]
+	if m == nil {
+		m = &Minute{Paths: make(map[string]*PathMinute)}
+		s.Minutes[t] = m
+	}
+	return m
+}
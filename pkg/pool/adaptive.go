@@ -0,0 +1,276 @@
+// Package pool provides a worker pool that resizes itself while running.
+//
+// Workers report the bytes they processed and how long they waited for I/O
+// versus how long they were busy computing. A controller samples these
+// counters periodically and grows the pool while the workers mostly wait for
+// I/O (for example on a cold page cache), shrinks it back towards GOMAXPROCS
+// when they are CPU bound, and undoes a resize that made throughput worse.
+package pool
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config bounds and tunes an adaptive Pool.
+type Config struct {
+	// Min and Max bound the number of workers. Zero values default to 1 and
+	// 4*GOMAXPROCS.
+	Min, Max int
+	// Initial is the number of workers started with. Zero means GOMAXPROCS,
+	// clamped to [Min, Max].
+	Initial int
+	// Interval is how often the controller samples the workers.
+	Interval time.Duration
+	// Logger receives every resize decision. Nil disables logging.
+	Logger *log.Logger
+}
+
+// Thresholds of the I/O wait ratio used by the controller.
+const (
+	ioBoundRatio  = 0.5
+	cpuBoundRatio = 0.2
+	// throughputDrop is the relative drop after a resize that makes the
+	// controller undo it.
+	throughputDrop = 0.15
+)
+
+func (c Config) withDefaults() Config {
+	procs := runtime.GOMAXPROCS(0)
+	if c.Min <= 0 {
+		c.Min = 1
+	}
+	if c.Max <= 0 {
+		c.Max = 4 * procs
+	}
+	c.Max = max(c.Max, c.Min)
+	if c.Initial <= 0 {
+		c.Initial = procs
+	}
+	c.Initial = min(max(c.Initial, c.Min), c.Max)
+	if c.Interval <= 0 {
+		c.Interval = 500 * time.Millisecond
+	}
+	return c
+}
+
+// Meter accumulates the work reported by workers. A nil *Meter ignores
+// everything, so work functions can report unconditionally.
+type Meter struct {
+	bytes  atomic.Int64
+	ioWait atomic.Int64
+	busy   atomic.Int64
+}
+
+// Add records that bytes were processed after waiting ioWait for input and
+// spending busy on computation.
+func (m *Meter) Add(bytes int64, ioWait, busy time.Duration) {
+	if m == nil {
+		return
+	}
+	m.bytes.Add(bytes)
+	m.ioWait.Add(int64(ioWait))
+	m.busy.Add(int64(busy))
+}
+
+type meterSnapshot struct {
+	bytes  int64
+	ioWait time.Duration
+	busy   time.Duration
+}
+
+func (m *Meter) snapshot() meterSnapshot {
+	return meterSnapshot{
+		bytes:  m.bytes.Load(),
+		ioWait: time.Duration(m.ioWait.Load()),
+		busy:   time.Duration(m.busy.Load()),
+	}
+}
+
+// Decision is a resize made by the controller.
+type Decision struct {
+	Elapsed    time.Duration // since the pool started
+	From, To   int
+	Throughput float64 // bytes per second during the last interval
+	IOWait     float64 // fraction of worker time spent waiting for I/O
+	Reason     string
+}
+
+func (d Decision) String() string {
+	return fmt.Sprintf("[%6.2fs] pool: %d -> %d workers: %s (%.1fMB/s, io wait %.0f%%)",
+		d.Elapsed.Seconds(), d.From, d.To, d.Reason, d.Throughput/(1024*1024), d.IOWait*100)
+}
+
+// Pool runs work for every job received from a channel with a varying
+// number of workers.
+type Pool[T any] struct {
+	cfg   Config
+	jobs  <-chan T
+	work  func(job T, m *Meter)
+	meter Meter
+	start time.Time
+
+	quit chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu        sync.Mutex
+	workers   int
+	drained   bool
+	decisions []Decision
+}
+
+// Start launches the pool. It processes jobs until the channel is closed.
+func Start[T any](jobs <-chan T, cfg Config, work func(job T, m *Meter)) *Pool[T] {
+	cfg = cfg.withDefaults()
+	p := &Pool[T]{
+		cfg:   cfg,
+		jobs:  jobs,
+		work:  work,
+		start: time.Now(),
+		quit:  make(chan struct{}, cfg.Max),
+		stop:  make(chan struct{}),
+	}
+
+	p.mu.Lock()
+	for range cfg.Initial {
+		p.spawnLocked()
+	}
+	p.mu.Unlock()
+
+	if cfg.Min < cfg.Max {
+		go p.control()
+	}
+	return p
+}
+
+// Wait blocks until every job has been processed.
+func (p *Pool[T]) Wait() {
+	p.wg.Wait()
+	close(p.stop)
+}
+
+// Workers returns the current target number of workers.
+func (p *Pool[T]) Workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.workers
+}
+
+// Decisions returns the resizes made so far.
+func (p *Pool[T]) Decisions() []Decision {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Decision(nil), p.decisions...)
+}
+
+func (p *Pool[T]) spawnLocked() {
+	p.workers++
+	p.wg.Go(func() {
+		for {
+			select {
+			case <-p.quit:
+				return
+			case job, ok := <-p.jobs:
+				if !ok {
+					// No worker may be added once the jobs are drained,
+					// otherwise Wait could return while a worker starts.
+					p.mu.Lock()
+					p.drained = true
+					p.mu.Unlock()
+					return
+				}
+				p.work(job, &p.meter)
+			}
+		}
+	})
+}
+
+func (p *Pool[T]) control() {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	procs := runtime.GOMAXPROCS(0)
+	prev := p.meter.snapshot()
+	prevThroughput := 0.0
+	lastResize := 0
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		cur := p.meter.snapshot()
+		delta := meterSnapshot{
+			bytes:  cur.bytes - prev.bytes,
+			ioWait: cur.ioWait - prev.ioWait,
+			busy:   cur.busy - prev.busy,
+		}
+		prev = cur
+		if delta.ioWait+delta.busy == 0 {
+			continue // no chunk finished during this interval
+		}
+
+		throughput := float64(delta.bytes) / p.cfg.Interval.Seconds()
+		ioWait := float64(delta.ioWait) / float64(delta.ioWait+delta.busy)
+
+		p.mu.Lock()
+		n := p.workers
+		target, reason, revert := n, "", false
+		switch {
+		case lastResize != 0 && prevThroughput > 0 && throughput < prevThroughput*(1-throughputDrop):
+			target = min(max(n-lastResize, p.cfg.Min), p.cfg.Max)
+			reason = fmt.Sprintf("throughput fell from %.1fMB/s after the last resize, reverting",
+				prevThroughput/(1024*1024))
+			revert = true
+		case ioWait >= ioBoundRatio && n < p.cfg.Max:
+			target = min(n+max(n/4, 1), p.cfg.Max)
+			reason = "I/O bound, adding workers to keep more reads in flight"
+		case ioWait <= cpuBoundRatio && n > max(procs, p.cfg.Min):
+			target = max(n-1, procs, p.cfg.Min)
+			reason = fmt.Sprintf("CPU bound with more workers than GOMAXPROCS=%d", procs)
+		}
+
+		if target != n && !p.drained {
+			p.resizeLocked(target)
+			d := Decision{
+				Elapsed:    time.Since(p.start),
+				From:       n,
+				To:         target,
+				Throughput: throughput,
+				IOWait:     ioWait,
+				Reason:     reason,
+			}
+			p.decisions = append(p.decisions, d)
+			if p.cfg.Logger != nil {
+				p.cfg.Logger.Print(d)
+			}
+			lastResize = target - n
+			if revert {
+				// A revert is not judged again, or the pool would oscillate.
+				lastResize = 0
+			}
+		} else {
+			lastResize = 0
+		}
+		p.mu.Unlock()
+
+		prevThroughput = throughput
+	}
+}
+
+func (p *Pool[T]) resizeLocked(target int) {
+	for p.workers < target {
+		p.spawnLocked()
+	}
+	for p.workers > target {
+		p.workers--
+		p.quit <- struct{}{}
+	}
+}
@@ -0,0 +1,126 @@
+// Package profiling adds the standard profiling flags to a command:
+// --cpuprofile, --memprofile, --blockprofile, --mutexprofile and --trace.
+//
+// The files can be inspected with go tool pprof, go tool trace, or with
+// cmd/tracetimeline for a per-worker timeline of the execution trace.
+package profiling
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+// Flags holds the output paths. An empty path disables that profile.
+type Flags struct {
+	CPUProfile   string
+	MemProfile   string
+	BlockProfile string
+	MutexProfile string
+	Trace        string
+}
+
+// Register defines the profiling flags on fs.
+func Register(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.CPUProfile, "cpuprofile", "", "Write a CPU profile to this file")
+	fs.StringVar(&f.MemProfile, "memprofile", "", "Write a heap profile to this file at exit")
+	fs.StringVar(&f.BlockProfile, "blockprofile", "", "Write a goroutine blocking profile to this file at exit")
+	fs.StringVar(&f.MutexProfile, "mutexprofile", "", "Write a mutex contention profile to this file at exit")
+	fs.StringVar(&f.Trace, "trace", "", "Write a runtime execution trace to this file")
+	return f
+}
+
+// Start starts the requested profiles. The returned function stops them
+// and writes the profiles taken at exit; it reports failures on stderr so
+// that it can simply be deferred.
+func (f *Flags) Start() (stop func(), err error) {
+	var stops []func() error
+	stopAll := func() error {
+		var errs []error
+		// Stop in reverse order, so the trace does not include the writing
+		// of the other profiles.
+		for i := len(stops) - 1; i >= 0; i-- {
+			errs = append(errs, stops[i]())
+		}
+		return errors.Join(errs...)
+	}
+	fail := func(err error) (func(), error) {
+		stopAll()
+		return nil, err
+	}
+
+	if f.CPUProfile != "" {
+		file, err := os.Create(f.CPUProfile)
+		if err != nil {
+			return fail(fmt.Errorf("failed to create CPU profile: %w", err))
+		}
+		if err := pprof.StartCPUProfile(file); err != nil {
+			file.Close()
+			return fail(fmt.Errorf("failed to start CPU profile: %w", err))
+		}
+		stops = append(stops, func() error {
+			pprof.StopCPUProfile()
+			return file.Close()
+		})
+	}
+
+	if f.BlockProfile != "" {
+		runtime.SetBlockProfileRate(1)
+		stops = append(stops, func() error {
+			defer runtime.SetBlockProfileRate(0)
+			return writeProfile("block", f.BlockProfile)
+		})
+	}
+	if f.MutexProfile != "" {
+		runtime.SetMutexProfileFraction(1)
+		stops = append(stops, func() error {
+			defer runtime.SetMutexProfileFraction(0)
+			return writeProfile("mutex", f.MutexProfile)
+		})
+	}
+	if f.MemProfile != "" {
+		stops = append(stops, func() error {
+			// Collect garbage first so the profile shows live memory.
+			runtime.GC()
+			return writeProfile("heap", f.MemProfile)
+		})
+	}
+
+	if f.Trace != "" {
+		file, err := os.Create(f.Trace)
+		if err != nil {
+			return fail(fmt.Errorf("failed to create trace: %w", err))
+		}
+		if err := trace.Start(file); err != nil {
+			file.Close()
+			return fail(fmt.Errorf("failed to start trace: %w", err))
+		}
+		stops = append(stops, func() error {
+			trace.Stop()
+			return file.Close()
+		})
+	}
+
+	return func() {
+		if err := stopAll(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to write profiles: %v\n", err)
+		}
+	}, nil
+}
+
+func writeProfile(name, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s profile: %w", name, err)
+	}
+	if err := pprof.Lookup(name).WriteTo(file, 0); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s profile: %w", name, err)
+	}
+	return file.Close()
+}
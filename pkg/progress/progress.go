@@ -0,0 +1,248 @@
+// Package progress reports the progress of a long run on a terminal.
+//
+// Workers update Counters with atomic adds at a coarse granularity (per
+// buffered read, chunk or file, never per line), and a Reporter samples them
+// periodically and redraws a single status line.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters is updated by workers. A nil *Counters ignores every update, so
+// workers can report unconditionally when progress is disabled.
+type Counters struct {
+	files  atomic.Int64
+	bytes  atomic.Int64
+	lines  atomic.Int64
+	active atomic.Int64
+
+	skippedFiles atomic.Int64
+	skippedBytes atomic.Int64
+}
+
+// WorkerBusy records that a worker started a piece of work.
+func (c *Counters) WorkerBusy() {
+	if c != nil {
+		c.active.Add(1)
+	}
+}
+
+// WorkerIdle records that a worker finished a piece of work.
+func (c *Counters) WorkerIdle() {
+	if c != nil {
+		c.active.Add(-1)
+	}
+}
+
+// AddFiles records that n files were completed.
+func (c *Counters) AddFiles(n int64) {
+	if c != nil {
+		c.files.Add(n)
+	}
+}
+
+// AddBytes records that n bytes were read.
+func (c *Counters) AddBytes(n int64) {
+	if c != nil {
+		c.bytes.Add(n)
+	}
+}
+
+// AddLines records that n lines were processed.
+func (c *Counters) AddLines(n int64) {
+	if c != nil {
+		c.lines.Add(n)
+	}
+}
+
+// Skip records files that count as done without being processed, for
+// example because an earlier run completed them. They count towards the
+// completion but not towards the rates.
+func (c *Counters) Skip(files, bytes int64) {
+	if c != nil {
+		c.skippedFiles.Add(files)
+		c.skippedBytes.Add(bytes)
+	}
+}
+
+// Snapshot is the state of Counters at one point in time.
+type Snapshot struct {
+	Files, Bytes, Lines int64
+	Active              int64 // workers currently busy
+
+	SkippedFiles, SkippedBytes int64
+}
+
+// Snapshot returns the current counts.
+func (c *Counters) Snapshot() Snapshot {
+	if c == nil {
+		return Snapshot{}
+	}
+	return Snapshot{
+		Files:  c.files.Load(),
+		Bytes:  c.bytes.Load(),
+		Lines:  c.lines.Load(),
+		Active: c.active.Load(),
+
+		SkippedFiles: c.skippedFiles.Load(),
+		SkippedBytes: c.skippedBytes.Load(),
+	}
+}
+
+// Reader returns r counting the bytes read from it into c, or r itself if
+// c is nil.
+func Reader(r io.Reader, c *Counters) io.Reader {
+	if c == nil {
+		return r
+	}
+	return &countingReader{r: r, c: c}
+}
+
+type countingReader struct {
+	r io.Reader
+	c *Counters
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.c.bytes.Add(int64(n))
+	return n, err
+}
+
+// IsTerminal reports whether f is a terminal, so that progress is not
+// written into redirected output.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Total is the amount of work of a run, used for percentages and the ETA.
+// Bytes may be zero if unknown, in which case files are used instead.
+type Total struct {
+	Files int
+	Bytes int64
+}
+
+// Reporter redraws a status line from Counters until stopped.
+type Reporter struct {
+	w        io.Writer
+	c        *Counters
+	total    Total
+	interval time.Duration
+	start    time.Time
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Start begins reporting c to w every interval. It returns nil, which is
+// safe to Stop, if c is nil.
+func Start(w io.Writer, c *Counters, total Total, interval time.Duration) *Reporter {
+	if c == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = 200 * time.Millisecond
+	}
+	r := &Reporter{
+		w:        w,
+		c:        c,
+		total:    total,
+		interval: interval,
+		start:    time.Now(),
+		stop:     make(chan struct{}),
+	}
+	r.wg.Go(r.loop)
+	return r
+}
+
+// Stop draws the final state and ends the status line.
+func (r *Reporter) Stop() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	r.wg.Wait()
+}
+
+func (r *Reporter) loop() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	prev, prevTime := r.c.Snapshot(), r.start
+	for {
+		select {
+		case <-r.stop:
+			// The final line shows averages over the whole run.
+			cur, elapsed := r.c.Snapshot(), time.Since(r.start)
+			r.draw(cur, rate(Snapshot{}, cur, elapsed), elapsed)
+			fmt.Fprintln(r.w)
+			return
+		case now := <-ticker.C:
+			cur := r.c.Snapshot()
+			// Rates over the last interval react to changes; the ETA uses
+			// the average since the start, which is steadier.
+			r.draw(cur, rate(prev, cur, now.Sub(prevTime)), now.Sub(r.start))
+			prev, prevTime = cur, now
+		}
+	}
+}
+
+// rate returns the per-second change between two snapshots.
+func rate(prev, cur Snapshot, d time.Duration) Snapshot {
+	if d <= 0 {
+		return Snapshot{}
+	}
+	s := d.Seconds()
+	return Snapshot{
+		Files: int64(float64(cur.Files-prev.Files) / s),
+		Bytes: int64(float64(cur.Bytes-prev.Bytes) / s),
+		Lines: int64(float64(cur.Lines-prev.Lines) / s),
+	}
+}
+
+// draw rewrites the status line.
+func (r *Reporter) draw(cur, perSecond Snapshot, elapsed time.Duration) {
+	processed, skipped, total := float64(cur.Files), float64(cur.SkippedFiles), float64(r.total.Files)
+	if r.total.Bytes > 0 {
+		processed, skipped, total = float64(cur.Bytes), float64(cur.SkippedBytes), float64(r.total.Bytes)
+	}
+	fraction := 0.0
+	if total > 0 {
+		fraction = min((processed+skipped)/total, 1)
+	}
+
+	// The ETA extrapolates the average rate of this run; skipped work took
+	// no time.
+	eta := "--"
+	if fraction == 1 {
+		eta = "0s"
+	} else if processed > 0 {
+		remaining := time.Duration(float64(elapsed) * (total - processed - skipped) / processed)
+		eta = remaining.Round(100 * time.Millisecond).String()
+	}
+
+	// \r returns to the start of the line and \x1b[K clears what is left of
+	// a longer previous line.
+	fmt.Fprintf(r.w, "\r[%d/%d files] %5.1f%%  %.1fMB  %s lines/s  %.1fMB/s  %d active  ETA %s\x1b[K",
+		cur.Files+cur.SkippedFiles, r.total.Files, fraction*100, float64(cur.Bytes)/(1024*1024),
+		formatCount(perSecond.Lines), float64(perSecond.Bytes)/(1024*1024), cur.Active, eta)
+}
+
+// formatCount formats n with thousands separators.
+func formatCount(n int64) string {
+	s := fmt.Sprintf("%d", n)
+	out := make([]byte, 0, len(s)+len(s)/3)
+	for i := range len(s) {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			out = append(out, ',')
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
@@ -0,0 +1,193 @@
+// Package sched runs tasks on a fixed set of workers with two strategies:
+// a shared FIFO channel, as in the phase3 worker pool, and per-worker deques
+// with work stealing.
+//
+// With a shared channel the order of the tasks decides how the run ends: if
+// the largest task is dispatched last, every other worker is idle while one
+// worker finishes it. With work stealing each worker owns a deque of tasks
+// and an idle worker takes tasks from the far end of another worker's deque,
+// so small pieces of a large file can be processed by whoever is free.
+package sched
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkerStats describes what one worker did during a run.
+type WorkerStats struct {
+	Tasks    int
+	Steals   int
+	Busy     time.Duration
+	Finished time.Duration // since the start of the run
+}
+
+// Stats describes a run.
+type Stats struct {
+	Elapsed time.Duration
+	Workers []WorkerStats
+}
+
+// Tail returns how long the run went on after the first worker ran out of
+// work, i.e. the time at least one worker was idle waiting for the others.
+func (s Stats) Tail() time.Duration {
+	if len(s.Workers) == 0 {
+		return 0
+	}
+	first := s.Workers[0].Finished
+	for _, w := range s.Workers[1:] {
+		first = min(first, w.Finished)
+	}
+	return s.Elapsed - first
+}
+
+// Steals returns the total number of stolen tasks.
+func (s Stats) Steals() int {
+	total := 0
+	for _, w := range s.Workers {
+		total += w.Steals
+	}
+	return total
+}
+
+// FIFO hands tasks to numWorkers workers through a shared channel in order.
+func FIFO[T any](tasks []T, numWorkers int, work func(worker int, task T)) Stats {
+	numWorkers = max(numWorkers, 1)
+	start := time.Now()
+	stats := Stats{Workers: make([]WorkerStats, numWorkers)}
+
+	jobs := make(chan T, numWorkers)
+	var wg sync.WaitGroup
+	for w := range numWorkers {
+		wg.Go(func() {
+			ws := &stats.Workers[w]
+			for task := range jobs {
+				t := time.Now()
+				work(w, task)
+				ws.Busy += time.Since(t)
+				ws.Tasks++
+			}
+			ws.Finished = time.Since(start)
+		})
+	}
+
+	for _, task := range tasks {
+		jobs <- task
+	}
+	close(jobs)
+	wg.Wait()
+
+	stats.Elapsed = time.Since(start)
+	return stats
+}
+
+// Steal runs one worker per queue. A worker pops tasks from the back of its
+// own deque, so queues should be given in reverse processing order; once it
+// is empty it steals from the front of the other deques. Tasks are not
+// added during the run, so a worker stops when every deque is empty.
+func Steal[T any](queues [][]T, work func(worker int, task T)) Stats {
+	start := time.Now()
+	deques := make([]*Deque[T], len(queues))
+	for i, q := range queues {
+		deques[i] = &Deque[T]{items: q}
+	}
+	stats := Stats{Workers: make([]WorkerStats, len(queues))}
+
+	var wg sync.WaitGroup
+	for w := range deques {
+		wg.Go(func() {
+			ws := &stats.Workers[w]
+			for {
+				task, ok := deques[w].PopBack()
+				if !ok {
+					task, ok = stealFrom(deques, w)
+					if !ok {
+						break
+					}
+					ws.Steals++
+				}
+				t := time.Now()
+				work(w, task)
+				ws.Busy += time.Since(t)
+				ws.Tasks++
+			}
+			ws.Finished = time.Since(start)
+		})
+	}
+	wg.Wait()
+
+	stats.Elapsed = time.Since(start)
+	return stats
+}
+
+// stealFrom takes a task from the longest other deque.
+func stealFrom[T any](deques []*Deque[T], self int) (T, bool) {
+	for {
+		victim, longest := -1, 0
+		for i, d := range deques {
+			if i == self {
+				continue
+			}
+			if n := d.Len(); n > longest {
+				victim, longest = i, n
+			}
+		}
+		if victim < 0 {
+			var zero T
+			return zero, false
+		}
+		if task, ok := deques[victim].StealFront(); ok {
+			return task, true
+		}
+		// The victim emptied its deque in the meantime; look again.
+	}
+}
+
+// Deque is a double-ended queue of tasks. The owner works on the back and
+// thieves take from the front, so they rarely contend for the same tasks.
+type Deque[T any] struct {
+	mu    sync.Mutex
+	items []T
+}
+
+// Len returns the number of queued tasks.
+func (d *Deque[T]) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.items)
+}
+
+// PushBack adds a task at the back.
+func (d *Deque[T]) PushBack(task T) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.items = append(d.items, task)
+}
+
+// PopBack removes the task at the back.
+func (d *Deque[T]) PopBack() (T, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var zero T
+	if len(d.items) == 0 {
+		return zero, false
+	}
+	task := d.items[len(d.items)-1]
+	d.items[len(d.items)-1] = zero
+	d.items = d.items[:len(d.items)-1]
+	return task, true
+}
+
+// StealFront removes the task at the front.
+func (d *Deque[T]) StealFront() (T, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var zero T
+	if len(d.items) == 0 {
+		return zero, false
+	}
+	task := d.items[0]
+	d.items[0] = zero
+	d.items = d.items[1:]
+	return task, true
+}
@@ -0,0 +1,475 @@
+package segment
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// File is an open segment.
+type File struct {
+	Header Header
+
+	r       io.ReaderAt
+	closer  io.Closer
+	offsets map[Column]int64 // of the stored columns
+}
+
+// Open opens the segment name under root and reads its header.
+func Open(root *os.Root, name string) (*File, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFile(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	f.closer = file
+	return f, nil
+}
+
+// NewFile reads the header of the segment in r.
+func NewFile(r io.ReaderAt) (*File, error) {
+	// The header takes a few dozen bytes per column; read its start and the
+	// rest if it is longer.
+	prefix := make([]byte, 512)
+	n, err := r.ReadAt(prefix, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	prefix = prefix[:n]
+	if !bytes.HasPrefix(prefix, magic) {
+		return nil, errors.New("not a segment: bad magic number")
+	}
+	d := decoder{data: prefix[len(magic):]}
+	if v := d.byte(); d.err == nil && v != version {
+		return nil, fmt.Errorf("unsupported segment version %d", v)
+	}
+	length := d.uvarint()
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid segment header: %w", d.err)
+	}
+	start := int64(n - len(d.data)) // of the header
+	header := d.data
+	if uint64(len(header)) < length {
+		if length > 1<<20 {
+			return nil, fmt.Errorf("invalid segment header: %w", errCorrupt)
+		}
+		header = make([]byte, length)
+		if _, err := r.ReadAt(header, start); err != nil {
+			return nil, fmt.Errorf("failed to read segment header: %w", err)
+		}
+	}
+	header = header[:length]
+
+	f := &File{r: r, offsets: make(map[Column]int64)}
+	if err := f.parseHeader(header, start+int64(length)); err != nil {
+		return nil, fmt.Errorf("invalid segment header: %w", err)
+	}
+	return f, nil
+}
+
+func (f *File) parseHeader(data []byte, offset int64) error {
+	d := decoder{data: data}
+	rows := d.uvarint()
+	if rows > maxRows {
+		return fmt.Errorf("%d rows exceed the limit of %d", rows, maxRows)
+	}
+	f.Header.Rows = int(rows)
+	n := d.count()
+	for range n {
+		info := ColumnInfo{Column: Column(d.byte())}
+		size := d.uvarint()
+		info.Min = d.varint()
+		info.Max = d.varint()
+		info.Distinct = int(d.uvarint())
+		checksum := d.bytes(4)
+		if d.err != nil {
+			return d.err
+		}
+		if size > 1<<40 {
+			return errCorrupt
+		}
+		info.Size = int64(size)
+		info.checksum = binary.LittleEndian.Uint32(checksum)
+		f.Header.Columns = append(f.Header.Columns, info)
+		f.offsets[info.Column] = offset
+		offset += info.Size
+	}
+	if d.err != nil {
+		return d.err
+	}
+	for _, c := range stored {
+		if _, ok := f.offsets[c]; !ok {
+			return fmt.Errorf("missing column %s", c)
+		}
+	}
+	return nil
+}
+
+// Close closes the file opened by Open.
+func (f *File) Close() error {
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+// column reads and checks the data of a stored column.
+func (f *File) column(c Column) ([]byte, error) {
+	info, _ := f.Header.Column(c)
+	data := make([]byte, info.Size)
+	if _, err := f.r.ReadAt(data, f.offsets[c]); err != nil {
+		return nil, fmt.Errorf("failed to read column %s: %w", c, err)
+	}
+	if crc32.ChecksumIEEE(data) != info.checksum {
+		return nil, fmt.Errorf("column %s: checksum mismatch", c)
+	}
+	return data, nil
+}
+
+// Size returns the number of bytes Read reads for columns.
+func (f *File) Size(columns Columns) int64 {
+	var size int64
+	for _, info := range f.Header.Columns {
+		switch c := info.Column; {
+		case c == pathIDs && columns.Has(Path),
+			c == Path && (columns.Has(Path) || columns.Has(PathTemplate)),
+			c != pathIDs && c != Path && columns.Has(c):
+			size += info.Size
+		}
+	}
+	return size
+}
+
+// Batch holds the decoded columns of a segment. Only the slices of the
+// columns that were read are set.
+type Batch struct {
+	Rows    int
+	Columns Columns
+
+	Timestamps    []int64 // Unix nanoseconds
+	Methods       []string
+	Paths         []string
+	PathTemplates []string
+	Statuses      []int
+	ResponseTimes []int
+	Bytes         []int
+	UserIDs       []string
+	IPs           []string
+
+	// timestamps holds the text of the timestamps that are not RFC 3339
+	// times in UTC, by row.
+	timestamps map[int]string
+}
+
+// Read decodes the given columns of every row.
+func (f *File) Read(columns Columns) (*Batch, error) {
+	b := &Batch{Rows: f.Header.Rows, Columns: columns & All}
+	for _, c := range []Column{Timestamp, Method, Path, PathTemplate, Status, ResponseTime, Bytes, UserID, IP} {
+		if !columns.Has(c) {
+			continue
+		}
+		if c == PathTemplate && columns.Has(Path) {
+			// Decoded along with the paths.
+			continue
+		}
+		source := c
+		if c == PathTemplate {
+			source = Path
+		}
+		data, err := f.column(source)
+		if err != nil {
+			return nil, err
+		}
+		d := decoder{data: data}
+		if err := b.decode(c, &d, f); err != nil {
+			return nil, err
+		}
+		if d.err != nil {
+			return nil, fmt.Errorf("column %s: %w", c, d.err)
+		}
+	}
+	return b, nil
+}
+
+func (b *Batch) decode(c Column, d *decoder, f *File) error {
+	switch c {
+	case Timestamp:
+		b.Timestamps, b.timestamps = d.timestamps(b.Rows)
+	case Method:
+		b.Methods = d.strings(b.Rows)
+	case Path, PathTemplate:
+		dict, codes := d.paths(b.Rows)
+		if d.err != nil {
+			return nil
+		}
+		if b.Columns.Has(PathTemplate) {
+			b.PathTemplates = make([]string, b.Rows)
+			for i, code := range codes {
+				b.PathTemplates[i] = dict[code].template
+			}
+		}
+		if c == Path {
+			data, err := f.column(pathIDs)
+			if err != nil {
+				return err
+			}
+			ids := decoder{data: data}
+			b.Paths = buildPaths(dict, codes, &ids)
+			if ids.err != nil {
+				return fmt.Errorf("column %s: %w", pathIDs, ids.err)
+			}
+		}
+	case Status:
+		b.Statuses = d.statuses(b.Rows)
+	case ResponseTime:
+		b.ResponseTimes = d.numbers(b.Rows)
+	case Bytes:
+		b.Bytes = d.numbers(b.Rows)
+	case UserID:
+		b.UserIDs = d.strings(b.Rows)
+	case IP:
+		b.IPs = d.strings(b.Rows)
+	}
+	return nil
+}
+
+// Timestamp returns the timestamp of row i as it was written.
+func (b *Batch) Timestamp(i int) string {
+	if s, ok := b.timestamps[i]; ok {
+		return s
+	}
+	return formatTimestamp(b.Timestamps[i])
+}
+
+// UnixNano returns the timestamp of row i in Unix nanoseconds, or false if
+// it is not a valid RFC 3339 time.
+func (b *Batch) UnixNano(i int) (int64, bool) {
+	s, ok := b.timestamps[i]
+	if !ok {
+		return b.Timestamps[i], true
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, false
+	}
+	return t.UnixNano(), true
+}
+
+// Entry sets entry to row i. Fields of columns that were not read are
+// left empty. If the templates were read but not the paths, entry.Path is
+// the template, which logparser.PathTemplate leaves unchanged.
+func (b *Batch) Entry(i int, entry *logparser.LogEntry) {
+	*entry = logparser.LogEntry{}
+	if b.Columns.Has(Timestamp) {
+		entry.Timestamp = b.Timestamp(i)
+	}
+	if b.Columns.Has(Method) {
+		entry.Method = b.Methods[i]
+	}
+	if b.Columns.Has(Path) {
+		entry.Path = b.Paths[i]
+	} else if b.Columns.Has(PathTemplate) {
+		entry.Path = b.PathTemplates[i]
+	}
+	if b.Columns.Has(Status) {
+		entry.Status = b.Statuses[i]
+	}
+	if b.Columns.Has(ResponseTime) {
+		entry.ResponseTimeMs = b.ResponseTimes[i]
+	}
+	if b.Columns.Has(Bytes) {
+		entry.Bytes = b.Bytes[i]
+	}
+	if b.Columns.Has(UserID) {
+		entry.UserID = b.UserIDs[i]
+	}
+	if b.Columns.Has(IP) {
+		entry.IP = b.IPs[i]
+	}
+}
+
+// codes reads n dictionary codes below size.
+func (d *decoder) codes(n, size int) []int64 {
+	codes := d.ints(n)
+	for _, code := range codes {
+		if code < 0 || code >= int64(size) {
+			d.fail()
+			return nil
+		}
+	}
+	return codes
+}
+
+func (d *decoder) strings(n int) []string {
+	dict := make([]string, d.count())
+	for i := range dict {
+		dict[i] = string(d.bytes(d.uvarint()))
+	}
+	codes := d.codes(n, len(dict))
+	if d.err != nil {
+		return nil
+	}
+	values := make([]string, n)
+	for i, code := range codes {
+		values[i] = dict[code]
+	}
+	return values
+}
+
+func (d *decoder) statuses(n int) []int {
+	dict := make([]int, d.count())
+	for i := range dict {
+		dict[i] = int(d.varint())
+	}
+	codes := d.codes(n, len(dict))
+	if d.err != nil {
+		return nil
+	}
+	values := make([]int, n)
+	for i, code := range codes {
+		values[i] = dict[code]
+	}
+	return values
+}
+
+func (d *decoder) numbers(n int) []int {
+	ints := d.ints(n)
+	if d.err != nil {
+		return nil
+	}
+	values := make([]int, n)
+	for i, v := range ints {
+		values[i] = int(v)
+	}
+	return values
+}
+
+func (d *decoder) timestamps(n int) ([]int64, map[int]string) {
+	scale := int64(d.uvarint())
+	if scale < 1 {
+		d.fail()
+		return nil, nil
+	}
+	var exceptions map[int]string
+	row := 0
+	for i := range d.count() {
+		if i == 0 {
+			exceptions = make(map[int]string)
+		}
+		row += int(d.uvarint())
+		exceptions[row] = string(d.bytes(d.uvarint()))
+		if row >= n {
+			d.fail()
+		}
+	}
+	start := d.varint()
+	deltas := d.ints(max(n-1, 0))
+	if d.err != nil {
+		return nil, nil
+	}
+
+	values := make([]int64, n)
+	v := start
+	for i := range values {
+		if i > 0 {
+			v += deltas[i-1]
+		}
+		values[i] = v * scale
+	}
+	return values, exceptions
+}
+
+// pathEntry is an entry of the path dictionary.
+type pathEntry struct {
+	template string
+	// parts are the pieces of a template path around its IDs, or the
+	// literal path.
+	parts []string
+}
+
+func (d *decoder) paths(n int) ([]pathEntry, []int64) {
+	dict := make([]pathEntry, d.count())
+	for i := range dict {
+		kind := d.byte()
+		value := string(d.bytes(d.uvarint()))
+		switch kind {
+		case literalPath:
+			dict[i] = pathEntry{template: logparser.PathTemplate(value), parts: []string{value}}
+		case templatePath:
+			dict[i] = pathEntry{template: value, parts: splitTemplate(value)}
+		default:
+			d.fail()
+		}
+	}
+	codes := d.codes(n, len(dict))
+	if d.err != nil {
+		return nil, nil
+	}
+	return dict, codes
+}
+
+// splitTemplate splits a template around its {id} segments, keeping the
+// slashes with the other parts.
+func splitTemplate(template string) []string {
+	segments := strings.Split(template, "/")
+	parts := []string{""}
+	for i, segment := range segments {
+		if i > 0 {
+			parts[len(parts)-1] += "/"
+		}
+		if segment == "{id}" {
+			parts = append(parts, "")
+			continue
+		}
+		parts[len(parts)-1] += segment
+	}
+	return parts
+}
+
+// buildPaths puts the IDs of d back into the template paths.
+func buildPaths(dict []pathEntry, codes []int64, d *decoder) []string {
+	var want uint64
+	for _, code := range codes {
+		want += uint64(len(dict[code].parts) - 1)
+	}
+	if d.uvarint() != want {
+		d.fail()
+	}
+	ids := d.ints(int(want))
+	if d.err != nil {
+		return nil
+	}
+	paths := make([]string, len(codes))
+	var sb strings.Builder
+	next := 0
+	for i, code := range codes {
+		parts := dict[code].parts
+		if len(parts) == 1 {
+			paths[i] = parts[0]
+			continue
+		}
+		sb.Reset()
+		for j, part := range parts {
+			if j > 0 {
+				sb.WriteString(strconv.FormatUint(uint64(ids[next]), 10))
+				next++
+			}
+			sb.WriteString(part)
+		}
+		paths[i] = sb.String()
+	}
+	return paths
+}
@@ -0,0 +1,300 @@
+package segment
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// Kinds of path dictionary entries.
+const (
+	literalPath  = 0 // the path as is
+	templatePath = 1 // a template whose {id} segments take the next IDs
+)
+
+// Encode returns the segment of entries.
+func Encode(entries []logparser.LogEntry) []byte {
+	columns := make([]ColumnInfo, len(stored))
+	blobs := make([][]byte, len(stored))
+	for i, c := range stored {
+		columns[i].Column = c
+		blobs[i] = encodeColumn(entries, c, &columns[i])
+		columns[i].Size = int64(len(blobs[i]))
+		columns[i].checksum = crc32.ChecksumIEEE(blobs[i])
+	}
+
+	var header []byte
+	header = binary.AppendUvarint(header, uint64(len(entries)))
+	header = binary.AppendUvarint(header, uint64(len(columns)))
+	for _, info := range columns {
+		header = append(header, byte(info.Column))
+		header = binary.AppendUvarint(header, uint64(info.Size))
+		header = binary.AppendVarint(header, info.Min)
+		header = binary.AppendVarint(header, info.Max)
+		header = binary.AppendUvarint(header, uint64(info.Distinct))
+		header = binary.LittleEndian.AppendUint32(header, info.checksum)
+	}
+
+	buf := append([]byte(nil), magic...)
+	buf = append(buf, version)
+	buf = binary.AppendUvarint(buf, uint64(len(header)))
+	buf = append(buf, header...)
+	for _, blob := range blobs {
+		buf = append(buf, blob...)
+	}
+	return buf
+}
+
+func encodeColumn(entries []logparser.LogEntry, c Column, info *ColumnInfo) []byte {
+	switch c {
+	case Timestamp:
+		return encodeTimestamps(entries, info)
+	case Method:
+		return encodeStrings(entries, info, func(e *logparser.LogEntry) string { return e.Method })
+	case Path:
+		return encodePaths(entries, info)
+	case pathIDs:
+		return encodePathIDs(entries, info)
+	case Status:
+		return encodeStatuses(entries, info)
+	case ResponseTime:
+		return encodeNumbers(entries, info, func(e *logparser.LogEntry) int { return e.ResponseTimeMs })
+	case Bytes:
+		return encodeNumbers(entries, info, func(e *logparser.LogEntry) int { return e.Bytes })
+	case UserID:
+		return encodeStrings(entries, info, func(e *logparser.LogEntry) string { return e.UserID })
+	case IP:
+		return encodeStrings(entries, info, func(e *logparser.LogEntry) string { return e.IP })
+	}
+	panic("segment: no encoding for column " + c.String())
+}
+
+func formatTimestamp(ns int64) string {
+	return time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+}
+
+// encodeTimestamps writes the timestamps as the bit-packed differences
+// between consecutive rows, in the coarsest unit that keeps every value,
+// such as milliseconds. Timestamps that do not round-trip through Unix
+// nanoseconds are kept as text; their rows repeat the previous value.
+//
+//	scale exceptions first deltas
+func encodeTimestamps(entries []logparser.LogEntry, info *ColumnInfo) []byte {
+	values := make([]int64, len(entries))
+	valid := make([]bool, len(entries))
+	var exceptions []int
+	first := true
+	for i := range entries {
+		t, err := time.Parse(time.RFC3339Nano, entries[i].Timestamp)
+		if err == nil {
+			ns := t.UnixNano()
+			if first {
+				info.Min, info.Max, first = ns, ns, false
+			}
+			info.Min, info.Max = min(info.Min, ns), max(info.Max, ns)
+			values[i], valid[i] = ns, formatTimestamp(ns) == entries[i].Timestamp
+		}
+		if !valid[i] {
+			exceptions = append(exceptions, i)
+		}
+	}
+
+	// Exceptions take the value of the previous row, or of the first row
+	// that is not one, so that they add nothing to the deltas.
+	previous := int64(0)
+	if i := slices.Index(valid, true); i >= 0 {
+		previous = values[i]
+	}
+	for i := range values {
+		if !valid[i] {
+			values[i] = previous
+		}
+		previous = values[i]
+	}
+
+	scale := int64(1)
+	for scale < int64(time.Second) && allMultiples(values, scale*10) {
+		scale *= 10
+	}
+	buf := binary.AppendUvarint(nil, uint64(scale))
+
+	buf = binary.AppendUvarint(buf, uint64(len(exceptions)))
+	last := 0
+	for _, row := range exceptions {
+		buf = binary.AppendUvarint(buf, uint64(row-last))
+		buf = binary.AppendUvarint(buf, uint64(len(entries[row].Timestamp)))
+		buf = append(buf, entries[row].Timestamp...)
+		last = row
+	}
+
+	var start int64
+	if len(values) > 0 {
+		start = values[0] / scale
+	}
+	buf = binary.AppendVarint(buf, start)
+	deltas := make([]int64, max(len(values)-1, 0))
+	for i := range deltas {
+		deltas[i] = values[i+1]/scale - values[i]/scale
+	}
+	return appendInts(buf, deltas)
+}
+
+func allMultiples(values []int64, n int64) bool {
+	for _, v := range values {
+		if v%n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// dictionary assigns codes to values in order of first appearance.
+type dictionary[T comparable] struct {
+	codes  map[T]int64
+	values []T
+}
+
+func (d *dictionary[T]) code(v T) int64 {
+	if d.codes == nil {
+		d.codes = make(map[T]int64)
+	}
+	code, ok := d.codes[v]
+	if !ok {
+		code = int64(len(d.values))
+		d.codes[v] = code
+		d.values = append(d.values, v)
+	}
+	return code
+}
+
+// encodeStrings writes a dictionary column:
+//
+//	count (length value)... codes
+func encodeStrings(entries []logparser.LogEntry, info *ColumnInfo, field func(*logparser.LogEntry) string) []byte {
+	var dict dictionary[string]
+	codes := make([]int64, len(entries))
+	for i := range entries {
+		codes[i] = dict.code(field(&entries[i]))
+	}
+	info.Distinct = len(dict.values)
+
+	buf := binary.AppendUvarint(nil, uint64(len(dict.values)))
+	for _, v := range dict.values {
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	}
+	return appendInts(buf, codes)
+}
+
+// encodeStatuses writes the status codes like encodeStrings, with varint
+// values.
+func encodeStatuses(entries []logparser.LogEntry, info *ColumnInfo) []byte {
+	var dict dictionary[int]
+	codes := make([]int64, len(entries))
+	for i := range entries {
+		codes[i] = dict.code(entries[i].Status)
+	}
+	info.Distinct = len(dict.values)
+	for i, v := range dict.values {
+		if i == 0 {
+			info.Min, info.Max = int64(v), int64(v)
+		}
+		info.Min, info.Max = min(info.Min, int64(v)), max(info.Max, int64(v))
+	}
+
+	buf := binary.AppendUvarint(nil, uint64(len(dict.values)))
+	for _, v := range dict.values {
+		buf = binary.AppendVarint(buf, int64(v))
+	}
+	return appendInts(buf, codes)
+}
+
+// encodeNumbers writes a numeric column with appendInts.
+func encodeNumbers(entries []logparser.LogEntry, info *ColumnInfo, field func(*logparser.LogEntry) int) []byte {
+	values := make([]int64, len(entries))
+	for i := range entries {
+		values[i] = int64(field(&entries[i]))
+		if i == 0 {
+			info.Min, info.Max = values[i], values[i]
+		}
+		info.Min, info.Max = min(info.Min, values[i]), max(info.Max, values[i])
+	}
+	return appendInts(nil, values)
+}
+
+// splitPath returns the template of a path and its IDs, or false if the
+// path cannot be rebuilt from them: when a segment is literally {id} or
+// an ID does not format back to the same digits, as with leading zeros.
+func splitPath(path string) (string, []uint64, bool) {
+	template := logparser.PathTemplate(path)
+	if template == path {
+		return path, nil, !strings.Contains(path, "{id}")
+	}
+	var ids []uint64
+	for segment := range strings.SplitSeq(path, "/") {
+		if segment == "{id}" {
+			return "", nil, false
+		}
+		if logparser.PathTemplate(segment) != "{id}" {
+			continue
+		}
+		id, err := strconv.ParseUint(segment, 10, 64)
+		if err != nil || strconv.FormatUint(id, 10) != segment {
+			return "", nil, false
+		}
+		ids = append(ids, id)
+	}
+	return template, ids, true
+}
+
+// pathKey is an entry of the path dictionary.
+type pathKey struct {
+	kind  byte
+	value string
+}
+
+// encodePaths writes the paths as a dictionary of templates, or of literal
+// paths for those that splitPath rejects. The IDs go to the pathIDs column.
+//
+//	count (kind length value)... codes
+func encodePaths(entries []logparser.LogEntry, info *ColumnInfo) []byte {
+	var dict dictionary[pathKey]
+	codes := make([]int64, len(entries))
+	for i := range entries {
+		key := pathKey{kind: literalPath, value: entries[i].Path}
+		if template, _, ok := splitPath(entries[i].Path); ok {
+			key = pathKey{kind: templatePath, value: template}
+		}
+		codes[i] = dict.code(key)
+	}
+	info.Distinct = len(dict.values)
+
+	buf := binary.AppendUvarint(nil, uint64(len(dict.values)))
+	for _, key := range dict.values {
+		buf = append(buf, key.kind)
+		buf = binary.AppendUvarint(buf, uint64(len(key.value)))
+		buf = append(buf, key.value...)
+	}
+	return appendInts(buf, codes)
+}
+
+// encodePathIDs writes the IDs of the template paths in row order:
+//
+//	count ids
+func encodePathIDs(entries []logparser.LogEntry, info *ColumnInfo) []byte {
+	var ids []int64
+	for i := range entries {
+		if _, pathIDs, ok := splitPath(entries[i].Path); ok {
+			for _, id := range pathIDs {
+				ids = append(ids, int64(id))
+			}
+		}
+	}
+	buf := binary.AppendUvarint(nil, uint64(len(ids)))
+	return appendInts(buf, ids)
+}
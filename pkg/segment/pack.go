@@ -0,0 +1,159 @@
+package segment
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+var errCorrupt = errors.New("corrupt segment")
+
+// appendInts appends values with frame-of-reference bit-packing: the
+// minimum as a varint, the bit width of the largest offset from it, and
+// every offset in that many bits.
+func appendInts(buf []byte, values []int64) []byte {
+	var base int64
+	if len(values) > 0 {
+		base = values[0]
+		for _, v := range values {
+			base = min(base, v)
+		}
+	}
+	var width int
+	for _, v := range values {
+		width = max(width, bits.Len64(uint64(v-base)))
+	}
+	buf = binary.AppendVarint(buf, base)
+	buf = append(buf, byte(width))
+
+	var acc uint64 // pending bits, least significant first
+	var filled int
+	for _, v := range values {
+		u := uint64(v - base)
+		acc |= u << filled
+		if filled+width >= 64 {
+			buf = binary.LittleEndian.AppendUint64(buf, acc)
+			// The bits of u that did not fit; shifting by 64 yields 0 in Go.
+			acc = u >> (64 - filled)
+			filled = filled + width - 64
+		} else {
+			filled += width
+		}
+	}
+	for ; filled > 0; filled -= 8 {
+		buf = append(buf, byte(acc))
+		acc >>= 8
+	}
+	return buf
+}
+
+// packedSize returns the size of n values of width bits after the header.
+func packedSize(n, width int) int {
+	return (n*width + 7) / 8
+}
+
+// decoder reads the fields of a column.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errCorrupt
+	}
+	d.data = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) < 1 {
+		d.fail()
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if uint64(len(d.data)) < n {
+		d.fail()
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+// count reads a number of items, each taking at least one byte, which
+// bounds it by the remaining data before anything is allocated.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// ints reads n values written by appendInts.
+func (d *decoder) ints(n int) []int64 {
+	base := d.varint()
+	width := int(d.byte())
+	if width > 64 {
+		d.fail()
+	}
+	packed := d.bytes(uint64(packedSize(n, width)))
+	if d.err != nil {
+		return nil
+	}
+
+	values := make([]int64, n)
+	if width == 0 {
+		for i := range values {
+			values[i] = base
+		}
+		return values
+	}
+	mask := uint64(1)<<width - 1 // all ones for a width of 64
+	bit := 0
+	for i := range values {
+		// Gather the up to 9 bytes that hold the value.
+		byteIndex, shift := bit/8, bit%8
+		var word uint64
+		if byteIndex+8 <= len(packed) {
+			word = binary.LittleEndian.Uint64(packed[byteIndex:])
+		} else {
+			for k := 0; byteIndex+k < len(packed); k++ {
+				word |= uint64(packed[byteIndex+k]) << (8 * k)
+			}
+		}
+		u := word >> shift
+		if shift+width > 64 && byteIndex+8 < len(packed) {
+			u |= uint64(packed[byteIndex+8]) << (64 - shift)
+		}
+		values[i] = base + int64(u&mask)
+		bit += width
+	}
+	return values
+}
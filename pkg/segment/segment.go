@@ -0,0 +1,129 @@
+// Package segment stores access logs in a compact columnar format, so that
+// analyses run repeatedly over the same logs neither parse JSON nor read the
+// fields they do not use.
+//
+// A segment holds the entries of one log file, column by column:
+//
+//	"LSEG" version headerLength header column...
+//
+// The header gives the number of rows and, for every column, its size, a
+// checksum and statistics: the minimum and maximum of numeric columns and
+// the number of distinct values of dictionary columns. Readers read the
+// header, then only the columns a query asks for.
+//
+// Timestamps are delta-encoded, methods, status codes, users, IPs and path
+// templates are dictionary-encoded, and numbers are bit-packed relative to
+// the minimum of their column. Decoding gives back the values of
+// logparser.LogEntry exactly, including timestamps and paths that do not
+// follow the usual formats.
+package segment
+
+import "strconv"
+
+// Ext is the file extension of segments.
+const Ext = ".seg"
+
+// magic starts every segment.
+var magic = []byte("LSEG")
+
+// version is the version of the format. Readers reject any other version,
+// so it must change whenever the format does.
+const version = 1
+
+// maxRows bounds the rows of a segment, so that a corrupt header cannot
+// make readers allocate without limit.
+const maxRows = 1 << 31
+
+// Column is a field of the log entries.
+type Column uint8
+
+// Columns of a segment. PathTemplate is not stored: it decodes the
+// templates of the path dictionary without the IDs of the paths, which is
+// all that analyses grouping by endpoint need.
+const (
+	Timestamp Column = iota
+	Method
+	Path
+	PathTemplate
+	Status
+	ResponseTime
+	Bytes
+	UserID
+	IP
+
+	// pathIDs holds the numeric segments of the paths, in row order.
+	pathIDs
+)
+
+// stored lists the columns written to a segment, in file order.
+var stored = []Column{Timestamp, Method, Path, pathIDs, Status, ResponseTime, Bytes, UserID, IP}
+
+var columnNames = [...]string{
+	Timestamp:    "timestamp",
+	Method:       "method",
+	Path:         "path",
+	PathTemplate: "path_template",
+	Status:       "status",
+	ResponseTime: "response_time_ms",
+	Bytes:        "bytes",
+	UserID:       "user_id",
+	IP:           "ip",
+	pathIDs:      "path_ids",
+}
+
+func (c Column) String() string {
+	if int(c) < len(columnNames) {
+		return columnNames[c]
+	}
+	return "column(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Columns is a set of columns.
+type Columns uint16
+
+// All is the set of every column.
+const All = Columns(1<<Timestamp | 1<<Method | 1<<Path | 1<<PathTemplate |
+	1<<Status | 1<<ResponseTime | 1<<Bytes | 1<<UserID | 1<<IP)
+
+// Of returns the set of the given columns.
+func Of(columns ...Column) Columns {
+	var set Columns
+	for _, c := range columns {
+		set |= 1 << c
+	}
+	return set
+}
+
+// Has reports whether c is in the set.
+func (s Columns) Has(c Column) bool {
+	return s&(1<<c) != 0
+}
+
+// ColumnInfo describes a stored column.
+type ColumnInfo struct {
+	Column Column
+	Size   int64 // bytes
+	// Min and Max are the smallest and largest values of numeric columns:
+	// Unix nanoseconds for Timestamp, ignoring timestamps that are not
+	// valid RFC 3339 times. Both are zero for an empty column.
+	Min, Max int64
+	// Distinct is the number of distinct values of dictionary columns.
+	Distinct int
+	checksum uint32
+}
+
+// Header is the header of a segment.
+type Header struct {
+	Rows    int
+	Columns []ColumnInfo
+}
+
+// Column returns the description of a stored column.
+func (h *Header) Column(c Column) (ColumnInfo, bool) {
+	for _, info := range h.Columns {
+		if info.Column == c {
+			return info, true
+		}
+	}
+	return ColumnInfo{}, false
+}
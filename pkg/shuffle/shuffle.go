@@ -0,0 +1,135 @@
+// Package shuffle implements an in-process map/shuffle/reduce stage.
+//
+// Mappers emit key/value records, which are routed by a hash of the key to
+// one of N reducer goroutines. Every key is always routed to the same
+// reducer, so each reducer owns a disjoint part of the key space and can
+// aggregate it without locks and without any other reducer holding a copy.
+// Records are sent in pooled batches to amortize the cost of channel
+// operations.
+package shuffle
+
+import (
+	"hash/maphash"
+	"iter"
+	"sync"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/batch"
+)
+
+// Record is a key/value pair routed through a Shuffle.
+type Record[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
+// Config configures a Shuffle.
+type Config struct {
+	// Partitions is the number of reducer goroutines.
+	Partitions int
+	// BatchSize is the number of records an Emitter buffers per partition
+	// before sending them to the reducer.
+	BatchSize int
+	// Buffer is the capacity, in batches, of each reducer's channel.
+	Buffer int
+}
+
+// DefaultConfig returns a Config with n partitions.
+func DefaultConfig(n int) Config {
+	return Config{Partitions: n, BatchSize: 256, Buffer: 4}
+}
+
+// Shuffle routes records from any number of Emitters to its reducers.
+type Shuffle[K comparable, V any] struct {
+	seed       maphash.Seed
+	pool       *batch.Pool[Record[K, V]]
+	partitions []chan []Record[K, V]
+	wg         sync.WaitGroup
+}
+
+// New starts cfg.Partitions reducers. reduce is called once per partition
+// in its own goroutine and receives every record routed to that partition;
+// it returns when the Shuffle is closed and the records are exhausted.
+func New[K comparable, V any](cfg Config, reduce func(partition int, records iter.Seq[Record[K, V]])) *Shuffle[K, V] {
+	s := &Shuffle[K, V]{
+		seed:       maphash.MakeSeed(),
+		pool:       batch.NewPool[Record[K, V]](cfg.BatchSize),
+		partitions: make([]chan []Record[K, V], max(cfg.Partitions, 1)),
+	}
+
+	for i := range s.partitions {
+		ch := make(chan []Record[K, V], cfg.Buffer)
+		s.partitions[i] = ch
+		s.wg.Go(func() {
+			reduce(i, func(yield func(Record[K, V]) bool) {
+				for records := range ch {
+					for _, record := range records {
+						if !yield(record) {
+							// Keep draining so that emitters never block.
+							for range ch {
+							}
+							return
+						}
+					}
+					s.pool.Put(records)
+				}
+			})
+		})
+	}
+	return s
+}
+
+// Partition returns the partition that owns key.
+func (s *Shuffle[K, V]) Partition(key K) int {
+	return int(maphash.Comparable(s.seed, key) % uint64(len(s.partitions)))
+}
+
+// NewEmitter returns an Emitter for one mapper goroutine.
+func (s *Shuffle[K, V]) NewEmitter() *Emitter[K, V] {
+	return &Emitter[K, V]{
+		shuffle: s,
+		batches: make([][]Record[K, V], len(s.partitions)),
+	}
+}
+
+// Close waits for the reducers to consume every record. All Emitters must
+// have been flushed before Close is called.
+func (s *Shuffle[K, V]) Close() {
+	for _, ch := range s.partitions {
+		close(ch)
+	}
+	s.wg.Wait()
+}
+
+// Emitter buffers records per partition. It is not safe for concurrent use;
+// each mapper goroutine should have its own.
+type Emitter[K comparable, V any] struct {
+	shuffle *Shuffle[K, V]
+	batches [][]Record[K, V]
+}
+
+// Emit routes a record to the partition that owns key.
+func (e *Emitter[K, V]) Emit(key K, value V) {
+	p := e.shuffle.Partition(key)
+	if e.batches[p] == nil {
+		e.batches[p] = e.shuffle.pool.Get()
+	}
+	e.batches[p] = append(e.batches[p], Record[K, V]{Key: key, Value: value})
+	if len(e.batches[p]) == e.shuffle.pool.Size() {
+		e.send(p)
+	}
+}
+
+// Flush sends every buffered record.
+func (e *Emitter[K, V]) Flush() {
+	for p, records := range e.batches {
+		if len(records) > 0 {
+			e.send(p)
+		}
+	}
+}
+
+func (e *Emitter[K, V]) send(p int) {
+	e.shuffle.partitions[p] <- e.batches[p]
+	// The reducer owns the sent slice now and returns it to the pool.
+	e.batches[p] = nil
+}
@@ -0,0 +1,279 @@
+package slo
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// minuteCounts are the requests of one objective in one minute.
+type minuteCounts struct {
+	Total  int
+	Errors int // 5xx responses
+	Slow   int // responses not faster than LatencyMs
+}
+
+// Tracker counts the requests of every objective per minute. Trackers of
+// disjoint sets of entries, such as different files, can be merged.
+type Tracker struct {
+	cfg         *Config
+	minutes     []map[int64]*minuteCounts // per objective, by Unix minute
+	first, last int64
+	seen        bool
+}
+
+// NewTracker creates an empty Tracker for the objectives of cfg.
+func NewTracker(cfg *Config) *Tracker {
+	t := &Tracker{cfg: cfg, minutes: make([]map[int64]*minuteCounts, len(cfg.Objectives))}
+	for i := range t.minutes {
+		t.minutes[i] = make(map[int64]*minuteCounts)
+	}
+	return t
+}
+
+// Add counts a log entry. Every entry extends the evaluated period, even
+// if no objective covers it.
+func (t *Tracker) Add(entry *logparser.LogEntry) error {
+	at, err := time.Parse(time.RFC3339Nano, entry.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %w", err)
+	}
+	minute := at.Unix() / 60
+	t.extend(minute, minute)
+
+	template := logparser.PathTemplate(entry.Path)
+	for i := range t.cfg.Objectives {
+		o := &t.cfg.Objectives[i]
+		if !o.matches(entry.Method, template) {
+			continue
+		}
+		c := t.minutes[i][minute]
+		if c == nil {
+			c = &minuteCounts{}
+			t.minutes[i][minute] = c
+		}
+		c.Total++
+		if entry.Status >= 500 && entry.Status < 600 {
+			c.Errors++
+		}
+		if o.LatencyMs > 0 && entry.ResponseTimeMs >= o.LatencyMs {
+			c.Slow++
+		}
+	}
+	return nil
+}
+
+// Merge adds the counts of other, which must track the same Config, to t.
+func (t *Tracker) Merge(other *Tracker) {
+	if other.seen {
+		t.extend(other.first, other.last)
+	}
+	for i, minutes := range other.minutes {
+		for minute, oc := range minutes {
+			c := t.minutes[i][minute]
+			if c == nil {
+				c = &minuteCounts{}
+				t.minutes[i][minute] = c
+			}
+			c.Total += oc.Total
+			c.Errors += oc.Errors
+			c.Slow += oc.Slow
+		}
+	}
+}
+
+func (t *Tracker) extend(first, last int64) {
+	if !t.seen || first < t.first {
+		t.first = first
+	}
+	if !t.seen || last > t.last {
+		t.last = last
+	}
+	t.seen = true
+}
+
+// Indicator is the evaluation of one SLI of an objective over the period
+// of the logs.
+type Indicator struct {
+	Objective string
+	Name      string  // "availability" or "latency < Nms"
+	Target    float64 // fraction of good requests, e.g. 0.999
+	Total     int
+	Bad       int
+	// Compliance is the fraction of good requests, 1 without requests.
+	Compliance float64
+	// BudgetRemaining is the fraction of the error budget left: 1 if no
+	// request was bad, negative once the budget is exceeded.
+	BudgetRemaining float64
+	// BurnRate is the average burn rate over the whole period.
+	BurnRate float64
+	// Current is the burn rate of every alert window ending with the logs.
+	Current []WindowBurn
+	Alerts  []AlertStatus
+}
+
+// Met reports whether the objective is met over the period.
+func (ind *Indicator) Met() bool {
+	return ind.Compliance >= ind.Target
+}
+
+// WindowBurn is the burn rate over a window.
+type WindowBurn struct {
+	Window time.Duration
+	// Covered reports whether the logs span the whole window. BurnRate is
+	// zero if they do not.
+	Covered  bool
+	BurnRate float64
+}
+
+// Episode is a period during which an alert fired.
+type Episode struct {
+	Start, End time.Time // End is exclusive
+}
+
+// AlertStatus tells when an alert rule fired during the period.
+type AlertStatus struct {
+	Rule AlertRule
+	// Evaluated reports whether the logs cover the long window of the
+	// rule. Shorter logs do not tell whether it would fire.
+	Evaluated bool
+	// Firing reports whether the rule fires at the end of the logs.
+	Firing   bool
+	Episodes []Episode
+}
+
+// Report is the evaluation of every objective.
+type Report struct {
+	Start, End time.Time // the evaluated period; End is exclusive
+	Indicators []Indicator
+}
+
+// Report evaluates the objectives over the minutes seen by t.
+func (t *Tracker) Report() *Report {
+	if !t.seen {
+		return &Report{}
+	}
+	r := &Report{Start: unixMinute(t.first), End: unixMinute(t.last + 1)}
+
+	for i, o := range t.cfg.Objectives {
+		// Only the minutes with requests are kept: the period can span many
+		// more minutes than the logs hold entries, as a single bogus
+		// timestamp shows.
+		at := slices.Sorted(maps.Keys(t.minutes[i]))
+		total := make([]int, len(at))
+		errs := make([]int, len(at))
+		slow := make([]int, len(at))
+		for k, minute := range at {
+			c := t.minutes[i][minute]
+			total[k], errs[k], slow[k] = c.Total, c.Errors, c.Slow
+		}
+		if o.Availability > 0 {
+			r.Indicators = append(r.Indicators, t.indicator(o.Name, "availability", o.Availability/100, at, total, errs))
+		}
+		if o.LatencyTarget > 0 {
+			name := fmt.Sprintf("latency < %dms", o.LatencyMs)
+			r.Indicators = append(r.Indicators, t.indicator(o.Name, name, o.LatencyTarget/100, at, total, slow))
+		}
+	}
+	return r
+}
+
+// indicator evaluates one SLI from the request and bad counts of the
+// minutes at, in time order.
+func (t *Tracker) indicator(objective, name string, target float64, at []int64, total, bad []int) Indicator {
+	// Windows are measured in minutes from t.first; the period has n.
+	n := int(t.last-t.first) + 1
+	// Prefix sums give the counts of any window in logarithmic time, with a
+	// binary search for the first minute of the window and the end.
+	sumTotal := make([]int, len(at)+1)
+	sumBad := make([]int, len(at)+1)
+	for k := range at {
+		sumTotal[k+1] = sumTotal[k] + total[k]
+		sumBad[k+1] = sumBad[k] + bad[k]
+	}
+	index := func(offset int) int {
+		k, _ := slices.BinarySearch(at, t.first+int64(offset))
+		return k
+	}
+	budget := 1 - target
+	// burn returns the burn rate of the window of w minutes ending with
+	// minute end (exclusive).
+	burn := func(end, w int) float64 {
+		from, to := index(max(end-w, 0)), index(end)
+		requests := sumTotal[to] - sumTotal[from]
+		if requests == 0 {
+			return 0
+		}
+		return float64(sumBad[to]-sumBad[from]) / float64(requests) / budget
+	}
+
+	ind := Indicator{
+		Objective:  objective,
+		Name:       name,
+		Target:     target,
+		Total:      sumTotal[len(at)],
+		Bad:        sumBad[len(at)],
+		Compliance: 1,
+	}
+	if ind.Total > 0 {
+		ind.Compliance = 1 - float64(ind.Bad)/float64(ind.Total)
+	}
+	ind.BurnRate = burn(n, n)
+	ind.BudgetRemaining = 1 - ind.BurnRate
+
+	var windows []int
+	for _, rule := range t.cfg.Alerts {
+		windows = append(windows, rule.Long.minutes(), rule.Short.minutes())
+	}
+	slices.Sort(windows)
+	for _, w := range slices.Compact(windows) {
+		b := WindowBurn{Window: time.Duration(w) * time.Minute, Covered: w <= n}
+		if b.Covered {
+			b.BurnRate = burn(n, w)
+		}
+		ind.Current = append(ind.Current, b)
+	}
+
+	for _, rule := range t.cfg.Alerts {
+		long, short := rule.Long.minutes(), rule.Short.minutes()
+		status := AlertStatus{Rule: rule, Evaluated: long <= n}
+		firing := false
+		// The long window must be covered by the logs, or its first minutes
+		// would be judged on a short window. Both windows only change when a
+		// minute with requests enters or leaves them, so the rule is only
+		// evaluated at those ends.
+		ends := []int{long}
+		for _, minute := range at {
+			offset := int(minute - t.first)
+			ends = append(ends, offset+1, offset+1+short, offset+1+long)
+		}
+		slices.Sort(ends)
+		for _, end := range slices.Compact(ends) {
+			if end < long || end > n {
+				continue
+			}
+			fires := burn(end, long) >= rule.BurnRate && burn(end, short) >= rule.BurnRate
+			now := unixMinute(t.first + int64(end-1))
+			switch {
+			case fires && !firing:
+				status.Episodes = append(status.Episodes, Episode{Start: now})
+			case !fires && firing:
+				status.Episodes[len(status.Episodes)-1].End = now
+			}
+			firing = fires
+		}
+		if firing {
+			status.Firing = true
+			status.Episodes[len(status.Episodes)-1].End = unixMinute(t.last + 1)
+		}
+		ind.Alerts = append(ind.Alerts, status)
+	}
+	return ind
+}
+
+func unixMinute(t int64) time.Time {
+	return time.Unix(t*60, 0).UTC()
+}
@@ -0,0 +1,57 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+)
+
+// TestReportFarOutlier checks that a timestamp centuries away from the
+// others costs no more than the minutes with requests, and does not change
+// when the alerts fired.
+func TestReportFarOutlier(t *testing.T) {
+	cfg := &Config{
+		Objectives: []Objective{{Name: "products", Path: "/api/products", Availability: 99.9}},
+		Alerts:     DefaultAlerts,
+	}
+	tracker := NewTracker(cfg)
+	start := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	add := func(at time.Time, status int) {
+		entry := &logparser.LogEntry{Timestamp: at.Format(time.RFC3339Nano), Method: "GET", Path: "/api/products", Status: status}
+		if err := tracker.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for m := range 180 {
+		status := 200
+		// Every request fails for twenty minutes.
+		if m >= 100 && m < 120 {
+			status = 500
+		}
+		for range 50 {
+			add(start.Add(time.Duration(m)*time.Minute), status)
+		}
+	}
+	end := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	add(end, 200)
+
+	r := tracker.Report()
+	if !r.Start.Equal(start) || !r.End.Equal(end.Add(time.Minute)) {
+		t.Errorf("got period %s - %s, want %s - %s", r.Start, r.End, start, end.Add(time.Minute))
+	}
+	if len(r.Indicators) != 1 {
+		t.Fatalf("got %d indicators, want 1", len(r.Indicators))
+	}
+	ind := r.Indicators[0]
+	if ind.Total != 9001 || ind.Bad != 1000 {
+		t.Errorf("got %d bad of %d requests, want 1000 of 9001", ind.Bad, ind.Total)
+	}
+	// The page fires once the first failures enter its short window, and
+	// stops five minutes after the last ones.
+	page := ind.Alerts[0]
+	from, to := start.Add(100*time.Minute), start.Add(124*time.Minute)
+	if page.Firing || len(page.Episodes) != 1 || !page.Episodes[0].Start.Equal(from) || !page.Episodes[0].End.Equal(to) {
+		t.Errorf("got page episodes %+v (firing %t), want only %s - %s", page.Episodes, page.Firing, from, to)
+	}
+}
@@ -0,0 +1,170 @@
+// Package slo evaluates service level objectives per endpoint over access
+// logs: compliance, remaining error budget and burn rates.
+//
+// An objective targets the requests to one path template, optionally with
+// one method, with up to two indicators: availability (the share of
+// responses that are not 5xx) and latency (the share of responses faster
+// than a threshold). The error budget of an indicator is the share of bad
+// requests its target allows, and the burn rate over a window is how fast
+// that window spent it: 1 spends exactly the budget over any period, 14.4
+// spends 2% of a 30-day budget in one hour.
+//
+// Like the multi-window alerts of the Google SRE workbook, an alert rule
+// fires while both a long and a short window burn faster than its rate: the
+// long window ignores short spikes and the short one stops the alert soon
+// after the problem is gone.
+package slo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Objective is the SLO of one endpoint.
+type Objective struct {
+	Name string `json:"name"`
+	// Method restricts the objective to one method; empty means any.
+	Method string `json:"method,omitempty"`
+	// Path is a path template as returned by logparser.PathTemplate.
+	Path string `json:"path"`
+	// Availability is the percentage of requests that must not fail with
+	// a 5xx status, e.g. 99.9. Zero disables the indicator.
+	Availability float64 `json:"availability,omitempty"`
+	// LatencyMs and LatencyTarget require LatencyTarget percent of the
+	// requests to complete in less than LatencyMs. Zero disables the
+	// indicator.
+	LatencyMs     int     `json:"latency_ms,omitempty"`
+	LatencyTarget float64 `json:"latency_target,omitempty"`
+}
+
+// matches reports whether a request with the method and path template
+// counts towards the objective.
+func (o *Objective) matches(method, template string) bool {
+	return template == o.Path && (o.Method == "" || o.Method == method)
+}
+
+// AlertRule is a multi-window burn rate alert.
+type AlertRule struct {
+	Name     string   `json:"name"`
+	Long     Duration `json:"long"`
+	Short    Duration `json:"short"`
+	BurnRate float64  `json:"burn_rate"`
+}
+
+// DefaultAlerts are the two fast-burn rules recommended by the Google SRE
+// workbook for a 30-day budget: 2% of it spent in one hour, 5% in six.
+var DefaultAlerts = []AlertRule{
+	{Name: "page", Long: Duration(time.Hour), Short: Duration(5 * time.Minute), BurnRate: 14.4},
+	{Name: "ticket", Long: Duration(6 * time.Hour), Short: Duration(30 * time.Minute), BurnRate: 6},
+}
+
+// Config is an SLO file.
+type Config struct {
+	Objectives []Objective `json:"objectives"`
+	// Alerts defaults to DefaultAlerts.
+	Alerts []AlertRule `json:"alerts,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "1h" in JSON.
+// Alert windows are rounded to whole minutes.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5m\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// minutes returns the duration in whole minutes, at least one.
+func (d Duration) minutes() int {
+	return max(int(time.Duration(d)/time.Minute), 1)
+}
+
+// Load reads and validates an SLO file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SLO file: %w", err)
+	}
+	var cfg Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse SLO file %s: %w", path, err)
+	}
+	if cfg.Alerts == nil {
+		cfg.Alerts = DefaultAlerts
+	}
+	for i := range cfg.Objectives {
+		cfg.Objectives[i].Method = strings.ToUpper(cfg.Objectives[i].Method)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid SLO file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate reports every problem found in the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Objectives) == 0 {
+		errs = append(errs, errors.New("objectives: at least one objective is required"))
+	}
+	names := make(map[string]bool)
+	for i, o := range c.Objectives {
+		field := fmt.Sprintf("objectives[%d]", i)
+		if o.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", field))
+		} else if names[o.Name] {
+			errs = append(errs, fmt.Errorf("%s: duplicate name %q", field, o.Name))
+		}
+		names[o.Name] = true
+		if !strings.HasPrefix(o.Path, "/") {
+			errs = append(errs, fmt.Errorf("%s: path %q must start with /", field, o.Path))
+		}
+		if o.Availability == 0 && o.LatencyTarget == 0 {
+			errs = append(errs, fmt.Errorf("%s: needs availability or latency_target", field))
+		}
+		if o.Availability < 0 || o.Availability >= 100 {
+			errs = append(errs, fmt.Errorf("%s: availability must be in (0, 100), got %g", field, o.Availability))
+		}
+		if o.LatencyTarget < 0 || o.LatencyTarget >= 100 {
+			errs = append(errs, fmt.Errorf("%s: latency_target must be in (0, 100), got %g", field, o.LatencyTarget))
+		}
+		if (o.LatencyTarget > 0) != (o.LatencyMs > 0) {
+			errs = append(errs, fmt.Errorf("%s: latency_ms and latency_target go together", field))
+		}
+	}
+	for i, a := range c.Alerts {
+		field := fmt.Sprintf("alerts[%d]", i)
+		if a.Name == "" {
+			errs = append(errs, fmt.Errorf("%s: name is required", field))
+		}
+		if a.Short <= 0 || a.Long <= a.Short {
+			errs = append(errs, fmt.Errorf("%s: need 0 < short < long, got %s and %s",
+				field, time.Duration(a.Short), time.Duration(a.Long)))
+		}
+		if a.BurnRate <= 0 {
+			errs = append(errs, fmt.Errorf("%s: burn_rate must be positive, got %g", field, a.BurnRate))
+		}
+	}
+	return errors.Join(errs...)
+}
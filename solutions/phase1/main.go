@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
@@ -9,10 +10,24 @@ import (
 	"strings"
 	"time"
 
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/dashboard"
 	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/progress"
 )
 
 func main() {
+	showDashboard := flag.Bool("dashboard", false, "ワーカーの動きを標準エラーにダッシュボード表示（端末のときのみ）")
+	prof := profiling.Register(flag.CommandLine)
+	flag.Parse()
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
 	startTime := time.Now()
 
 	logRoot, err := os.OpenRoot("./logs")
@@ -36,7 +51,14 @@ func main() {
 		}
 	}
 
-	results := processFiles(logRoot, files)
+	// ダッシュボードは nil のとき何もしないので、無効なら処理は変わらない
+	var dash *dashboard.Dashboard
+	if *showDashboard && progress.IsTerminal(os.Stderr) {
+		dash = dashboard.New(os.Stderr, "phase1", len(files))
+		dash.Start(100 * time.Millisecond)
+	}
+	results := processFiles(logRoot, files, dash)
+	dash.Stop()
 
 	elapsed := time.Since(startTime)
 	printResults(results, elapsed)
@@ -44,10 +66,16 @@ func main() {
 }
 
 // processFiles はファイルを順番に処理します
-func processFiles(root *os.Root, files []string) []*logparser.Result {
+func processFiles(root *os.Root, files []string, dash *dashboard.Dashboard) []*logparser.Result {
+	// 逐次処理なのでダッシュボードのワーカーは1つだけ
+	w := dash.Worker()
+	defer w.Exit()
+
 	results := make([]*logparser.Result, 0, len(files))
 	for _, filename := range files {
+		w.Begin(root, filename)
 		result, err := processFile(root, filename)
+		w.End()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
 			continue
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
@@ -10,10 +11,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/dashboard"
 	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/progress"
 )
 
 func main() {
+	showDashboard := flag.Bool("dashboard", false, "ワーカーの動きを標準エラーにダッシュボード表示（端末のときのみ）")
+	prof := profiling.Register(flag.CommandLine)
+	flag.Parse()
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
 	startTime := time.Now()
 
 	logRoot, err := os.OpenRoot("./logs")
@@ -37,7 +52,14 @@ func main() {
 		}
 	}
 
-	results := processFiles(logRoot, files)
+	// ダッシュボードは nil のとき何もしないので、無効なら処理は変わらない
+	var dash *dashboard.Dashboard
+	if *showDashboard && progress.IsTerminal(os.Stderr) {
+		dash = dashboard.New(os.Stderr, "phase2", len(files))
+		dash.Start(100 * time.Millisecond)
+	}
+	results := processFiles(logRoot, files, dash)
+	dash.Stop()
 
 	elapsed := time.Since(startTime)
 	printResults(results, elapsed)
@@ -45,9 +67,10 @@ func main() {
 }
 
 // processFiles はファイルを並行処理します
-func processFiles(root *os.Root, files []string) []*logparser.Result {
+func processFiles(root *os.Root, files []string, dash *dashboard.Dashboard) []*logparser.Result {
 	// 結果を収集するチャネルを作成
 	resultCh := make(chan *logparser.Result, len(files))
+	dashboard.Watch(dash, "results", resultCh)
 
 	// WaitGroupで全てのgoroutineの完了を待つ
 	var wg sync.WaitGroup
@@ -57,8 +80,13 @@ func processFiles(root *os.Root, files []string) []*logparser.Result {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			// ファイルごとのgoroutineがそれぞれダッシュボードの1行になる
+			w := dash.Worker()
+			defer w.Exit()
 
+			w.Begin(root, filename)
 			result, err := processFile(root, filename)
+			w.End()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
 				return
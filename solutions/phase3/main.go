@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
@@ -11,10 +12,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/dashboard"
 	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/progress"
 )
 
 func main() {
+	showDashboard := flag.Bool("dashboard", false, "ワーカーの動きを標準エラーにダッシュボード表示（端末のときのみ）")
+	prof := profiling.Register(flag.CommandLine)
+	flag.Parse()
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
 	startTime := time.Now()
 
 	logRoot, err := os.OpenRoot("./logs")
@@ -40,7 +55,14 @@ func main() {
 
 	// ワーカー数の目安を「GOMAXPROCS (= P の数 )」にする
 	numWorkers := runtime.GOMAXPROCS(0)
-	results := processFiles(logRoot, files, numWorkers)
+	// ダッシュボードは nil のとき何もしないので、無効なら処理は変わらない
+	var dash *dashboard.Dashboard
+	if *showDashboard && progress.IsTerminal(os.Stderr) {
+		dash = dashboard.New(os.Stderr, "phase3", len(files))
+		dash.Start(100 * time.Millisecond)
+	}
+	results := processFiles(logRoot, files, numWorkers, dash)
+	dash.Stop()
 
 	elapsed := time.Since(startTime)
 	printResults(results, elapsed)
@@ -48,19 +70,26 @@ func main() {
 }
 
 // processFiles はワーカープールパターンでファイルを処理します（Go 1.25のWaitGroup.Go()を使用）
-func processFiles(root *os.Root, files []string, numWorkers int) []*logparser.Result {
+func processFiles(root *os.Root, files []string, numWorkers int, dash *dashboard.Dashboard) []*logparser.Result {
 	// ジョブ配布と結果収集のためのチャネルを作成（filesが巨大でもメモリが増えないように小さめのバッファにする）
 	jobs := make(chan string, numWorkers)
 	results := make(chan *logparser.Result, numWorkers)
+	dashboard.Watch(dash, "jobs", jobs)
+	dashboard.Watch(dash, "results", results)
 
 	// ワーカー調整のためのWaitGroupを作成
 	var wg sync.WaitGroup
 	// WaitGroup.Go()を使ってワーカーgoroutineを起動（Go 1.25+）
 	for range numWorkers {
 		wg.Go(func() {
+			w := dash.Worker()
+			defer w.Exit()
+
 			// 各ワーカーはjobsチャネルからファイルを処理
 			for filename := range jobs {
+				w.Begin(root, filename)
 				result, err := processFile(root, filename)
+				w.End()
 				if err != nil {
 					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
 					continue
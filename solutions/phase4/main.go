@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
@@ -12,6 +13,10 @@ import (
 	"time"
 
 	"github.com/bytedance/sonic"
+
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/dashboard"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/progress"
 )
 
 // OptimizedResult はPhase4専用の最適化版Result構造体
@@ -29,6 +34,18 @@ type MinimalLogEntry struct {
 }
 
 func main() {
+	showProgress := flag.Bool("progress", true, "標準エラーに進捗を表示（端末のときのみ）")
+	showDashboard := flag.Bool("dashboard", false, "ワーカーの動きを標準エラーにダッシュボード表示（端末のときのみ、進捗表示の代わり）")
+	prof := profiling.Register(flag.CommandLine)
+	flag.Parse()
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
 	startTime := time.Now()
 
 	logRoot, err := os.OpenRoot("./logs")
@@ -45,15 +62,37 @@ func main() {
 	}
 
 	files := make([]string, 0, len(entries))
+	var totalBytes int64
 	for _, entry := range entries {
 		name := entry.Name()
 		if !entry.IsDir() && strings.HasPrefix(name, "access_") && strings.HasSuffix(name, ".json") {
 			files = append(files, name)
+			if info, err := entry.Info(); err == nil {
+				totalBytes += info.Size()
+			}
 		}
 	}
 
+	// 進捗表示はリダイレクト先を汚さないよう、標準エラーが端末のときだけ有効にする
+	// counters が nil のときワーカーは何も記録しない
+	// ダッシュボードも nil のとき何もしない
+	var counters *progress.Counters
+	var dash *dashboard.Dashboard
+	switch {
+	case !progress.IsTerminal(os.Stderr):
+	case *showDashboard:
+		dash = dashboard.New(os.Stderr, "phase4", len(files))
+		dash.Start(100 * time.Millisecond)
+	case *showProgress:
+		counters = &progress.Counters{}
+	}
+	reporter := progress.Start(os.Stderr, counters,
+		progress.Total{Files: len(files), Bytes: totalBytes}, 200*time.Millisecond)
+
 	numWorkers := runtime.NumCPU()
-	results := processFiles(logRoot, files, numWorkers)
+	results := processFiles(logRoot, files, numWorkers, counters, dash)
+	reporter.Stop()
+	dash.Stop()
 
 	elapsed := time.Since(startTime)
 	printResults(results, elapsed)
@@ -61,11 +100,15 @@ func main() {
 }
 
 // processFiles は最適化されたワーカープールパターンでファイルを処理します
-func processFiles(root *os.Root, files []string, numWorkers int) []*OptimizedResult {
+// counters が nil でなければ、処理したファイル数・バイト数・行数を記録します
+// dash が nil でなければ、各ワーカーの状態とチャネルの使用量を表示します
+func processFiles(root *os.Root, files []string, numWorkers int, counters *progress.Counters, dash *dashboard.Dashboard) []*OptimizedResult {
 	//  ジョブチャネルは小さいバッファで十分
 	jobs := make(chan string, numWorkers)
 	//  ワーカーごとの集計結果を受け取る（ファイル数ではなくワーカー数）
 	results := make(chan *OptimizedResult, numWorkers)
+	dashboard.Watch(dash, "jobs", jobs)
+	dashboard.Watch(dash, "results", results)
 
 	var wg sync.WaitGroup
 
@@ -76,11 +119,18 @@ func processFiles(root *os.Root, files []string, numWorkers int) []*OptimizedRes
 			localResult := &OptimizedResult{
 				FileName: "worker-aggregate",
 			}
+			w := dash.Worker()
+			defer w.Exit()
 
 			for filename := range jobs {
-				if err := processFileInto(root, filename, localResult); err != nil {
+				counters.WorkerBusy()
+				w.Begin(root, filename)
+				if err := processFileInto(root, filename, localResult, counters); err != nil {
 					fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", filename, err)
 				}
+				w.End()
+				counters.WorkerIdle()
+				counters.AddFiles(1)
 			}
 
 			// ワーカーが処理した全ファイルの集計結果を送信
@@ -111,7 +161,7 @@ func processFiles(root *os.Root, files []string, numWorkers int) []*OptimizedRes
 
 // processFileInto は1つのログファイルを解析して既存の結果に集計します
 // ワーカーごとのローカル集計に使用され、Result作成のオーバーヘッドを削減
-func processFileInto(root *os.Root, filename string, result *OptimizedResult) error {
+func processFileInto(root *os.Root, filename string, result *OptimizedResult, counters *progress.Counters) error {
 	file, err := root.Open(filename)
 	if err != nil {
 		return err
@@ -119,13 +169,15 @@ func processFileInto(root *os.Root, filename string, result *OptimizedResult) er
 	defer file.Close()
 
 	//  256KBのバッファでI/O効率を向上
-	bufferedReader := bufio.NewReaderSize(file, 256*1024)
+	//  バイト数はバッファへの読み込み（256KB）ごとに数えるので、行ごとのループには何も足さない
+	bufferedReader := bufio.NewReaderSize(progress.Reader(file, counters), 256*1024)
 	//  sonic JSONデコーダーを使用（標準ライブラリより2-5倍高速）
 	decoder := sonic.ConfigDefault.NewDecoder(bufferedReader)
 
 	//  最小フィールドのみパースしてパース時間とメモリを削減
 	// 必要なのは status のみなので、他の7フィールド(timestamp, method, path, etc.)は無視
 	var entry MinimalLogEntry
+	counted := result.TotalCount
 	for decoder.More() {
 		if err := decoder.Decode(&entry); err != nil {
 			continue
@@ -133,6 +185,8 @@ func processFileInto(root *os.Root, filename string, result *OptimizedResult) er
 		result.TotalCount++
 		result.StatusCounts[entry.Status]++
 	}
+	//  行数はファイルの終わりにまとめて加算する
+	counters.AddLines(int64(result.TotalCount - counted))
 
 	return nil
 }
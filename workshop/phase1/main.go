@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
@@ -10,9 +11,20 @@ import (
 	"time"
 
 	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
 )
 
 func main() {
+	prof := profiling.Register(flag.CommandLine)
+	flag.Parse()
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
 	startTime := time.Now()
 
 	logRoot, err := os.OpenRoot("./logs")
@@ -2,30 +2,32 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
 	"runtime"
-	"runtime/pprof"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
 )
 
 func main() {
-	startTime := time.Now()
+	prof := profiling.Register(flag.CommandLine)
+	flag.Parse()
 
-	f, err := os.Create("cpu.prof")
+	stopProfiling, err := prof.Start()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating CPU profile file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
 		os.Exit(1)
 	}
-	defer f.Close()
-	pprof.StartCPUProfile(f)
-	defer pprof.StopCPUProfile()
+	defer stopProfiling()
+
+	startTime := time.Now()
 
 	logRoot, err := os.OpenRoot("./logs")
 	if err != nil {
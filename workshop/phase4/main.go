@@ -5,6 +5,7 @@ import (
 	jsontext "encoding/json/jsontext"
 	jsonv2 "encoding/json/v2"
 
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
@@ -16,9 +17,20 @@ import (
 	"time"
 
 	"github.com/nnnkkk7/go-concurrency-workshop/pkg/logparser"
+	"github.com/nnnkkk7/go-concurrency-workshop/pkg/profiling"
 )
 
 func main() {
+	prof := profiling.Register(flag.CommandLine)
+	flag.Parse()
+
+	stopProfiling, err := prof.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting profiling: %v\n", err)
+		os.Exit(1)
+	}
+	defer stopProfiling()
+
 	startTime := time.Now()
 
 	logRoot, err := os.OpenRoot("./logs")